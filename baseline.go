@@ -0,0 +1,120 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var baselineDriftDesc = prometheus.NewDesc(
+	"iptables_baseline_drift_rules",
+	"iptables_exporter: Number of rules only found in either the approved baseline or the running ruleset.",
+	[]string{"ip_family"},
+	nil,
+)
+
+// baselineDiff is the answer of /api/v1/diff.
+type baselineDiff struct {
+	Family iptables.Family `json:"ip_family"`
+	// DriftRules is the number of rules only found in one of the rulesets.
+	DriftRules int                  `json:"drift_rules"`
+	Chains     []iptables.ChainDiff `json:"chains"`
+}
+
+func newBaselineDiff(family iptables.Family, baseline, running iptables.Tables) *baselineDiff {
+	diffs := iptables.Diff(baseline, running)
+	if diffs == nil {
+		diffs = []iptables.ChainDiff{}
+	}
+	return &baselineDiff{Family: family, DriftRules: driftRules(diffs), Chains: diffs}
+}
+
+// collectBaselineDrift compares the running ruleset of family with its
+// approved baseline. Unlike the saved ruleset, every table counts: a table
+// the baseline doesn't have is drift.
+func (c *collector) collectBaselineDrift(metricChan chan<- prometheus.Metric, family iptables.Family, running iptables.Tables) {
+	path, ok := c.baselines[family]
+	if !ok {
+		return
+	}
+	baseline, err := c.readSavedRules(path)
+	if err != nil {
+		log.Errorf("Reading %s baseline %s: %s", family, path, err)
+		return
+	}
+	drift := driftRules(iptables.Diff(baseline, running))
+	metricChan <- prometheus.MustNewConstMetric(baselineDriftDesc, prometheus.GaugeValue, float64(drift), string(family))
+}
+
+// diffHandler answers /api/v1/diff with the difference between the
+// running ruleset of the family given by the ip_family parameter and a
+// baseline: the iptables-save dump posted, or the configured baseline file
+// on GET.
+func diffHandler(c *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		family := iptables.IPv4
+		if f := r.URL.Query().Get("ip_family"); f != "" {
+			family = iptables.Family(f)
+		}
+		if family != iptables.IPv4 && family != iptables.IPv6 {
+			http.Error(w, "unknown ip_family "+strconv.Quote(string(family)), http.StatusBadRequest)
+			return
+		}
+		var baseline iptables.Tables
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			path, ok := c.baselines[family]
+			if !ok {
+				http.Error(w, "no "+string(family)+" baseline configured", http.StatusNotFound)
+				return
+			}
+			if baseline, err = c.readSavedRules(path); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPost:
+			if baseline, err = c.parseSavedRules(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		running, _, err := c.getTables(family, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newBaselineDiff(family, baseline, running))
+	}
+}
+
+func newBaselineFlags(app *kingpin.Application) familyFileFlags {
+	return familyFileFlags{
+		v4: app.Flag("iptables.baseline", "File holding the approved IPv4 ruleset, in iptables-save format, to export how many rules differ from it and serve the difference at /api/v1/diff.").String(),
+		v6: app.Flag("iptables.baseline-v6", "File holding the approved IPv6 ruleset.").String(),
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	cacheAgeDesc = prometheus.NewDesc(
+		"iptables_cache_age_seconds",
+		"iptables_exporter: Age of the collection the scrape was served from.",
+		nil,
+		nil,
+	)
+
+	cacheHitsDesc = prometheus.NewDesc(
+		"iptables_cache_hits_total",
+		"iptables_exporter: Total scrapes served from a cached collection.",
+		nil,
+		nil,
+	)
+
+	cacheMissesDesc = prometheus.NewDesc(
+		"iptables_cache_misses_total",
+		"iptables_exporter: Total scrapes that collected iptables as the cache was empty or expired.",
+		nil,
+		nil,
+	)
+
+	cacheRulesDesc = prometheus.NewDesc(
+		"iptables_cache_rules",
+		"iptables_exporter: Number of rules held by the cache.",
+		[]string{"ip_family"},
+		nil,
+	)
+
+	lastScrapeDesc = prometheus.NewDesc(
+		"iptables_last_scrape_timestamp_seconds",
+		"iptables_exporter: Time of the collection the scrape was served from.",
+		nil,
+		nil,
+	)
+
+	cacheSizeDesc = prometheus.NewDesc(
+		"iptables_cache_size_bytes",
+		"iptables_exporter: Approximate size of the text of the chains and rules held by the cache.",
+		[]string{"ip_family"},
+		nil,
+	)
+)
+
+// tablesCache keeps the last successful collection for ttl, serving the
+// scrapes arriving in the meantime, e.g. of several Prometheus servers. If
+// interval is positive the collection is instead refreshed in the background
+// every interval by refreshTables and never expires.
+type tablesCache struct {
+	ttl      time.Duration
+	interval time.Duration
+
+	mu        sync.Mutex
+	collected time.Time
+	families  map[iptables.Family]iptables.Tables
+	results   []familyResult
+	hits      uint64
+	misses    uint64
+}
+
+// familyResult is what collecting a family told the observer of
+// getAllTables, replayed on cache hits.
+type familyResult struct {
+	family   iptables.Family
+	duration time.Duration
+	stats    iptables.ParseStats
+	err      error
+}
+
+func newTablesCache(ttl, interval time.Duration) *tablesCache {
+	if ttl <= 0 && interval <= 0 {
+		return nil
+	}
+	return &tablesCache{ttl: ttl, interval: interval}
+}
+
+// getCachedTables is getAllTables, but serves the cached collection while it
+// is younger than the cache's ttl, or any collection if it is refreshed in the
+// background. hit is true if it did. Concurrent scrapes wait for a collection
+// in progress rather than running their own.
+func (c *collector) getCachedTables(trace *scrapeTrace, observe func(family iptables.Family, duration time.Duration, stats iptables.ParseStats, err error)) (families map[iptables.Family]iptables.Tables, hit bool, err error) {
+	cache := c.cache
+	if cache == nil {
+		families, err = c.getAllTables(trace, observe)
+		return families, false, err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.families != nil && (cache.interval > 0 || time.Since(cache.collected) < cache.ttl) {
+		cache.hits++
+		trace.cache("hit")
+		if observe != nil {
+			for _, r := range cache.results {
+				observe(r.family, r.duration, r.stats, r.err)
+			}
+		}
+		return cache.families, true, nil
+	}
+	cache.misses++
+	trace.cache("miss")
+	var results []familyResult
+	collected := time.Now()
+	families, err = c.getAllTables(trace, func(family iptables.Family, duration time.Duration, stats iptables.ParseStats, err error) {
+		results = append(results, familyResult{family, duration, stats, err})
+		if observe != nil {
+			observe(family, duration, stats, err)
+		}
+	})
+	if err != nil {
+		// Failures aren't cached, so the next scrape tries again.
+		cache.families = nil
+		return nil, false, err
+	}
+	cache.collected = collected
+	cache.families = families
+	cache.results = results
+	return families, false, nil
+}
+
+// refreshTables collects the tables of c into its cache every interval of the
+// cache, starting right away, so scrapes never wait for the save commands
+// but for the first one. A failed collection keeps the previous one.
+func refreshTables(c *collector) {
+	cache := c.cache
+	for {
+		var results []familyResult
+		collected := time.Now()
+		families, err := c.getAllTables(nil, func(family iptables.Family, duration time.Duration, stats iptables.ParseStats, err error) {
+			results = append(results, familyResult{family, duration, stats, err})
+		})
+		c.health.record(err)
+		if err != nil {
+			log.Errorf("Refreshing the tables in the background: %s", err)
+		} else {
+			cache.mu.Lock()
+			cache.collected = collected
+			cache.families = families
+			cache.results = results
+			cache.mu.Unlock()
+			for _, o := range c.observers {
+				o.observe(collected, families)
+			}
+		}
+		time.Sleep(cache.interval - time.Since(collected))
+	}
+}
+
+// age returns the age of the cached collection, false if there is none.
+func (cache *tablesCache) age() (time.Duration, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.families == nil {
+		return 0, false
+	}
+	return time.Since(cache.collected), true
+}
+
+// collectCache exports the state of the cache, if enabled.
+func (c *collector) collectCache(metricChan chan<- prometheus.Metric) {
+	cache := c.cache
+	if cache == nil {
+		return
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	metricChan <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(cache.hits))
+	metricChan <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(cache.misses))
+	if cache.families == nil {
+		return
+	}
+	metricChan <- prometheus.MustNewConstMetric(cacheAgeDesc, prometheus.GaugeValue, time.Since(cache.collected).Seconds())
+	metricChan <- prometheus.MustNewConstMetric(lastScrapeDesc, prometheus.GaugeValue, float64(cache.collected.UnixNano())/1e9)
+	for family, tables := range cache.families {
+		rules, size := tablesSize(tables)
+		metricChan <- prometheus.MustNewConstMetric(cacheRulesDesc, prometheus.GaugeValue, float64(rules), string(family))
+		metricChan <- prometheus.MustNewConstMetric(cacheSizeDesc, prometheus.GaugeValue, float64(size), string(family))
+	}
+}
+
+// tablesSize returns the number of rules of tables and the length of the
+// names of their chains and the text and labels of their rules.
+func tablesSize(tables iptables.Tables) (rules, size int) {
+	for _, table := range tables {
+		for name, chain := range table {
+			size += len(name) + len(chain.Policy)
+			for _, rule := range chain.Rules {
+				rules++
+				size += len(rule.Text) + len(rule.Rule)
+			}
+		}
+	}
+	return rules, size
+}
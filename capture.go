@@ -0,0 +1,169 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// captureConfig is a capture regexp applying to the rules of one table, or
+// of one of its chains, instead of --iptables.capture-re.
+type captureConfig struct {
+	Table string `yaml:"table"`
+	// Chain, if set, restricts the regexp to a chain of the table.
+	Chain string `yaml:"chain"`
+	Regex string `yaml:"regex"`
+}
+
+// chainCaptures are the capture regexps of tables and chains, by table and
+// chain, with an empty chain for the whole table.
+type chainCaptures map[[2]string]*regexp.Regexp
+
+// newChainCaptures compiles the captures section of the configuration
+// file, which config.validate has checked.
+func newChainCaptures(cfgs []captureConfig) (chainCaptures, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	captures := make(chainCaptures)
+	for _, cfg := range cfgs {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, err
+		}
+		captures[[2]string{cfg.Table, cfg.Chain}] = re
+	}
+	return captures, nil
+}
+
+// regexp returns the capture regexp of a chain: its own, else its table's,
+// else fallback.
+func (c chainCaptures) regexp(table, chain string, fallback *regexp.Regexp) *regexp.Regexp {
+	if re, ok := c[[2]string{table, chain}]; ok {
+		return re
+	}
+	if re, ok := c[[2]string{table, ""}]; ok {
+		return re
+	}
+	return fallback
+}
+
+// Policies for rules the capture regexp doesn't match.
+const (
+	// unmatchedSkip leaves them out.
+	unmatchedSkip = "skip"
+	// unmatchedFull exports them with their full text as rule label.
+	unmatchedFull = "full"
+	// unmatchedBucket exports them summed up with unmatchedRule as rule
+	// label.
+	unmatchedBucket = "bucket"
+)
+
+// unmatchedRule is the rule label of unmatched rules with unmatchedBucket.
+const unmatchedRule = "_unmatched"
+
+// apply computes the Rule of every rule of tables, parsed without capture,
+// with the capture regexp of its chain. Rules it doesn't match are handled
+// according to unmatched and counted in stats.
+func (c chainCaptures) apply(tables iptables.Tables, fallback *regexp.Regexp, unmatched string, stats *iptables.ParseStats) {
+	for tableName, table := range tables {
+		for chainName, chain := range table {
+			re := c.regexp(tableName, chainName, fallback)
+			rules := chain.Rules[:0]
+			for _, rule := range chain.Rules {
+				label, ok := iptables.CaptureLabel(re, rule.Text)
+				if !ok {
+					stats.RulesNotCaptured++
+					switch unmatched {
+					case unmatchedFull:
+						label = rule.Text
+					case unmatchedBucket:
+						label = unmatchedRule
+					default:
+						continue
+					}
+				}
+				rule.Rule = label
+				rules = append(rules, rule)
+			}
+			chain.Rules = rules
+			table[chainName] = chain
+		}
+	}
+}
+
+// captureGroupLabeler exports the text captured by the named groups of the
+// capture regexps as labels named after the groups, e.g. "service" for
+// (?P<service>\w+). Rules a regexp has no such group for, or doesn't
+// match, have empty values.
+type captureGroupLabeler struct {
+	fallback *regexp.Regexp
+	captures chainCaptures
+	names    []string
+}
+
+// newCaptureGroupLabeler returns the labeler of the named groups of
+// fallback, given to --iptables.capture-re, and of captures, or nil if they
+// have none.
+func newCaptureGroupLabeler(fallback *regexp.Regexp, captures chainCaptures) (*captureGroupLabeler, error) {
+	regexps := []*regexp.Regexp{fallback}
+	keys := make([][2]string, 0, len(captures))
+	for key := range captures {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		regexps = append(regexps, captures[key])
+	}
+	l := &captureGroupLabeler{fallback: fallback, captures: captures}
+	seen := make(map[string]bool)
+	for _, re := range regexps {
+		for _, name := range re.SubexpNames() {
+			if name == "" || seen[name] {
+				continue
+			}
+			if !model.LabelName(name).IsValid() || reservedLabels[name] {
+				return nil, fmt.Errorf("group %q of %s can't be used as a label name", name, re)
+			}
+			seen[name] = true
+			l.names = append(l.names, name)
+		}
+	}
+	if len(l.names) == 0 {
+		return nil, nil
+	}
+	return l, nil
+}
+
+func (l *captureGroupLabeler) labelNames() []string {
+	return l.names
+}
+
+func (l *captureGroupLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	groups := iptables.CaptureGroups(l.captures.regexp(table, chain, l.fallback), rule.Text)
+	values := make([]string, len(l.names))
+	for i, name := range l.names {
+		values[i] = groups[name]
+	}
+	return values, false
+}
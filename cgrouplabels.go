@@ -0,0 +1,58 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// systemdUnitSuffixes are the unit types that own cgroups.
+var systemdUnitSuffixes = []string{".service", ".scope", ".slice"}
+
+// cgroupLabeler exports the systemd unit owning the cgroup matched by
+// -m cgroup --path as "unit" label.
+type cgroupLabeler struct{}
+
+func (cgroupLabeler) labelNames() []string {
+	return []string{"unit"}
+}
+
+func (cgroupLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	option, ok := rule.Spec().MatchOption("cgroup", "--path")
+	if !ok || len(option.Values) == 0 {
+		return []string{""}, false
+	}
+	unit := cgroupUnit(option.Values[0])
+	if option.Negated && unit != "" {
+		unit = "!" + unit
+	}
+	return []string{unit}, false
+}
+
+// cgroupUnit returns the innermost systemd unit of a cgroup v2 path such as
+// system.slice/nginx.service/worker, or "" if it isn't managed by systemd.
+func cgroupUnit(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		for _, suffix := range systemdUnitSuffixes {
+			if strings.HasSuffix(parts[i], suffix) {
+				return parts[i]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// chainGraph is the jump and goto topology of the chains of a ruleset,
+// answered by /api/v1/graph.
+type chainGraph struct {
+	Family iptables.Family `json:"ip_family"`
+	Tables []tableGraph    `json:"tables"`
+}
+
+type tableGraph struct {
+	Table  string      `json:"table"`
+	Chains []graphNode `json:"chains"`
+	Edges  []graphEdge `json:"edges"`
+}
+
+type graphNode struct {
+	Chain string `json:"chain"`
+	// Policy is only set for built-in chains.
+	Policy string `json:"policy,omitempty"`
+	Rules  int    `json:"rules"`
+}
+
+// graphEdge sums the rules passing packets from one chain to another.
+type graphEdge struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	VerdictKind string `json:"verdict_kind"`
+	Rules       int    `json:"rules"`
+	Packets     uint64 `json:"packets"`
+	Bytes       uint64 `json:"bytes"`
+}
+
+// newChainGraph builds the graph of the tables selected by names, or all
+// tables if there are none.
+func newChainGraph(family iptables.Family, tables iptables.Tables, names []string) *chainGraph {
+	if len(names) == 0 {
+		for name := range tables {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	g := &chainGraph{Family: family, Tables: []tableGraph{}}
+	for _, name := range names {
+		table, ok := tables[name]
+		if !ok {
+			continue
+		}
+		tg := tableGraph{Table: name, Chains: []graphNode{}, Edges: []graphEdge{}}
+		for chainName, chain := range table {
+			node := graphNode{Chain: chainName, Rules: len(chain.Rules)}
+			if chain.Policy != "-" {
+				node.Policy = chain.Policy
+			}
+			tg.Chains = append(tg.Chains, node)
+		}
+		sort.Slice(tg.Chains, func(i, j int) bool { return tg.Chains[i].Chain < tg.Chains[j].Chain })
+		// Jumps are sorted by source and target, so rules of the same edge
+		// are adjacent but for goto and jump rules between the same chains.
+		index := make(map[graphEdge]int)
+		for _, jump := range table.Jumps() {
+			key := graphEdge{From: jump.From, To: jump.To, VerdictKind: "jump"}
+			if jump.Goto {
+				key.VerdictKind = "goto"
+			}
+			i, ok := index[key]
+			if !ok {
+				i = len(tg.Edges)
+				index[key] = i
+				tg.Edges = append(tg.Edges, key)
+			}
+			tg.Edges[i].Rules++
+			tg.Edges[i].Packets += jump.Packets
+			tg.Edges[i].Bytes += jump.Bytes
+		}
+		g.Tables = append(g.Tables, tg)
+	}
+	return g
+}
+
+// writeDOT writes the graph in the Graphviz DOT language, a cluster per
+// table, edges labelled with their packet counts and goto edges dashed.
+func (g *chainGraph) writeDOT(w io.Writer) {
+	fmt.Fprintf(w, "digraph %s {\n", strconv.Quote("iptables_"+string(g.Family)))
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	for _, tg := range g.Tables {
+		fmt.Fprintf(w, "\tsubgraph %s {\n", strconv.Quote("cluster_"+tg.Table))
+		fmt.Fprintf(w, "\t\tlabel=%s;\n", strconv.Quote(tg.Table))
+		for _, n := range tg.Chains {
+			label := n.Chain
+			style := ""
+			if n.Policy != "" {
+				label += "\npolicy " + n.Policy
+				style = ", style=bold"
+			}
+			fmt.Fprintf(w, "\t\t%s [label=%s%s];\n", strconv.Quote(tg.Table+"/"+n.Chain), strconv.Quote(label), style)
+		}
+		for _, e := range tg.Edges {
+			style := ""
+			if e.VerdictKind == "goto" {
+				style = ", style=dashed"
+			}
+			fmt.Fprintf(w, "\t\t%s -> %s [label=%s%s];\n",
+				strconv.Quote(tg.Table+"/"+e.From),
+				strconv.Quote(tg.Table+"/"+e.To),
+				strconv.Quote(strconv.FormatUint(e.Packets, 10)+" pkts"),
+				style)
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// graphHandler answers /api/v1/graph with the chain graph of the family
+// given by the ip_family parameter, as JSON or, with format=dot, as DOT. The
+// table parameter, which can be repeated, selects tables.
+func graphHandler(c *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		family := iptables.IPv4
+		if f := q.Get("ip_family"); f != "" {
+			family = iptables.Family(f)
+		}
+		if family != iptables.IPv4 && family != iptables.IPv6 {
+			http.Error(w, "unknown ip_family "+strconv.Quote(string(family)), http.StatusBadRequest)
+			return
+		}
+		format := q.Get("format")
+		if format != "" && format != "json" && format != "dot" {
+			http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+			return
+		}
+		families, _, err := c.getCachedTables(nil, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tables, ok := families[family]
+		if !ok {
+			http.Error(w, string(family)+" isn't collected", http.StatusNotFound)
+			return
+		}
+		g := newChainGraph(family, tables, q["table"])
+		if format == "dot" {
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			g.writeDOT(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g)
+	}
+}
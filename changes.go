@@ -0,0 +1,119 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var rulesetChanges = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "iptables_ruleset_changes_total",
+		Help: "iptables_exporter: Total ruleset changes observed between collections.",
+	},
+	[]string{"ip_family"},
+)
+
+func init() {
+	prometheus.MustRegister(rulesetChanges)
+}
+
+// rulesetChange describes a ruleset modification seen between two
+// collections of a family.
+type rulesetChange struct {
+	Time         time.Time
+	Family       iptables.Family
+	Hash         string
+	PreviousHash string
+	Old, New     iptables.Tables
+	Diff         []iptables.ChainDiff
+}
+
+// changeListener is notified of every ruleset change.
+type changeListener interface {
+	rulesetChanged(change rulesetChange)
+}
+
+// changeDetector compares every collection to the previous one and notifies
+// listeners if the ruleset of a family changed. The first collection of a
+// family only sets the baseline.
+type changeDetector struct {
+	listeners []changeListener
+
+	mu     sync.Mutex
+	hashes map[iptables.Family]string
+	tables map[iptables.Family]iptables.Tables
+}
+
+func newChangeDetector(listeners []changeListener) *changeDetector {
+	return &changeDetector{
+		listeners: listeners,
+		hashes:    make(map[iptables.Family]string),
+		tables:    make(map[iptables.Family]iptables.Tables),
+	}
+}
+
+func (d *changeDetector) observe(now time.Time, families map[iptables.Family]iptables.Tables) {
+	d.mu.Lock()
+	var changes []rulesetChange
+	for family, tables := range families {
+		hash := tables.Hash()
+		previous, known := d.hashes[family]
+		if known && hash != previous {
+			old := d.tables[family]
+			changes = append(changes, rulesetChange{
+				Time:         now,
+				Family:       family,
+				Hash:         hash,
+				PreviousHash: previous,
+				Old:          old,
+				New:          tables,
+				Diff:         iptables.Diff(old, tables),
+			})
+		}
+		d.hashes[family] = hash
+		d.tables[family] = tables
+	}
+	d.mu.Unlock()
+
+	for _, change := range changes {
+		log.Infof("Ruleset of %s changed in %d chains", change.Family, len(change.Diff))
+		rulesetChanges.WithLabelValues(string(change.Family)).Inc()
+		for _, l := range d.listeners {
+			l.rulesetChanged(change)
+		}
+	}
+}
+
+// watchChanges collects iptables every interval so changes are detected
+// even if nothing scrapes the exporter.
+func watchChanges(c *collector, interval time.Duration) {
+	for range time.Tick(interval) {
+		families, err := c.getAllTables(nil, nil)
+		c.health.record(err)
+		if err != nil {
+			log.Errorf("Checking for ruleset changes: %s", err)
+			continue
+		}
+		for _, o := range c.observers {
+			o.observe(time.Now(), families)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// collectorSet holds a registry per collector that scrapes can select with
+// collect[] parameters, like node_exporter.
+type collectorSet struct {
+	registries map[string]*prometheus.Registry
+	// gatherers are the collectors gathering their metrics themselves
+	gatherers map[string]prometheus.Gatherer
+	// families holds the collectors of the iptables and ip6tables
+	// collectors, which collect all IP families at once. Its series are
+	// told apart by their ip_family or collector label.
+	families *prometheus.Registry
+	// familyNames are the names of the collectors of the families
+	// registered with familyRegisterer.
+	familyNames map[string]bool
+}
+
+func newCollectorSet() *collectorSet {
+	return &collectorSet{
+		registries:  make(map[string]*prometheus.Registry),
+		gatherers:   make(map[string]prometheus.Gatherer),
+		families:    prometheus.NewRegistry(),
+		familyNames: make(map[string]bool),
+	}
+}
+
+// familyRegisterer returns the registerer of collectors collecting the
+// tables of families, selected as the iptables and ip6tables collectors.
+func (s *collectorSet) familyRegisterer(families []iptables.Family) prometheus.Registerer {
+	for _, family := range families {
+		s.familyNames[familyCollector(family)] = true
+	}
+	return s.families
+}
+
+// registerer returns the registerer of the named collector.
+func (s *collectorSet) registerer(name string) prometheus.Registerer {
+	r, ok := s.registries[name]
+	if !ok {
+		r = prometheus.NewRegistry()
+		s.registries[name] = r
+	}
+	return r
+}
+
+// addGatherer adds a collector gathering its metrics itself, e.g. because
+// they change from scrape to scrape.
+func (s *collectorSet) addGatherer(name string, g prometheus.Gatherer) {
+	s.gatherers[name] = g
+}
+
+// names returns the sorted names of the collectors.
+func (s *collectorSet) names() []string {
+	names := make([]string, 0, len(s.registries)+len(s.gatherers)+len(s.familyNames))
+	for name := range s.registries {
+		names = append(names, name)
+	}
+	for name := range s.familyNames {
+		names = append(names, name)
+	}
+	for name := range s.gatherers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gatherer returns the gatherer of the default registry, holding the
+// exporter's own metrics, and of the named collectors, or of all
+// collectors if names is empty.
+func (s *collectorSet) gatherer(names []string) (prometheus.Gatherer, error) {
+	gatherers, err := s.collectorGatherers(names)
+	if err != nil {
+		return nil, err
+	}
+	return append(prometheus.Gatherers{prometheus.DefaultGatherer}, gatherers...), nil
+}
+
+// collectorGatherers returns the gatherers of the named collectors, or of
+// all collectors if names is empty.
+func (s *collectorSet) collectorGatherers(names []string) (prometheus.Gatherers, error) {
+	if len(names) == 0 {
+		names = s.names()
+	}
+	var gatherers prometheus.Gatherers
+	seen := make(map[string]bool)
+	selected := make(map[string]bool)
+	for _, name := range names {
+		var g prometheus.Gatherer
+		if s.familyNames[name] {
+			selected[name] = true
+			continue
+		}
+		if r, ok := s.registries[name]; ok {
+			g = r
+		} else if g, ok = s.gatherers[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q, expected one of %v", name, s.names())
+		}
+		if !seen[name] {
+			gatherers = append(gatherers, g)
+			seen[name] = true
+		}
+	}
+	if len(selected) > 0 {
+		// Gathered once however many families are selected, so their
+		// collectors run once per scrape.
+		gatherers = append(gatherers, familyGatherer{s.families, selected})
+	}
+	return gatherers, nil
+}
+
+// familyGatherer gathers the series of the selected family collectors from
+// a registry collecting all families.
+type familyGatherer struct {
+	prometheus.Gatherer
+	collectors map[string]bool
+}
+
+func (g familyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	result := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if g.selected(m) {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) > 0 {
+			mf.Metric = metrics
+			result = append(result, mf)
+		}
+	}
+	return result, err
+}
+
+// selected reports whether m belongs to a selected collector: series of an
+// IP family to its collector, those of collector metrics to the collector
+// named, and all other series, e.g. of rules merged across families, to
+// every collector.
+func (g familyGatherer) selected(m *dto.Metric) bool {
+	for _, label := range m.Label {
+		switch label.GetName() {
+		case "ip_family":
+			switch family := iptables.Family(label.GetValue()); family {
+			case iptables.IPv4, iptables.IPv6:
+				return g.collectors[familyCollector(family)]
+			}
+		case "collector":
+			if name := label.GetValue(); name == familyCollector(iptables.IPv4) || name == familyCollector(iptables.IPv6) {
+				return g.collectors[name]
+			}
+		}
+	}
+	return true
+}
+
+// handler answers metrics requests with the collectors selected by the
+// collect[] parameters, or all of them. Responses are not compressed, that is
+// left to compression.handler.
+func (s *collectorSet) handler() http.Handler {
+	all, _ := s.gatherer(nil)
+	allHandler := promhttp.HandlerFor(all, promhttp.HandlerOpts{DisableCompression: true})
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()["collect[]"]
+		if len(names) == 0 {
+			allHandler.ServeHTTP(w, r)
+			return
+		}
+		g, err := s.gatherer(names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		promhttp.HandlerFor(g, promhttp.HandlerOpts{DisableCompression: true}).ServeHTTP(w, r)
+	}))
+}
+
+var (
+	collectorDurationDesc = prometheus.NewDesc(
+		"iptables_scrape_collector_duration_seconds",
+		"iptables_exporter: Duration of a collector scrape.",
+		[]string{"collector"},
+		nil,
+	)
+
+	collectorSuccessDesc = prometheus.NewDesc(
+		"iptables_scrape_collector_success",
+		"iptables_exporter: Whether a collector succeeded.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+// familyCollector returns the name of the collector of an IP family.
+func familyCollector(family iptables.Family) string {
+	if family == iptables.IPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// collectorMetrics exports the duration and success of a collector.
+func collectorMetrics(metricChan chan<- prometheus.Metric, name string, duration time.Duration, err error) {
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	metricChan <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+	metricChan <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, name)
+}
+
+// updater is a collector that can fail, like node_exporter's collectors.
+type updater interface {
+	Describe(descChan chan<- *prometheus.Desc)
+	update(metricChan chan<- prometheus.Metric) error
+}
+
+// instrumentedCollector exports the duration and success of an updater
+// along with its metrics, logging its failures.
+type instrumentedCollector struct {
+	name string
+	updater
+}
+
+func (c instrumentedCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- collectorDurationDesc
+	descChan <- collectorSuccessDesc
+	c.updater.Describe(descChan)
+}
+
+func (c instrumentedCollector) Collect(metricChan chan<- prometheus.Metric) {
+	start := time.Now()
+	err := c.update(metricChan)
+	if err != nil {
+		log.Errorf("Collector %s failed: %s", c.name, err)
+	}
+	collectorMetrics(metricChan, c.name, time.Since(start), err)
+}
+
+// collectorFlags are the values of the --collector.* flags.
+type collectorFlags struct {
+	iptables, ip6tables, nflog, conntrack, ipset *bool
+	ebtables, arptables, nftables, lxc, netns    *bool
+	backend                                      *string
+}
+
+func newCollectorFlags(app *kingpin.Application) collectorFlags {
+	return collectorFlags{
+		iptables:  app.Flag("collector.iptables", "Collect the IPv4 tables with iptables-save.").Default("true").Bool(),
+		ip6tables: app.Flag("collector.ip6tables", "Collect the IPv6 tables with ip6tables-save.").Default("true").Bool(),
+		backend:   app.Flag("collector.backend", "How to read the tables of the local host: exec runs iptables-save and ip6tables-save, netlink reads the legacy tables from the kernel like libiptc, running the save commands for families without legacy tables.").Default(backendExec).Enum(backendExec, backendNetlink),
+		nflog:     app.Flag("collector.nflog", "Export NFLOG group statistics from /proc/net/netfilter.").Bool(),
+		conntrack: app.Flag("collector.conntrack", "Export the size and per-CPU statistics of the connection tracking table from /proc/sys/net/netfilter and /proc/net/stat.").Bool(),
+		ipset:     app.Flag("collector.ipset", "Export the entries, maximum entries, memory size and references of every IP set, as reported by ipset.").Bool(),
+		ebtables:  app.Flag("collector.ebtables", "Export the counters of the bridge firewall from ebtables-save.").Bool(),
+		arptables: app.Flag("collector.arptables", "Export the counters of the ARP firewall from arptables-save.").Bool(),
+		nftables:  app.Flag("collector.nftables", "Collect the native nftables ruleset with nft -j list ruleset.").Bool(),
+		lxc:       app.Flag("collector.lxc", "Collect the rulesets of the running LXC, LXD and Incus containers, found in --path.procfs, in their network namespaces with nsenter, labelled with their container name.").Bool(),
+		netns:     app.Flag("collector.netns", "Collect the rulesets of all network namespaces of the host, named ones in --path.netns and those of the processes in --path.procfs, with nsenter, labelled with netns and container.").Bool(),
+	}
+}
+
+// families returns the IP families of the enabled iptables collectors.
+func (f collectorFlags) families() []iptables.Family {
+	var families []iptables.Family
+	if *f.iptables {
+		families = append(families, iptables.IPv4)
+	}
+	if *f.ip6tables {
+		families = append(families, iptables.IPv6)
+	}
+	return families
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// familiesCollector exports a rule series of every IP family and the
+// collector metrics of their collectors, like the local collector.
+type familiesCollector struct{}
+
+var testRuleDesc = prometheus.NewDesc("iptables_rule_packets_total", "Packets.", []string{"ip_family"}, nil)
+
+func (familiesCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- testRuleDesc
+	descChan <- collectorDurationDesc
+	descChan <- collectorSuccessDesc
+	descChan <- scrapeSuccessDesc
+}
+
+func (familiesCollector) Collect(metricChan chan<- prometheus.Metric) {
+	for _, family := range []iptables.Family{iptables.IPv4, iptables.IPv6, anyFamily} {
+		metricChan <- prometheus.MustNewConstMetric(testRuleDesc, prometheus.CounterValue, 1, string(family))
+	}
+	collectorMetrics(metricChan, familyCollector(iptables.IPv4), time.Second, nil)
+	collectorMetrics(metricChan, familyCollector(iptables.IPv6), time.Second, nil)
+	metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
+}
+
+func TestCollectorSetFamilies(t *testing.T) {
+	s := newCollectorSet()
+	s.familyRegisterer([]iptables.Family{iptables.IPv4, iptables.IPv6}).MustRegister(familiesCollector{})
+	s.registerer("nflog").MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "iptables_nflog_test", Help: "Test."}))
+	h := s.handler()
+	for _, tc := range []struct {
+		query    string
+		code     int
+		contains []string
+		ignores  []string
+	}{
+		{
+			query: "collect[]=ip6tables",
+			code:  http.StatusOK,
+			contains: []string{
+				`iptables_rule_packets_total{ip_family="ipv6"} 1`,
+				`iptables_rule_packets_total{ip_family="any"} 1`,
+				`iptables_scrape_collector_success{collector="ip6tables"} 1`,
+				`iptables_scrape_success 1`,
+			},
+			ignores: []string{`ip_family="ipv4"`, `collector="iptables"`, `iptables_nflog_test`},
+		},
+		{
+			query: "collect[]=iptables",
+			code:  http.StatusOK,
+			contains: []string{
+				`iptables_rule_packets_total{ip_family="ipv4"} 1`,
+				`iptables_scrape_collector_success{collector="iptables"} 1`,
+			},
+			ignores: []string{`ip_family="ipv6"`, `collector="ip6tables"`},
+		},
+		{
+			query: "collect[]=iptables&collect[]=ip6tables&collect[]=nflog",
+			code:  http.StatusOK,
+			contains: []string{
+				`iptables_rule_packets_total{ip_family="ipv4"} 1`,
+				`iptables_rule_packets_total{ip_family="ipv6"} 1`,
+				`iptables_nflog_test 0`,
+			},
+		},
+		{
+			query:    "collect[]=conntrack",
+			code:     http.StatusBadRequest,
+			contains: []string{`unknown collector "conntrack", expected one of [ip6tables iptables nflog]`},
+		},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics?"+tc.query, nil))
+		body, _ := ioutil.ReadAll(w.Body)
+		if w.Code != tc.code {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.query, tc.code, w.Code, body)
+		}
+		for _, series := range tc.contains {
+			if !strings.Contains(string(body), series) {
+				t.Fatalf("%s: %s missing from\n%s", tc.query, series, body)
+			}
+		}
+		for _, series := range tc.ignores {
+			if strings.Contains(string(body), series) {
+				t.Fatalf("%s: unexpected %s in\n%s", tc.query, series, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// reservedLabels are the labels set by the collector itself, its built-in
+// labelers and the wrappers of its registries, which user-defined labels
+// must not shadow.
+var reservedLabels = map[string]bool{
+	"table": true, "chain": true, "rule": true, "ip_family": true, "rule_key": true,
+	"limit_match": true, "limit_unit": true,
+	"time_start": true, "time_stop": true, "time_weekdays": true, "time_monthdays": true,
+	"service": true, "set": true, "unit": true, "mark": true, "hook": true, "zone": true, "dport": true,
+	"section": true, "ctstate": true, "dscp": true, "log_prefix": true, "owner_user": true, "owner_group": true,
+	"physdev_in": true, "physdev_out": true, "proxy_port": true, "reject_with": true, "verdict_kind": true,
+	"icmpv6_type": true, "hop_limit": true, "frag": true, "rt_type": true,
+	"csf_list": true, "csf_direction": true, "managed_by": true, "src_zone": true, "dst_zone": true,
+	"firewall": true, "rule_number": true, "description": true,
+	"instance": true, "container": true, "netns": true,
+}
+
+// wrapperLabels are added to the rule metrics by the registries of remote
+// targets, containers and network namespaces.
+var wrapperLabels = []string{"instance", "container", "netns"}
+
+// checkLabelNames returns an error unless names are valid label names, each
+// used once and none of them one of wrapperLabels.
+func checkLabelNames(names []string) error {
+	seen := make(map[string]bool)
+	for _, name := range wrapperLabels {
+		seen[name] = true
+	}
+	for _, name := range names {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("%q can't be used as a label name", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("label %q is used more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// commentLabeler turns key=value pairs of rule comments into labels, e.g.
+// "owner=ops,ticket=NET-42".
+type commentLabeler struct {
+	keys []string
+}
+
+// newCommentLabeler accepts the keys given to --iptables.comment-labels,
+// each of which may be a comma-separated list.
+func newCommentLabeler(values []string) (*commentLabeler, error) {
+	l := &commentLabeler{}
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, key := range strings.Split(value, ",") {
+			key = strings.TrimSpace(key)
+			if key == "" || seen[key] {
+				continue
+			}
+			if !model.LabelName(key).IsValid() || reservedLabels[key] {
+				return nil, fmt.Errorf("%q can't be used as a label name", key)
+			}
+			seen[key] = true
+			l.keys = append(l.keys, key)
+		}
+	}
+	return l, nil
+}
+
+func (l *commentLabeler) labelNames() []string {
+	return l.keys
+}
+
+func (l *commentLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	pairs := parseCommentPairs(rule.Spec().Comment)
+	values := make([]string, len(l.keys))
+	for i, key := range l.keys {
+		values[i] = pairs[key]
+	}
+	return values, false
+}
+
+// parseCommentPairs parses comments of the form key=value,key2=value2.
+// Comments not following that syntax yield no pairs at all.
+func parseCommentPairs(comment string) map[string]string {
+	if comment == "" {
+		return nil
+	}
+	pairs := make(map[string]string)
+	for _, part := range strings.Split(comment, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil
+		}
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			return nil
+		}
+		pairs[key] = strings.TrimSpace(kv[1])
+	}
+	return pairs
+}
@@ -0,0 +1,29 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestCommentLabeler(t *testing.T) {
+	comments, err := newCommentLabeler([]string{"owner,ticket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLabeler(t, comments, []labelerCase{
+		{text: `-m comment --comment "owner=ops,ticket=NET-42" -j ACCEPT`, values: []string{"ops", "NET-42"}},
+		{text: `-m comment --comment "owner=ops" -j ACCEPT`, values: []string{"ops", ""}},
+		{text: `-m comment --comment "allow ssh" -j ACCEPT`, values: []string{"", ""}},
+	})
+}
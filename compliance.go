@@ -0,0 +1,128 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var complianceCheckDesc = prometheus.NewDesc(
+	"iptables_compliance_check",
+	"iptables_exporter: Whether a configured compliance check passed.",
+	[]string{"name"},
+	nil,
+)
+
+// complianceConfig asserts properties of a chain. Every property set must
+// hold for the check to pass.
+type complianceConfig struct {
+	Name string `yaml:"name"`
+	// Family restricts the check to one IP family. By default the check has
+	// to pass for every family collected.
+	Family string `yaml:"ip_family"`
+	Table  string `yaml:"table"`
+	Chain  string `yaml:"chain"`
+	// Policy is the required default policy of the chain.
+	Policy string `yaml:"policy"`
+	// Rule is a regular expression at least one rule of the chain has to
+	// match.
+	Rule string `yaml:"rule"`
+	// NoRule is a regular expression no rule of the chain may match.
+	NoRule string `yaml:"no_rule"`
+}
+
+type complianceCheck struct {
+	name   string
+	family iptables.Family
+	table  string
+	chain  string
+	policy string
+	rule   *regexp.Regexp
+	noRule *regexp.Regexp
+}
+
+// newComplianceChecks compiles checks validated by config.validate.
+func newComplianceChecks(configs []complianceConfig) ([]complianceCheck, error) {
+	var checks []complianceCheck
+	for _, cfg := range configs {
+		check := complianceCheck{
+			name:   cfg.Name,
+			family: iptables.Family(cfg.Family),
+			table:  cfg.Table,
+			chain:  cfg.Chain,
+			policy: cfg.Policy,
+		}
+		var err error
+		if cfg.Rule != "" {
+			if check.rule, err = regexp.Compile(cfg.Rule); err != nil {
+				return nil, fmt.Errorf("compliance check %q: %s", cfg.Name, err)
+			}
+		}
+		if cfg.NoRule != "" {
+			if check.noRule, err = regexp.Compile(cfg.NoRule); err != nil {
+				return nil, fmt.Errorf("compliance check %q: %s", cfg.Name, err)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// passes evaluates the check against the collected families. A check
+// fails if its chain doesn't exist.
+func (c complianceCheck) passes(families map[iptables.Family]iptables.Tables) bool {
+	evaluated := false
+	for family, tables := range families {
+		if c.family != "" && c.family != family {
+			continue
+		}
+		evaluated = true
+		chain, ok := tables[c.table][c.chain]
+		if !ok {
+			return false
+		}
+		if c.policy != "" && chain.Policy != c.policy {
+			return false
+		}
+		matched := false
+		for _, rule := range chain.Rules {
+			if c.rule != nil && c.rule.MatchString(rule.Text) {
+				matched = true
+			}
+			if c.noRule != nil && c.noRule.MatchString(rule.Text) {
+				return false
+			}
+		}
+		if c.rule != nil && !matched {
+			return false
+		}
+	}
+	return evaluated
+}
+
+// collectCompliance exports the result of every compliance check.
+func (c *collector) collectCompliance(metricChan chan<- prometheus.Metric, families map[iptables.Family]iptables.Tables) {
+	for _, check := range c.checks {
+		value := 0.0
+		if check.passes(families) {
+			value = 1
+		}
+		metricChan <- prometheus.MustNewConstMetric(complianceCheckDesc, prometheus.GaugeValue, value, check.name)
+	}
+}
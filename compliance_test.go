@@ -0,0 +1,62 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+func TestComplianceCheckPasses(t *testing.T) {
+	families := map[iptables.Family]iptables.Tables{
+		iptables.IPv4: {
+			"filter": {
+				"INPUT": {Policy: "DROP", Rules: []iptables.Rule{
+					{Text: "-p tcp -m tcp --dport 22 -m limit --limit 5/min -j ACCEPT"},
+					{Text: "-p tcp -m tcp --dport 80 -j ACCEPT"},
+				}},
+			},
+		},
+		iptables.IPv6: {
+			"filter": {
+				"INPUT": {Policy: "ACCEPT"},
+			},
+		},
+	}
+	for _, tc := range []struct {
+		config complianceConfig
+		passes bool
+	}{
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "INPUT", Policy: "DROP"}, passes: true},
+		{config: complianceConfig{Table: "filter", Chain: "INPUT", Policy: "DROP"}, passes: false},
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "INPUT", Rule: `--dport 22 .*-m limit`}, passes: true},
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "INPUT", Rule: `--dport 3389`}, passes: false},
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "INPUT", NoRule: `--dport 23 `}, passes: true},
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "INPUT", NoRule: `--dport 80 `}, passes: false},
+		{config: complianceConfig{Family: "ipv4", Table: "filter", Chain: "FORWARD", Policy: "DROP"}, passes: false},
+		{config: complianceConfig{Family: "ipv4", Table: "nat", Chain: "INPUT"}, passes: false},
+		// A check of a family that wasn't collected can't pass.
+		{config: complianceConfig{Family: "ipv5", Table: "filter", Chain: "INPUT"}, passes: false},
+	} {
+		checks, err := newComplianceChecks([]complianceConfig{tc.config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passes := checks[0].passes(families); passes != tc.passes {
+			t.Fatalf("%+v: expected %v, got %v", tc.config, tc.passes, passes)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	uncompressedSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iptables_exporter_http_response_uncompressed_size_bytes",
+			Help:    "iptables_exporter: Size of the responses of scrape handlers before compression.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 12),
+		},
+		[]string{"handler", "encoding"},
+	)
+
+	compressedSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iptables_exporter_http_response_compressed_size_bytes",
+			Help:    "iptables_exporter: Size of the responses of scrape handlers after compression.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 12),
+		},
+		[]string{"handler", "encoding"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(uncompressedSize, compressedSize)
+}
+
+// compression compresses the responses of the scrape handlers with the
+// encodings clients accept, in place of the fixed gzip level of promhttp.
+type compression struct {
+	enabled   bool
+	gzipLevel int
+	zstd      bool
+
+	gzipWriters sync.Pool
+	zstdWriters sync.Pool
+}
+
+// newCompression returns the compression configured by the --web.compression
+// flags. gzipLevel is a compress/gzip level, from HuffmanOnly to
+// BestCompression.
+func newCompression(enabled bool, gzipLevel int, zstdEnabled bool) (*compression, error) {
+	if _, err := gzip.NewWriterLevel(ioutil.Discard, gzipLevel); err != nil || gzipLevel < gzip.HuffmanOnly {
+		return nil, fmt.Errorf("invalid gzip level %d", gzipLevel)
+	}
+	return &compression{enabled: enabled, gzipLevel: gzipLevel, zstd: zstdEnabled}, nil
+}
+
+// encoding returns the encoding to compress the response to r with, or ""
+// to send it uncompressed. zstd is preferred over gzip if both are enabled
+// and accepted.
+func (c *compression) encoding(r *http.Request) string {
+	if !c.enabled {
+		return ""
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		accepted[name] = true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				// q=0 marks an encoding as not acceptable.
+				q, err := strconv.ParseFloat(param[2:], 64)
+				accepted[name] = err == nil && q > 0
+			}
+		}
+	}
+	switch {
+	case c.zstd && accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// encoder returns a writer compressing to w with encoding, and a function
+// to finish the stream and return the writer to its pool.
+func (c *compression) encoder(encoding string, w io.Writer) (io.Writer, func() error) {
+	if encoding == "zstd" {
+		z, ok := c.zstdWriters.Get().(*zstd.Encoder)
+		if ok {
+			z.Reset(w)
+		} else {
+			// Responses are written by a single goroutine.
+			z, _ = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		}
+		return z, func() error {
+			defer c.zstdWriters.Put(z)
+			return z.Close()
+		}
+	}
+	g, ok := c.gzipWriters.Get().(*gzip.Writer)
+	if ok {
+		g.Reset(w)
+	} else {
+		g, _ = gzip.NewWriterLevel(w, c.gzipLevel)
+	}
+	return g, func() error {
+		defer c.gzipWriters.Put(g)
+		return g.Close()
+	}
+}
+
+// handler compresses the responses of next, which must not compress them
+// itself, and records their size before and after compression.
+func (c *compression) handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := c.encoding(r)
+		out := &countingResponseWriter{ResponseWriter: w}
+		if encoding == "" {
+			next.ServeHTTP(out, r)
+			uncompressedSize.WithLabelValues(name, "identity").Observe(float64(out.written))
+			compressedSize.WithLabelValues(name, "identity").Observe(float64(out.written))
+			return
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc, finish := c.encoder(encoding, out)
+		in := &countingResponseWriter{ResponseWriter: w, w: enc}
+		next.ServeHTTP(in, r)
+		if err := finish(); err != nil {
+			log.Debugf("Compressing response to %s: %s", r.RemoteAddr, err)
+		}
+		uncompressedSize.WithLabelValues(name, encoding).Observe(float64(in.written))
+		compressedSize.WithLabelValues(name, encoding).Observe(float64(out.written))
+	})
+}
+
+// countingResponseWriter counts the bytes of a response, writing them to w
+// if set, or the ResponseWriter otherwise.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	w       io.Writer
+	written int64
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if cw.w != nil {
+		n, err = cw.w.Write(p)
+	} else {
+		n, err = cw.ResponseWriter.Write(p)
+	}
+	cw.written += int64(n)
+	return n, err
+}
+
+// compressionFlags are the values of the --web.compression* flags.
+type compressionFlags struct {
+	enabled, zstd *bool
+	gzipLevel     *int
+}
+
+func newCompressionFlags(app *kingpin.Application) compressionFlags {
+	return compressionFlags{
+		enabled:   app.Flag("web.compression", "Compress the responses of the metrics and probe endpoints for clients accepting it.").Default("true").Bool(),
+		gzipLevel: app.Flag("web.compression.gzip-level", "gzip level of compressed responses, from 1 (fastest) to 9 (smallest), -1 for the default level or -2 for Huffman coding only.").Default("-1").Int(),
+		zstd:      app.Flag("web.compression.zstd", "Compress responses with zstd instead of gzip for clients accepting it.").Bool(),
+	}
+}
+
+func (f compressionFlags) compression() (*compression, error) {
+	c, err := newCompression(*f.enabled, *f.gzipLevel, *f.zstd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --web.compression.gzip-level: %s", err)
+	}
+	return c, nil
+}
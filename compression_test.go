@@ -0,0 +1,111 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCompressionHandler(t *testing.T) {
+	body := strings.Repeat("iptables_rule_packets_total{chain=\"INPUT\",table=\"filter\"} 42\n", 100)
+	for _, tc := range []struct {
+		name           string
+		enabled, zstd  bool
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "identity", enabled: true, encoding: "identity"},
+		{name: "gzip", enabled: true, acceptEncoding: "gzip, deflate", encoding: "gzip"},
+		{name: "zstd disabled", enabled: true, acceptEncoding: "zstd, gzip", encoding: "gzip"},
+		{name: "zstd preferred", enabled: true, zstd: true, acceptEncoding: "gzip, zstd", encoding: "zstd"},
+		{name: "zstd refused", enabled: true, zstd: true, acceptEncoding: "zstd;q=0, gzip;q=0.5", encoding: "gzip"},
+		{name: "gzip refused", enabled: true, acceptEncoding: "GZIP; q=0", encoding: "identity"},
+		{name: "disabled", zstd: true, acceptEncoding: "gzip, zstd", encoding: "identity"},
+	} {
+		c, err := newCompression(tc.enabled, gzip.DefaultCompression, tc.zstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := c.handler(tc.name, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		}))
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tc.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		compressed := w.Body.Len()
+		var reader io.Reader = w.Body
+		contentEncoding := w.Header().Get("Content-Encoding")
+		switch tc.encoding {
+		case "gzip":
+			reader, err = gzip.NewReader(w.Body)
+		case "zstd":
+			var z *zstd.Decoder
+			z, err = zstd.NewReader(w.Body)
+			reader = z
+		case "identity":
+			if contentEncoding != "" {
+				t.Fatalf("%s: expected no Content-Encoding, got %q", tc.name, contentEncoding)
+			}
+			contentEncoding = "identity"
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if contentEncoding != tc.encoding {
+			t.Fatalf("%s: expected Content-Encoding %q, got %q", tc.name, tc.encoding, contentEncoding)
+		}
+		decoded, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if !bytes.Equal(decoded, []byte(body)) {
+			t.Fatalf("%s: response doesn't match", tc.name)
+		}
+
+		for _, size := range []struct {
+			histogram *prometheus.HistogramVec
+			expected  int
+		}{
+			{uncompressedSize, len(body)},
+			{compressedSize, compressed},
+		} {
+			var m dto.Metric
+			if err := size.histogram.WithLabelValues(tc.name, tc.encoding).(prometheus.Metric).Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			if count, sum := m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(); count != 1 || sum != float64(size.expected) {
+				t.Fatalf("%s: expected one response of %d bytes, got %d of %v", tc.name, size.expected, count, sum)
+			}
+		}
+		if tc.encoding != "identity" && compressed >= len(body) {
+			t.Fatalf("%s: response not smaller compressed: %d bytes", tc.name, compressed)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+	"github.com/steigr/iptables_exporter/expr"
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/yaml.v3"
+)
+
+// config is the content of --config.file.
+type config struct {
+	Rules rulesConfig `yaml:"rules"`
+	// Marks maps firewall marks to names for the mark label.
+	Marks map[string]string `yaml:"marks"`
+	// Webhooks are notified of ruleset changes.
+	Webhooks []webhookConfig `yaml:"webhooks"`
+	// Compliance checks are evaluated on every collection.
+	Compliance []complianceConfig `yaml:"compliance"`
+	// Targets are remote hosts collected along with the local one.
+	Targets []targetConfig `yaml:"targets"`
+	// Captures override --iptables.capture-re for tables and chains.
+	Captures []captureConfig `yaml:"captures"`
+}
+
+type rulesConfig struct {
+	// Filter is an expression; rules it evaluates to false for are not
+	// exported.
+	Filter string `yaml:"filter"`
+	// Labels maps label names to expressions computing their values.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// loadConfig reads and validates path. Unknown fields are rejected, so typos
+// don't silently disable parts of the configuration.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(&root); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configError is a validation error located in the configuration file.
+type configError struct {
+	line int
+	path string
+	msg  string
+}
+
+func (e configError) Error() string {
+	if e.line == 0 {
+		return fmt.Sprintf("%s: %s", e.path, e.msg)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.line, e.path, e.msg)
+}
+
+// validate checks constraints decoding can't express. root is the parsed
+// document, used to point errors at the offending line.
+func (c *config) validate(root *yaml.Node) error {
+	fail := func(msg string, path ...interface{}) error {
+		e := configError{msg: msg}
+		for _, p := range path {
+			switch p := p.(type) {
+			case string:
+				if e.path != "" {
+					e.path += "."
+				}
+				e.path += p
+			case int:
+				e.path += fmt.Sprintf("[%d]", p)
+			}
+		}
+		if n := configNode(root, path...); n != nil {
+			e.line = n.Line
+		}
+		return e
+	}
+
+	if c.Rules.Filter != "" {
+		if _, err := expr.Compile(c.Rules.Filter, exprVars); err != nil {
+			return fail(err.Error(), "rules", "filter")
+		}
+	}
+	for name, source := range c.Rules.Labels {
+		if !model.LabelName(name).IsValid() || reservedLabels[name] {
+			return fail(fmt.Sprintf("%q can't be used as a label name", name), "rules", "labels", name)
+		}
+		if _, err := expr.Compile(source, exprVars); err != nil {
+			return fail(err.Error(), "rules", "labels", name)
+		}
+	}
+	for mark := range c.Marks {
+		if _, err := strconv.ParseUint(mark, 0, 32); err != nil {
+			return fail(fmt.Sprintf("invalid mark %q", mark), "marks", mark)
+		}
+	}
+	for i, w := range c.Webhooks {
+		if w.URL == "" {
+			return fail("url is required", "webhooks", i)
+		}
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fail(fmt.Sprintf("invalid url %q", w.URL), "webhooks", i, "url")
+		}
+		if w.MaxRetries != nil && *w.MaxRetries < 0 {
+			return fail("max_retries must not be negative", "webhooks", i, "max_retries")
+		}
+		if w.Timeout < 0 {
+			return fail("timeout must not be negative", "webhooks", i, "timeout")
+		}
+	}
+	names := make(map[string]bool)
+	for i, check := range c.Compliance {
+		switch {
+		case check.Name == "":
+			return fail("name is required", "compliance", i)
+		case names[check.Name]:
+			return fail(fmt.Sprintf("duplicate name %q", check.Name), "compliance", i, "name")
+		case check.Table == "" || check.Chain == "":
+			return fail("table and chain are required", "compliance", i)
+		case check.Policy == "" && check.Rule == "" && check.NoRule == "":
+			return fail("one of policy, rule or no_rule is required", "compliance", i)
+		}
+		names[check.Name] = true
+		if check.Family != "" && check.Family != string(iptables.IPv4) && check.Family != string(iptables.IPv6) {
+			return fail(fmt.Sprintf("unknown ip_family %q", check.Family), "compliance", i, "ip_family")
+		}
+		for field, re := range map[string]string{"rule": check.Rule, "no_rule": check.NoRule} {
+			if _, err := regexp.Compile(re); err != nil {
+				return fail(err.Error(), "compliance", i, field)
+			}
+		}
+	}
+	captures := make(map[[2]string]bool)
+	for i, capture := range c.Captures {
+		key := [2]string{capture.Table, capture.Chain}
+		switch {
+		case capture.Table == "":
+			return fail("table is required", "captures", i)
+		case captures[key]:
+			return fail("duplicate table and chain", "captures", i)
+		}
+		captures[key] = true
+		if _, err := regexp.Compile(capture.Regex); err != nil {
+			return fail(err.Error(), "captures", i, "regex")
+		}
+	}
+	targets := map[string]bool{localTarget: true}
+	for i, t := range c.Targets {
+		switch {
+		case t.Name == "":
+			return fail("name is required", "targets", i)
+		case targets[t.Name]:
+			return fail(fmt.Sprintf("duplicate or reserved name %q", t.Name), "targets", i, "name")
+		case (t.SSH == nil) == (t.Agent == nil):
+			return fail("exactly one of ssh or agent is required", "targets", i)
+		case t.SSH != nil && t.SSH.Host == "":
+			return fail("host is required", "targets", i, "ssh")
+		case t.SSH != nil && hasEmpty(t.SSH.JumpHosts):
+			return fail("jump hosts must not be empty", "targets", i, "ssh", "jump_hosts")
+		case t.SSH != nil && t.SSH.Persist < 0:
+			return fail("persist must not be negative", "targets", i, "ssh", "persist")
+		case t.Agent != nil && t.Agent.URL == "":
+			return fail("url is required", "targets", i, "agent")
+		case t.Timeout < 0:
+			return fail("timeout must not be negative", "targets", i, "timeout")
+		}
+		targets[t.Name] = true
+	}
+	return nil
+}
+
+func hasEmpty(values []string) bool {
+	for _, v := range values {
+		if v == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// configNode returns the node at path, made of mapping keys and sequence
+// indexes, or the deepest node found on the way.
+func configNode(root *yaml.Node, path ...interface{}) *yaml.Node {
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, p := range path {
+		var next *yaml.Node
+		switch p := p.(type) {
+		case string:
+			if n.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(n.Content); i += 2 {
+					if n.Content[i].Value == p {
+						next = n.Content[i+1]
+						if next.Kind == yaml.ScalarNode {
+							next = n.Content[i]
+						}
+						break
+					}
+				}
+			}
+		case int:
+			if n.Kind == yaml.SequenceNode && p < len(n.Content) {
+				next = n.Content[p]
+			}
+		}
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	if n.Kind == yaml.DocumentNode {
+		return nil
+	}
+	return n
+}
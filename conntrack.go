@@ -0,0 +1,152 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	conntrackEntriesDesc = prometheus.NewDesc(
+		"iptables_conntrack_entries",
+		"iptables_exporter: Number of entries in the connection tracking table.",
+		nil,
+		nil,
+	)
+
+	conntrackEntriesLimitDesc = prometheus.NewDesc(
+		"iptables_conntrack_entries_limit",
+		"iptables_exporter: Maximum size of the connection tracking table.",
+		nil,
+		nil,
+	)
+
+	conntrackBucketsDesc = prometheus.NewDesc(
+		"iptables_conntrack_buckets",
+		"iptables_exporter: Size of the hash table of the connection tracking table.",
+		nil,
+		nil,
+	)
+
+	// conntrackStatDescs are the per-CPU counters of nf_conntrack by their
+	// column in /proc/net/stat/nf_conntrack.
+	conntrackStatDescs = map[string]*prometheus.Desc{
+		"found":         conntrackStatDesc("found", "Total lookups of packets finding their connection."),
+		"invalid":       conntrackStatDesc("invalid", "Total packets that couldn't be tracked."),
+		"drop":          conntrackStatDesc("drop", "Total packets dropped as tracking their connection failed."),
+		"early_drop":    conntrackStatDesc("early_drop", "Total entries dropped to make room for new ones as the table was full."),
+		"insert_failed": conntrackStatDesc("insert_failed", "Total entries that couldn't be inserted, e.g. as another CPU inserted the same one."),
+	}
+)
+
+func conntrackStatDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"iptables_conntrack_stat_"+name+"_total",
+		"iptables_exporter: "+help,
+		[]string{"cpu"},
+		nil,
+	)
+}
+
+// conntrackCollector exports the size of the connection tracking table from
+// /proc/sys/net/netfilter and the per-CPU statistics of nf_conntrack from
+// /proc/net/stat, so a table filling up, and new connections being dropped,
+// can be watched along with the rules.
+type conntrackCollector struct {
+	procPath string
+}
+
+func (c conntrackCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- conntrackEntriesDesc
+	descChan <- conntrackEntriesLimitDesc
+	descChan <- conntrackBucketsDesc
+	for _, desc := range conntrackStatDescs {
+		descChan <- desc
+	}
+}
+
+func (c conntrackCollector) update(metricChan chan<- prometheus.Metric) error {
+	for _, sysctl := range []struct {
+		name string
+		desc *prometheus.Desc
+	}{
+		{"nf_conntrack_count", conntrackEntriesDesc},
+		{"nf_conntrack_max", conntrackEntriesLimitDesc},
+		{"nf_conntrack_buckets", conntrackBucketsDesc},
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(c.procPath, "sys", "net", "netfilter", sysctl.name))
+		if os.IsNotExist(err) {
+			// nf_conntrack isn't loaded.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %s", sysctl.name, err)
+		}
+		metricChan <- prometheus.MustNewConstMetric(sysctl.desc, prometheus.GaugeValue, v)
+	}
+	if err := c.updateStats(metricChan); err != nil {
+		return fmt.Errorf("reading nf_conntrack statistics: %s", err)
+	}
+	return nil
+}
+
+// updateStats exports the counters of /proc/net/stat/nf_conntrack: a header
+// naming the columns, then a line of hexadecimal values per possible CPU.
+func (c conntrackCollector) updateStats(metricChan chan<- prometheus.Metric) error {
+	f, err := os.Open(filepath.Join(c.procPath, "net", "stat", "nf_conntrack"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	// The columns differ between kernel versions.
+	columns := strings.Fields(scanner.Text())
+	for cpu := 0; scanner.Scan(); cpu++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != len(columns) {
+			return fmt.Errorf("%d values for %d columns", len(fields), len(columns))
+		}
+		for i, column := range columns {
+			desc, ok := conntrackStatDescs[column]
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(fields[i], 16, 64)
+			if err != nil {
+				return err
+			}
+			metricChan <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), strconv.Itoa(cpu))
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	csfDenyEntriesDesc = prometheus.NewDesc(
+		"iptables_csf_deny_entries",
+		"iptables_exporter: Number of entries of a ConfigServer Firewall deny list.",
+		[]string{"chain", "direction", "ip_family"},
+		nil,
+	)
+
+	csfBlockedPacketsDesc = prometheus.NewDesc(
+		"iptables_csf_blocked_packets_total",
+		"iptables_exporter: Total packets matching the ConfigServer Firewall deny lists.",
+		[]string{"direction", "ip_family"},
+		nil,
+	)
+
+	csfBlockedBytesDesc = prometheus.NewDesc(
+		"iptables_csf_blocked_bytes_total",
+		"iptables_exporter: Total bytes matching the ConfigServer Firewall deny lists.",
+		[]string{"direction", "ip_family"},
+		nil,
+	)
+)
+
+// csfChain describes a chain of ConfigServer Firewall: the list it holds, if
+// any, and the direction of the traffic it filters.
+type csfChain struct {
+	list      string
+	direction string
+}
+
+// csfChains are the chains created by ConfigServer Firewall in the filter
+// table.
+var csfChains = map[string]csfChain{
+	"LOCALINPUT":  {"", "in"},
+	"LOCALOUTPUT": {"", "out"},
+	"ALLOWIN":     {"allow", "in"},
+	"ALLOWOUT":    {"allow", "out"},
+	"GALLOWIN":    {"allow", "in"},
+	"GALLOWOUT":   {"allow", "out"},
+	"CC_ALLOW":    {"allow", "in"},
+	"CC_ALLOWF":   {"allow", "in"},
+	"CC_ALLOWP":   {"allow", "in"},
+	"DENYIN":      {"deny", "in"},
+	"DENYOUT":     {"deny", "out"},
+	"GDENYIN":     {"deny", "in"},
+	"GDENYOUT":    {"deny", "out"},
+	"CC_DENY":     {"deny", "in"},
+	"CC_DENYP":    {"deny", "in"},
+	"BLOCKDENY":   {"deny", "in"},
+	"LOGDROPIN":   {"drop", "in"},
+	"LOGDROPOUT":  {"drop", "out"},
+	"INVALID":     {"", "in"},
+	"INVDROP":     {"drop", "in"},
+	"PORTFLOOD":   {"", "in"},
+	"SYNFLOOD":    {"", "in"},
+	"UDPFLOOD":    {"", "out"},
+}
+
+// csfLabeler exports the list held by ConfigServer Firewall chains (allow,
+// deny or drop) as "csf_list" and the direction of the traffic they filter
+// (in or out) as "csf_direction" label.
+type csfLabeler struct{}
+
+func (csfLabeler) labelNames() []string {
+	return []string{"csf_list", "csf_direction"}
+}
+
+func (csfLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	if table != "filter" {
+		return []string{"", ""}, false
+	}
+	c := csfChains[chain]
+	return []string{c.list, c.direction}, false
+}
+
+// collectCSF exports the number of entries of the deny lists of ConfigServer
+// Firewall, one rule each, and the traffic they blocked per direction. With
+// LF_IPSET, the entries are kept in IP sets instead, see
+// --iptables.set-entries.
+func (c *collector) collectCSF(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	if !c.csf {
+		return
+	}
+	packets := make(map[string]uint64)
+	bytes := make(map[string]uint64)
+	for name, chain := range tables["filter"] {
+		csf, ok := csfChains[name]
+		if !ok || csf.list != "deny" {
+			continue
+		}
+		metricChan <- prometheus.MustNewConstMetric(csfDenyEntriesDesc, prometheus.GaugeValue, float64(len(chain.Rules)), name, csf.direction, string(family))
+		for _, rule := range chain.Rules {
+			packets[csf.direction] += rule.Packets
+			bytes[csf.direction] += rule.Bytes
+		}
+	}
+	for direction := range packets {
+		metricChan <- prometheus.MustNewConstMetric(csfBlockedPacketsDesc, prometheus.CounterValue, float64(packets[direction]), direction, string(family))
+		metricChan <- prometheus.MustNewConstMetric(csfBlockedBytesDesc, prometheus.CounterValue, float64(bytes[direction]), direction, string(family))
+	}
+}
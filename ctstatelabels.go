@@ -0,0 +1,43 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ctstateLabeler exports the connection tracking states matched by
+// -m conntrack --ctstate or -m state --state as "ctstate" label.
+type ctstateLabeler struct{}
+
+func (ctstateLabeler) labelNames() []string {
+	return []string{"ctstate"}
+}
+
+func (ctstateLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{ruleCtstate(rule.Spec())}, false
+}
+
+// ruleCtstate returns the states matched by a rule as written, e.g.
+// "RELATED,ESTABLISHED", prefixed with "!" if negated.
+func ruleCtstate(spec iptables.RuleSpec) string {
+	if option, ok := spec.MatchOption("conntrack", "--ctstate"); ok {
+		return option.Value()
+	}
+	if option, ok := spec.MatchOption("state", "--state"); ok {
+		return option.Value()
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// scrapeTrace records the stages of a single collection for /debug/scrape.
+// Its methods do nothing on a nil trace, so the collector can call them
+// unconditionally.
+type scrapeTrace struct {
+	mu sync.Mutex
+
+	Seconds               float64         `json:"seconds"`
+	Commands              []*commandTrace `json:"commands"`
+	RulesNotCaptured      int             `json:"rules_not_captured"`
+	RulesSkippedByFilters int             `json:"rules_skipped_by_filters"`
+	Series                map[string]int  `json:"series"`
+	SeriesTotal           int             `json:"series_total"`
+	Cache                 string          `json:"cache"`
+}
+
+type commandTrace struct {
+	Command string          `json:"command"`
+	Family  iptables.Family `json:"ip_family,omitempty"`
+	Seconds float64         `json:"seconds"`
+	Error   string          `json:"error,omitempty"`
+	Lines   int             `json:"lines,omitempty"`
+	Rules   int             `json:"rules,omitempty"`
+
+	trace *scrapeTrace
+}
+
+func (t *scrapeTrace) command(command string, family iptables.Family, start time.Time, err error) *commandTrace {
+	if t == nil {
+		return nil
+	}
+	ct := &commandTrace{
+		Command: command,
+		Family:  family,
+		Seconds: time.Since(start).Seconds(),
+		trace:   t,
+	}
+	if err != nil {
+		ct.Error = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Commands = append(t.Commands, ct)
+	return ct
+}
+
+func (ct *commandTrace) parsed(stats iptables.ParseStats) {
+	if ct == nil {
+		return
+	}
+	ct.trace.mu.Lock()
+	defer ct.trace.mu.Unlock()
+	ct.Lines = stats.Lines
+	ct.Rules = stats.Rules
+	ct.trace.RulesNotCaptured += stats.RulesNotCaptured
+	ct.trace.RulesSkippedByFilters += stats.RulesSkipped
+}
+
+// cache records whether the collection was served from the cache.
+func (t *scrapeTrace) cache(result string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Cache = result
+}
+
+func (t *scrapeTrace) ruleFiltered() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.RulesSkippedByFilters++
+}
+
+var fqNameRegexp = regexp.MustCompile(`fqName: "([^"]*)"`)
+
+// debugScrapeHandler answers /debug/scrape: it runs one collection and
+// reports how long each command took, what was parsed and skipped, and how
+// many series of each metric were produced.
+func debugScrapeHandler(c *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(traceScrape(c))
+	}
+}
+
+// traceScrape runs one collection of c, recording it in the returned trace.
+func traceScrape(c *collector) *scrapeTrace {
+	trace := &scrapeTrace{Series: make(map[string]int), Cache: "disabled"}
+	metrics := make(chan prometheus.Metric)
+	start := time.Now()
+	go func() {
+		c.collect(metrics, trace)
+		close(metrics)
+	}()
+	for m := range metrics {
+		name := m.Desc().String()
+		if match := fqNameRegexp.FindStringSubmatch(name); match != nil {
+			name = match[1]
+		}
+		trace.Series[name]++
+		trace.SeriesTotal++
+	}
+	trace.Seconds = time.Since(start).Seconds()
+	sort.SliceStable(trace.Commands, func(i, j int) bool {
+		return trace.Commands[i].Command < trace.Commands[j].Command
+	})
+	return trace
+}
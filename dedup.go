@@ -0,0 +1,65 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// Keys rules sharing a series are merged by, set with --iptables.dedup-key.
+const (
+	// dedupLabel merges rules with the same labels, so rules differing only
+	// outside the captured part of their text share a series.
+	dedupLabel = "label"
+	// dedupRule merges rules with the same text, as iptables-save prints it.
+	dedupRule = "rule"
+	// dedupComment merges rules with the same comment, or the same labels
+	// for rules without comment.
+	dedupComment = "comment"
+	// dedupHash merges rules with the same text like dedupRule, exporting
+	// a digest of it instead of the text.
+	dedupHash = "hash"
+)
+
+// dedupKeyLabeler exports the key rules are merged by as "rule_key" label,
+// keeping rules with different keys but the same labels apart.
+type dedupKeyLabeler struct {
+	key string
+}
+
+func (dedupKeyLabeler) labelNames() []string {
+	return []string{"rule_key"}
+}
+
+func (l dedupKeyLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{dedupKey(l.key, rule)}, false
+}
+
+// dedupKey returns the value of rule for the given key kind.
+func dedupKey(key string, rule iptables.Rule) string {
+	switch key {
+	case dedupRule:
+		return rule.Text
+	case dedupComment:
+		return rule.Spec().Comment
+	case dedupHash:
+		sum := sha256.Sum256([]byte(rule.Text))
+		return hex.EncodeToString(sum[:8])
+	}
+	return ""
+}
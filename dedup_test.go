@@ -0,0 +1,66 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestDedupKey(t *testing.T) {
+	const (
+		ssh      = "-s 10.0.0.1/32 -p tcp -m comment --comment ssh -j ACCEPT"
+		untagged = "-s 10.0.0.2/32 -p tcp -j ACCEPT"
+	)
+	for _, tc := range []struct {
+		key   string
+		cases []labelerCase
+	}{
+		{key: dedupLabel},
+		{key: dedupRule, cases: []labelerCase{
+			{text: ssh, values: []string{ssh}},
+			{text: untagged, values: []string{untagged}},
+		}},
+		{key: dedupComment, cases: []labelerCase{
+			{text: ssh, values: []string{"ssh"}},
+			{text: untagged, values: []string{""}},
+		}},
+		{key: dedupHash, cases: []labelerCase{
+			{text: ssh, values: []string{"185debce0f950e3e"}},
+		}},
+	} {
+		app := kingpin.New("test", "")
+		flags := newLabelerFlags(app)
+		if _, err := app.Parse([]string{"--iptables.dedup-key=" + tc.key}); err != nil {
+			t.Fatal(err)
+		}
+		labelers, err := flags.labelers(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The default key merges by labels alone, adding none.
+		if tc.key == dedupLabel {
+			if len(labelers) != 0 {
+				t.Fatalf("%s: expected no labelers, got %v", tc.key, labelers)
+			}
+			continue
+		}
+		if len(labelers) != 1 {
+			t.Fatalf("%s: expected one labeler, got %v", tc.key, labelers)
+		}
+		testLabeler(t, labelers[0], tc.cases)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// dscpClasses names the DSCP code points of the class selectors, assured
+// forwarding and expedited forwarding, as the DSCP target's --set-dscp-class
+// accepts them.
+var dscpClasses = map[uint64]string{
+	0:  "BE",
+	8:  "CS1",
+	10: "AF11",
+	12: "AF12",
+	14: "AF13",
+	16: "CS2",
+	18: "AF21",
+	20: "AF22",
+	22: "AF23",
+	24: "CS3",
+	26: "AF31",
+	28: "AF32",
+	30: "AF33",
+	32: "CS4",
+	34: "AF41",
+	36: "AF42",
+	38: "AF43",
+	40: "CS5",
+	46: "EF",
+	48: "CS6",
+	56: "CS7",
+}
+
+// dscpOptions are the options carrying a DSCP value, of the dscp match and
+// of the DSCP target.
+var dscpOptions = []struct{ match, flag string }{
+	{"dscp", "--dscp"},
+	{"dscp", "--dscp-class"},
+	{"DSCP", "--set-dscp"},
+	{"DSCP", "--set-dscp-class"},
+}
+
+// dscpLabeler exports the DSCP class a rule matches with -m dscp or -m tos,
+// or marks packets with using the DSCP or TOS target, as a "dscp" label:
+// the class name, e.g. "AF41" or "EF", or the code point in hex for other
+// values. TOS values whose mask leaves the DSCP bits out are exported as
+// written, prefixed with "tos=".
+type dscpLabeler struct{}
+
+func (dscpLabeler) labelNames() []string {
+	return []string{"dscp"}
+}
+
+func (dscpLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	for _, o := range dscpOptions {
+		if option, ok := spec.MatchOption(o.match, o.flag); ok {
+			return []string{negate(option, dscpName(strings.Join(option.Values, " ")))}, false
+		}
+	}
+	for _, o := range []struct{ match, flag string }{{"tos", "--tos"}, {"TOS", "--set-tos"}} {
+		if option, ok := spec.MatchOption(o.match, o.flag); ok {
+			return []string{negate(option, tosDSCP(strings.Join(option.Values, " ")))}, false
+		}
+	}
+	return []string{""}, false
+}
+
+// negate prefixes value with "!" if option is negated.
+func negate(option iptables.Option, value string) string {
+	if option.Negated {
+		return "!" + value
+	}
+	return value
+}
+
+// dscpName returns the class name of a DSCP value given as a number or a
+// class name.
+func dscpName(value string) string {
+	n, err := strconv.ParseUint(value, 0, 8)
+	if err != nil {
+		return strings.ToUpper(value)
+	}
+	if name, ok := dscpClasses[n]; ok {
+		return name
+	}
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// tosDSCP returns the DSCP class of a TOS value with an optional mask, e.g.
+// "0xb8/0xff" for EF.
+func tosDSCP(value string) string {
+	parts := strings.SplitN(value, "/", 2)
+	tos, err := strconv.ParseUint(parts[0], 0, 8)
+	if err != nil {
+		return "tos=" + value
+	}
+	if len(parts) == 2 {
+		mask, err := strconv.ParseUint(parts[1], 0, 8)
+		if err != nil || mask&0xfc != 0xfc {
+			return "tos=" + value
+		}
+	}
+	return dscpName(strconv.FormatUint(tos>>2, 10))
+}
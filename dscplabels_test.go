@@ -0,0 +1,24 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestDSCPLabeler(t *testing.T) {
+	testLabeler(t, dscpLabeler{}, []labelerCase{
+		{text: `-m dscp --dscp 0x2e -j ACCEPT`, values: []string{"EF"}},
+		{text: `-j DSCP --set-dscp-class af41`, values: []string{"AF41"}},
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// stateDump is the internal state of the exporter written on SIGUSR1.
+type stateDump struct {
+	Time    time.Time         `json:"time"`
+	Version string            `json:"version"`
+	Flags   map[string]string `json:"flags"`
+	Targets []targetDump      `json:"targets"`
+	// Scrape is a collection of the local host run for the dump.
+	Scrape *scrapeTrace `json:"scrape"`
+}
+
+type targetDump struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Ready     bool     `json:"ready"`
+	Failures  int      `json:"failures"`
+	LastError string   `json:"last_error,omitempty"`
+	CacheAge  *float64 `json:"cache_age_seconds,omitempty"`
+}
+
+func newStateDump(targets []probeTarget) *stateDump {
+	d := &stateDump{
+		Time:    time.Now(),
+		Version: version.Info(),
+		Flags:   make(map[string]string),
+	}
+	for _, f := range kingpin.CommandLine.Model().Flags {
+		if f.Value != nil {
+			d.Flags[f.Name] = f.Value.String()
+		}
+	}
+	if len(targets) > 0 {
+		d.Scrape = traceScrape(targets[0].collector)
+	}
+	for _, t := range targets {
+		ok, failures := t.collector.health.status()
+		td := targetDump{Name: t.name, Kind: t.kind, Ready: ok, Failures: failures}
+		if err := t.collector.health.lastError(); err != nil {
+			td.LastError = err.Error()
+		}
+		if t.collector.cache != nil {
+			if age, ok := t.collector.cache.age(); ok {
+				seconds := age.Seconds()
+				td.CacheAge = &seconds
+			}
+		}
+		d.Targets = append(d.Targets, td)
+	}
+	return d
+}
+
+// dumpStateOnSignal writes the state of the exporter to path, or the log if
+// path is empty, whenever it receives SIGUSR1.
+func dumpStateOnSignal(path string, targets []probeTarget) {
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	for range usr1 {
+		data, err := json.MarshalIndent(newStateDump(targets), "", "  ")
+		if err != nil {
+			log.Errorf("Dumping state: %s", err)
+			continue
+		}
+		if path == "" {
+			log.Infof("State dump: %s", data)
+			continue
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			log.Errorf("Dumping state to %s: %s", path, err)
+			continue
+		}
+		log.Infof("Dumped state to %s", path)
+	}
+}
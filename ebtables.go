@@ -0,0 +1,140 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// toolCollector exports the counters of the ruleset of ebtables or
+// arptables, as dumped by their save commands, under metric names prefixed
+// with the tool's name, e.g. ebtables_rule_packets_total.
+type toolCollector struct {
+	tool iptables.Tool
+	// policyCounters is false for tools whose chains count nothing.
+	policyCounters bool
+	timeout        time.Duration
+
+	chainsDesc         *prometheus.Desc
+	defaultPacketsDesc *prometheus.Desc
+	defaultBytesDesc   *prometheus.Desc
+	rulePacketsDesc    *prometheus.Desc
+	ruleBytesDesc      *prometheus.Desc
+}
+
+// toolRule identifies the series of a rule; rules with the same text in
+// a chain share one.
+type toolRule struct {
+	table, chain, rule string
+}
+
+// ruleCounts sums the counters of the rules sharing a series.
+type ruleCounts struct {
+	packets, bytes uint64
+}
+
+func newToolCollector(tool iptables.Tool, timeout time.Duration) toolCollector {
+	name := string(tool)
+	return toolCollector{
+		tool: tool,
+		// ebtables chains have no counters, arptables chains do.
+		policyCounters: tool != iptables.Ebtables,
+		timeout:        timeout,
+		chainsDesc: prometheus.NewDesc(
+			name+"_chains",
+			"iptables_exporter: Number of chains of a table.",
+			[]string{"table"},
+			nil,
+		),
+		defaultPacketsDesc: prometheus.NewDesc(
+			name+"_default_packets_total",
+			"iptables_exporter: Total packets matching a chain's default policy.",
+			[]string{"table", "chain", "policy"},
+			nil,
+		),
+		defaultBytesDesc: prometheus.NewDesc(
+			name+"_default_bytes_total",
+			"iptables_exporter: Total bytes matching a chain's default policy.",
+			[]string{"table", "chain", "policy"},
+			nil,
+		),
+		rulePacketsDesc: prometheus.NewDesc(
+			name+"_rule_packets_total",
+			"iptables_exporter: Total packets matching a rule.",
+			[]string{"table", "chain", "rule"},
+			nil,
+		),
+		ruleBytesDesc: prometheus.NewDesc(
+			name+"_rule_bytes_total",
+			"iptables_exporter: Total bytes matching a rule.",
+			[]string{"table", "chain", "rule"},
+			nil,
+		),
+	}
+}
+
+func (c toolCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- c.chainsDesc
+	if c.policyCounters {
+		descChan <- c.defaultPacketsDesc
+		descChan <- c.defaultBytesDesc
+	}
+	descChan <- c.rulePacketsDesc
+	descChan <- c.ruleBytesDesc
+}
+
+func (c toolCollector) update(metricChan chan<- prometheus.Metric) error {
+	start := time.Now()
+	tables, _, err := iptables.GetToolTablesStats(context.Background(), c.tool, iptables.Options{
+		Timeout: c.timeout,
+		Exited:  recordExit,
+	})
+	timeExec(c.tool.SaveCommand(), "", start)
+	if err != nil {
+		return err
+	}
+	rules := make(map[toolRule]*ruleCounts)
+	var order []toolRule
+	for tableName, table := range tables {
+		metricChan <- prometheus.MustNewConstMetric(c.chainsDesc, prometheus.GaugeValue, float64(len(table)), tableName)
+		for chainName, chain := range table {
+			if c.policyCounters {
+				metricChan <- prometheus.MustNewConstMetric(c.defaultPacketsDesc, prometheus.CounterValue, float64(chain.Packets), tableName, chainName, chain.Policy)
+				metricChan <- prometheus.MustNewConstMetric(c.defaultBytesDesc, prometheus.CounterValue, float64(chain.Bytes), tableName, chainName, chain.Policy)
+			}
+			for _, rule := range chain.Rules {
+				key := toolRule{tableName, chainName, rule.Text}
+				counts, ok := rules[key]
+				if !ok {
+					counts = &ruleCounts{}
+					rules[key] = counts
+					order = append(order, key)
+				}
+				counts.packets += rule.Packets
+				counts.bytes += rule.Bytes
+			}
+		}
+	}
+	for _, key := range order {
+		counts := rules[key]
+		metricChan <- prometheus.MustNewConstMetric(c.rulePacketsDesc, prometheus.CounterValue, float64(counts.packets), key.table, key.chain, key.rule)
+		metricChan <- prometheus.MustNewConstMetric(c.ruleBytesDesc, prometheus.CounterValue, float64(counts.bytes), key.table, key.chain, key.rule)
+	}
+	return nil
+}
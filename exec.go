@@ -0,0 +1,76 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var execDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "iptables_exec_duration_seconds",
+		Help:    "iptables_exporter: Duration of external commands run to collect metrics.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	},
+	[]string{"binary", "table"},
+)
+
+var (
+	execCPUSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptables_exec_cpu_seconds_total",
+			Help: "iptables_exporter: CPU time used by external commands run to collect metrics.",
+		},
+		[]string{"binary", "mode"},
+	)
+
+	execMaxRSS = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptables_exec_max_rss_bytes",
+			Help: "iptables_exporter: Maximum resident set size of the last run of an external command.",
+		},
+		[]string{"binary"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(execDuration)
+	prometheus.MustRegister(execCPUSeconds)
+	prometheus.MustRegister(execMaxRSS)
+}
+
+// timeExec records how long binary took since start. table is empty for
+// commands not limited to a single table.
+func timeExec(binary, table string, start time.Time) {
+	execDuration.WithLabelValues(binary, table).Observe(time.Since(start).Seconds())
+}
+
+// recordExit accounts for the resource usage of an external command once it
+// exited, telling apart the CPU time of the exporter's children from that of
+// other processes on busy devices.
+func recordExit(binary string, state *os.ProcessState) {
+	binary = filepath.Base(binary)
+	execCPUSeconds.WithLabelValues(binary, "user").Add(state.UserTime().Seconds())
+	execCPUSeconds.WithLabelValues(binary, "system").Add(state.SystemTime().Seconds())
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// Linux reports the maximum resident set size in kilobytes.
+		execMaxRSS.WithLabelValues(binary).Set(float64(usage.Maxrss) * 1024)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package expr compiles the CEL (https://github.com/google/cel-spec)
+// expressions used to filter and label rules, e.g.
+//
+//	rule.target == "DROP" && rule.dport in [22, 3389]
+//
+// Variables are dynamically typed, and numbers of different types compare
+// with each other, so counters compare to integer literals.
+package expr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"github.com/google/cel-go/interpreter"
+)
+
+// Program is a compiled expression.
+type Program struct {
+	source  string
+	vars    []string
+	program cel.Program
+}
+
+// Compile parses and checks source. Only the top-level identifiers listed
+// in vars may be referenced.
+func Compile(source string, vars []string) (*Program, error) {
+	options := []cel.EnvOption{cel.CrossTypeNumericComparisons(true)}
+	for _, v := range vars {
+		options = append(options, cel.Variable(v, cel.DynType))
+	}
+	env, err := cel.NewEnv(options...)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	program, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize, cel.OptPartialEval))
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: source, vars: vars, program: program}, nil
+}
+
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program. Values in vars may be strings, integers,
+// booleans, []interface{} and map[string]interface{}; accessing a missing
+// map field is an error.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := p.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return v.Value(), nil
+}
+
+func (p *Program) eval(vars map[string]interface{}) (ref.Val, error) {
+	v, _, err := p.program.Eval(vars)
+	return v, err
+}
+
+// EvalBool evaluates the program and fails unless it returns a boolean.
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	v, err := p.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(types.Bool)
+	if !ok {
+		return false, fmt.Errorf("%s: expected bool, got %s", p.source, v.Type().TypeName())
+	}
+	return bool(b), nil
+}
+
+// Decide evaluates a boolean program with only some of its variables known,
+// those not in vars being unknown. ok is true if the result is the same
+// whatever the unknown variables hold, e.g. for table == "nat" && rule.x
+// with a table other than nat, so callers can skip the evaluation for every
+// value of the unknown ones.
+func (p *Program) Decide(vars map[string]interface{}) (result, ok bool) {
+	var unknown []*interpreter.AttributePattern
+	for _, v := range p.vars {
+		if _, known := vars[v]; !known {
+			unknown = append(unknown, cel.AttributePattern(v))
+		}
+	}
+	activation, err := cel.PartialVars(vars, unknown...)
+	if err != nil {
+		return false, false
+	}
+	v, _, err := p.program.Eval(activation)
+	if err != nil {
+		return false, false
+	}
+	b, ok := v.(types.Bool)
+	return bool(b), ok
+}
+
+// EvalString evaluates the program and formats its result as a string,
+// joining lists with commas.
+func (p *Program) EvalString(vars map[string]interface{}) (string, error) {
+	v, err := p.eval(vars)
+	if err != nil {
+		return "", err
+	}
+	return format(v), nil
+}
+
+func format(v ref.Val) string {
+	if list, ok := v.(traits.Lister); ok {
+		var parts []string
+		for it := list.Iterator(); it.HasNext() == types.True; {
+			parts = append(parts, format(it.Next()))
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(v.Value())
+}
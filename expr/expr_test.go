@@ -0,0 +1,89 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package expr
+
+import "testing"
+
+func TestEval(t *testing.T) {
+	vars := map[string]interface{}{
+		"chain": "INPUT",
+		"rule": map[string]interface{}{
+			"target":  "DROP",
+			"dport":   22,
+			"comment": "owner=ops",
+			"packets": uint64(1204),
+		},
+	}
+	for source, expected := range map[string]interface{}{
+		`rule.target == "DROP" && rule.dport in [22, 3389]`: true,
+		`rule.target == "DROP" && rule.dport in [80, 443]`:  false,
+		`!(chain == "INPUT") || rule.dport >= 1024`:         false,
+		`rule.comment.startsWith("owner=")`:                 true,
+		`rule.comment.matches("^owner=(ops|dev)$")`:         true,
+		`has(rule.missing)`:                                 false,
+		`rule.target`:                                       "DROP",
+		`rule.packets > 1000`:                               true,
+	} {
+		p, err := Compile(source, []string{"chain", "rule"})
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+		v, err := p.Eval(vars)
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+		if v != expected {
+			t.Fatalf("%s: expected %v, got %v", source, expected, v)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, source := range []string{
+		`table == "filter"`,
+		`rule.target ==`,
+		`rule.target.lower()`,
+		`"unterminated`,
+		`rule.comment.matches("(")`,
+	} {
+		if _, err := Compile(source, []string{"rule"}); err == nil {
+			t.Fatalf("%s: expected error", source)
+		}
+	}
+}
+
+func TestDecide(t *testing.T) {
+	vars := map[string]interface{}{"table": "filter", "chain": "KUBE-SVC-1"}
+	for source, expected := range map[string]struct{ result, ok bool }{
+		`table == "nat" && rule.target == "DNAT"`:                 {false, true},
+		`table == "filter" && rule.target == "DROP"`:              {false, false},
+		`rule.target == "DROP" && table == "nat"`:                 {false, true},
+		`!chain.startsWith("KUBE-") || rule.dport == 22`:          {false, false},
+		`!(chain.startsWith("KUBE-") && rule.target == "ACCEPT")`: {false, false},
+		`chain.startsWith("KUBE-") || rule.target == "ACCEPT"`:    {true, true},
+		`!chain.startsWith("KUBE-") && rule.dport == 22`:          {false, true},
+		`chain in ["INPUT", "FORWARD"]`:                           {false, true},
+		`rule.target == "DROP"`:                                   {false, false},
+	} {
+		p, err := Compile(source, []string{"table", "chain", "rule"})
+		if err != nil {
+			t.Fatalf("%s: %s", source, err)
+		}
+		result, ok := p.Decide(vars)
+		if ok != expected.ok || ok && result != expected.result {
+			t.Errorf("%s: expected %v, %v, got %v, %v", source, expected.result, expected.ok, result, ok)
+		}
+	}
+}
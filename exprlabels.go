@@ -0,0 +1,142 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/expr"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// exprVars are the variables available to rule expressions.
+var exprVars = []string{"table", "chain", "rule"}
+
+// exprLabeler filters and labels rules with the expressions of the rules
+// section of the configuration.
+type exprLabeler struct {
+	filter *expr.Program
+	names  []string
+	labels []*expr.Program
+}
+
+func newExprLabeler(cfg rulesConfig) (*exprLabeler, error) {
+	l := &exprLabeler{}
+	if cfg.Filter != "" {
+		p, err := expr.Compile(cfg.Filter, exprVars)
+		if err != nil {
+			return nil, fmt.Errorf("rules.filter: %s", err)
+		}
+		l.filter = p
+	}
+	for name := range cfg.Labels {
+		l.names = append(l.names, name)
+	}
+	sort.Strings(l.names)
+	for _, name := range l.names {
+		p, err := expr.Compile(cfg.Labels[name], exprVars)
+		if err != nil {
+			return nil, fmt.Errorf("rules.labels.%s: %s", name, err)
+		}
+		l.labels = append(l.labels, p)
+	}
+	return l, nil
+}
+
+func (l *exprLabeler) enabled() bool {
+	return l.filter != nil || len(l.labels) > 0
+}
+
+// chains returns whether the filter can keep rules of a chain, nil without
+// filter. Only chains the filter rules out whatever their rules are, e.g.
+// with table == "nat" && ..., are left out.
+func (l *exprLabeler) chains() func(table, chain string) bool {
+	if l.filter == nil {
+		return nil
+	}
+	return func(table, chain string) bool {
+		keep, ok := l.filter.Decide(map[string]interface{}{"table": table, "chain": chain})
+		return keep || !ok
+	}
+}
+
+func (l *exprLabeler) labelNames() []string {
+	return l.names
+}
+
+func (l *exprLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	vars := ruleVars(table, chain, rule)
+	if l.filter != nil {
+		keep, err := l.filter.EvalBool(vars)
+		if err != nil {
+			log.Debugf("Skipping rule %q in chain %s[%s]: %s", rule.Text, chain, table, err)
+			return nil, true
+		}
+		if !keep {
+			return nil, true
+		}
+	}
+	values := make([]string, len(l.labels))
+	for i, p := range l.labels {
+		v, err := p.EvalString(vars)
+		if err != nil {
+			log.Debugf("Evaluating label %s for rule %q in chain %s[%s]: %s", l.names[i], rule.Text, chain, table, err)
+		}
+		values[i] = v
+	}
+	return values, false
+}
+
+// ruleVars returns the variables describing rule to expressions.
+func ruleVars(table, chain string, rule iptables.Rule) map[string]interface{} {
+	spec := rule.Spec()
+	matches := make([]interface{}, len(spec.Matches))
+	for i, m := range spec.Matches {
+		matches[i] = m
+	}
+	return map[string]interface{}{
+		"table": table,
+		"chain": chain,
+		"rule": map[string]interface{}{
+			"text":          rule.Text,
+			"label":         rule.Rule,
+			"packets":       rule.Packets,
+			"bytes":         rule.Bytes,
+			"source":        spec.Source,
+			"destination":   spec.Destination,
+			"proto":         spec.Proto,
+			"in_interface":  spec.InInterface,
+			"out_interface": spec.OutInterface,
+			"sport":         port(spec.SPort),
+			"dport":         port(spec.DPort),
+			"comment":       spec.Comment,
+			"target":        spec.Target,
+			"goto":          spec.Goto,
+			"matches":       matches,
+		},
+	}
+}
+
+// port returns a single port as an integer, so that it compares to integers,
+// and ranges and lists of ports such as 1024:65535 unchanged.
+func port(p string) interface{} {
+	if n, err := strconv.ParseInt(p, 10, 64); err == nil {
+		return n
+	}
+	return p
+}
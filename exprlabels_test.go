@@ -0,0 +1,31 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestExprLabeler(t *testing.T) {
+	expressions, err := newExprLabeler(rulesConfig{
+		Filter: `rule.target == "DROP"`,
+		Labels: map[string]string{"port": "rule.dport"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLabeler(t, expressions, []labelerCase{
+		{text: `-p tcp -m tcp --dport 22 -j DROP`, values: []string{"22"}},
+		{text: `-p tcp -m tcp --dport 22 -j ACCEPT`, skip: true},
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	rulesetFlushes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptables_ruleset_flushed_total",
+			Help: "iptables_exporter: Total times a table lost all its rules and custom chains between collections.",
+		},
+		[]string{"table", "ip_family"},
+	)
+
+	rulesetFlushed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iptables_ruleset_flushed",
+			Help: "iptables_exporter: Whether a table has had no rules or custom chains since it was flushed.",
+		},
+		[]string{"table", "ip_family"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rulesetFlushes, rulesetFlushed)
+}
+
+// flushDetector notices tables that suddenly lose all their rules and custom
+// chains, as after an accidental iptables -F.
+type flushDetector struct{}
+
+func (flushDetector) rulesetChanged(change rulesetChange) {
+	for name, old := range change.Old {
+		if trivialTable(old) {
+			continue
+		}
+		if table := change.New[name]; trivialTable(table) {
+			log.Warnf("Table %s of %s was flushed", name, change.Family)
+			rulesetFlushes.WithLabelValues(name, string(change.Family)).Inc()
+			rulesetFlushed.WithLabelValues(name, string(change.Family)).Set(1)
+		}
+	}
+	for name, table := range change.New {
+		if !trivialTable(table) {
+			rulesetFlushed.DeleteLabelValues(name, string(change.Family))
+		}
+	}
+}
+
+// trivialTable reports whether table has neither rules nor custom chains.
+func trivialTable(table iptables.Table) bool {
+	for name, chain := range table {
+		if _, builtin := iptables.BuiltinHook(name); !builtin || len(chain.Rules) > 0 {
+			return false
+		}
+	}
+	return true
+}
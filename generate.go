@@ -0,0 +1,249 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// chainFamily is a group of chains created by a well-known tool.
+type chainFamily struct {
+	prefix string
+	tool   string
+	// noisy chains come and go with workloads and are filtered out by the
+	// generated configuration
+	noisy bool
+}
+
+// chainFamilies are checked in order; the first matching prefix wins.
+var chainFamilies = []chainFamily{
+	{"KUBE-SEP-", "kube-proxy service endpoints", true},
+	{"KUBE-SVC-", "kube-proxy services", true},
+	{"KUBE-FW-", "kube-proxy load balancers", true},
+	{"KUBE-XLB-", "kube-proxy external traffic policy", true},
+	{"KUBE-", "kube-proxy", false},
+	{"cali-", "Calico", true},
+	{"CILIUM_", "Cilium", false},
+	{"DOCKER", "Docker", false},
+	{"LIBVIRT_", "libvirt", false},
+	{"f2b-", "fail2ban", false},
+	{"ufw-", "ufw", false},
+	{"ufw6-", "ufw", false},
+}
+
+// systemInventory is what generate-config found on the live system.
+type systemInventory struct {
+	commands map[string]bool
+	tables   map[iptables.Family]iptables.Tables
+	errors   map[iptables.Family]error
+	// chains counts the chains of each chain family
+	chains       map[string]int
+	sets         int
+	marks        []string
+	commentKeys  []string
+	customChains bool
+	nflog        bool
+	policies     map[string]string
+}
+
+// inspectSystem collects the information generate-config bases its
+// suggestions on.
+func inspectSystem(procPath string) systemInventory {
+	inv := systemInventory{
+		commands: make(map[string]bool),
+		tables:   make(map[iptables.Family]iptables.Tables),
+		errors:   make(map[iptables.Family]error),
+		chains:   make(map[string]int),
+		policies: make(map[string]string),
+	}
+	for _, command := range backendCommands {
+		_, err := exec.LookPath(command)
+		inv.commands[command] = err == nil
+	}
+	for _, family := range iptables.Families {
+		tables, err := iptables.GetTablesContext(context.Background(), iptables.Options{Family: family})
+		if err == nil && len(tables) == 0 {
+			err = fmt.Errorf("no output from %s", family.SaveCommand())
+		}
+		if err != nil {
+			inv.errors[family] = err
+			continue
+		}
+		inv.tables[family] = tables
+	}
+	if _, err := os.Stat(filepath.Join(procPath, "net/netfilter/nfnetlink_log")); err == nil {
+		inv.nflog = true
+	}
+
+	marks := make(map[string]bool)
+	commentKeys := make(map[string]bool)
+	for family, tables := range inv.tables {
+		for tableName, table := range tables {
+			for chainName, chain := range table {
+				if _, builtin := iptables.BuiltinHook(chainName); !builtin {
+					inv.customChains = true
+					for _, f := range chainFamilies {
+						if strings.HasPrefix(chainName, f.prefix) {
+							inv.chains[f.prefix]++
+							break
+						}
+					}
+				} else if family == iptables.IPv4 && tableName == "filter" {
+					inv.policies[chainName] = chain.Policy
+				}
+				for _, rule := range chain.Rules {
+					spec := rule.Spec()
+					for _, o := range markOptions {
+						if option, ok := spec.MatchOption(o.match, o.flag); ok && len(option.Values) > 0 {
+							marks[strings.SplitN(option.Values[0], "/", 2)[0]] = true
+						}
+					}
+					for key := range parseCommentPairs(spec.Comment) {
+						commentKeys[key] = true
+					}
+				}
+			}
+		}
+	}
+	inv.sets = len(referencedSets(inv.tables))
+	inv.marks = sortedKeys(marks)
+	inv.commentKeys = sortedKeys(commentKeys)
+	return inv
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// generateConfig writes a commented starter configuration for the system
+// described by inv.
+func generateConfig(w io.Writer, inv systemInventory) {
+	fmt.Fprintln(w, "# Starter configuration generated by iptables_exporter generate-config.")
+	fmt.Fprintln(w, "# Review it before use, then pass it with --config.file.")
+	fmt.Fprintln(w, "#")
+	fmt.Fprintln(w, "# Detected:")
+	for _, command := range backendCommands {
+		state := "not found"
+		if inv.commands[command] {
+			state = "found"
+		}
+		fmt.Fprintf(w, "#   %s: %s\n", command, state)
+	}
+	for _, family := range iptables.Families {
+		if err, ok := inv.errors[family]; ok {
+			fmt.Fprintf(w, "#   %s tables: not collected (%s)\n", family, err)
+			continue
+		}
+		fmt.Fprintf(w, "#   %s tables: %s\n", family, strings.Join(inv.tables[family].Names(), ", "))
+	}
+	for _, f := range chainFamilies {
+		if n := inv.chains[f.prefix]; n > 0 {
+			fmt.Fprintf(w, "#   %s* chains: %d (%s)\n", f.prefix, n, f.tool)
+		}
+	}
+	if inv.sets > 0 {
+		fmt.Fprintf(w, "#   IP sets matched by rules: %d\n", inv.sets)
+	}
+
+	var flags []string
+	if inv.sets > 0 && inv.commands["ipset"] {
+		flags = append(flags, "--iptables.set-label", "--iptables.set-entries")
+	}
+	if len(inv.marks) > 0 {
+		flags = append(flags, "--iptables.mark-label")
+	}
+	if len(inv.commentKeys) > 0 {
+		flags = append(flags, "--iptables.comment-labels="+strings.Join(inv.commentKeys, ","))
+	}
+	if inv.customChains {
+		flags = append(flags, "--iptables.hook-label")
+	}
+	if inv.nflog {
+		flags = append(flags, "--collector.nflog")
+	}
+	if len(inv.tables) == 2 {
+		flags = append(flags, "--iptables.merge-families")
+	}
+	if len(flags) > 0 {
+		fmt.Fprintln(w, "#")
+		fmt.Fprintln(w, "# Suggested flags:")
+		for _, flag := range flags {
+			fmt.Fprintf(w, "#   %s\n", flag)
+		}
+	}
+	fmt.Fprintln(w)
+
+	var excluded []string
+	for _, f := range chainFamilies {
+		if f.noisy && inv.chains[f.prefix] > 0 {
+			excluded = append(excluded, fmt.Sprintf("!chain.startsWith(%q)", f.prefix))
+		}
+	}
+	fmt.Fprintln(w, "rules:")
+	if len(excluded) > 0 {
+		fmt.Fprintln(w, "  # Leave out chains that come and go with workloads; each of their rules")
+		fmt.Fprintln(w, "  # would be a short-lived series.")
+		fmt.Fprintf(w, "  filter: '%s'\n", strings.Join(excluded, " && "))
+	} else {
+		fmt.Fprintln(w, "  # filter: 'table == \"filter\"'")
+	}
+	fmt.Fprintln(w, "  labels:")
+	fmt.Fprintln(w, "    # target: 'rule.target'")
+
+	if len(inv.marks) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "# Name the firewall marks used by rules for --iptables.mark-label.")
+		fmt.Fprintln(w, "marks:")
+		for _, mark := range inv.marks {
+			fmt.Fprintf(w, "  # %q: name\n", mark)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "compliance:")
+	for _, chain := range []string{"INPUT", "FORWARD"} {
+		policy, ok := inv.policies[chain]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "  # Currently %s.\n", policy)
+		prefix := "  "
+		if policy != "DROP" {
+			prefix = "  # "
+		}
+		fmt.Fprintf(w, "%s- name: %s-policy-drop\n", prefix, strings.ToLower(chain))
+		fmt.Fprintf(w, "%s  ip_family: ipv4\n", prefix)
+		fmt.Fprintf(w, "%s  table: filter\n", prefix)
+		fmt.Fprintf(w, "%s  chain: %s\n", prefix, chain)
+		fmt.Fprintf(w, "%s  policy: DROP\n", prefix)
+	}
+	if len(inv.policies) == 0 {
+		fmt.Fprintln(w, "  []")
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// collectionHealth tracks the outcome of recent collections to answer
+// readiness probes.
+type collectionHealth struct {
+	mu                  sync.Mutex
+	failureThreshold    int
+	succeeded           bool
+	consecutiveFailures int
+	failures            int
+	lastErr             error
+}
+
+func newCollectionHealth(failureThreshold int) *collectionHealth {
+	return &collectionHealth{failureThreshold: failureThreshold}
+}
+
+func (h *collectionHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.consecutiveFailures++
+		h.failures++
+		h.lastErr = err
+		return
+	}
+	h.succeeded = true
+	h.consecutiveFailures = 0
+	h.lastErr = nil
+}
+
+// ready returns nil once a collection succeeded and as long as fewer than
+// failureThreshold collections failed in a row since.
+func (h *collectionHealth) ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.succeeded {
+		if h.lastErr != nil {
+			return fmt.Errorf("no successful collection yet: %s", h.lastErr)
+		}
+		return fmt.Errorf("no successful collection yet")
+	}
+	if h.failureThreshold > 0 && h.consecutiveFailures >= h.failureThreshold {
+		return fmt.Errorf("%d consecutive collections failed: %s", h.consecutiveFailures, h.lastErr)
+	}
+	return nil
+}
+
+// status returns whether the last collection succeeded and how many failed
+// so far.
+func (h *collectionHealth) status() (ok bool, failures int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.succeeded && h.consecutiveFailures == 0, h.failures
+}
+
+// lastError returns the error of the last failed collection, if any.
+func (h *collectionHealth) lastError() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastErr
+}
+
+// healthzHandler reports liveness: the process is up and serving HTTP.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK\n"))
+}
+
+// readyzHandler reports whether the recorded collections make the exporter
+// ready. It doesn't collect anything itself, so probes are cheap however
+// often they come.
+func readyzHandler(h *collectionHealth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	}
+}
+
+// familyAvailability remembers which IP families could be collected last
+// time, so a family that is missing by design is only logged about once
+// instead of on every scrape.
+type familyAvailability struct {
+	// target names the host in log messages, empty for the local one
+	target string
+
+	mu        sync.Mutex
+	available map[iptables.Family]bool
+	// timeouts counts the collections of every family that were killed
+	// because they took longer than the timeout.
+	timeouts map[iptables.Family]uint64
+}
+
+func newFamilyAvailability(target string) *familyAvailability {
+	return &familyAvailability{target: target, available: make(map[iptables.Family]bool), timeouts: make(map[iptables.Family]uint64)}
+}
+
+// timedOut returns the number of collections of family that timed out.
+func (a *familyAvailability) timedOut(family iptables.Family) uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.timeouts[family]
+}
+
+func (a *familyAvailability) record(family iptables.Family, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	was, known := a.available[family]
+	a.available[family] = err == nil
+	if errors.Is(err, context.DeadlineExceeded) {
+		a.timeouts[family]++
+	}
+	name := string(family)
+	if a.target != "" {
+		name += " of " + a.target
+	}
+	switch {
+	case err != nil && (was || !known):
+		log.Warnf("Not collecting %s until it becomes available: %s", name, err)
+	case err == nil && known && !was:
+		log.Infof("Collecting %s again", name)
+	}
+}
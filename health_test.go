@@ -0,0 +1,57 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	failed := errors.New("iptables-save: exit status 1")
+	for _, tc := range []struct {
+		name             string
+		failureThreshold int
+		collections      []error
+		ready            int
+	}{
+		{name: "before the first collection", failureThreshold: 3, ready: http.StatusServiceUnavailable},
+		{name: "first collection failed", failureThreshold: 3, collections: []error{failed}, ready: http.StatusServiceUnavailable},
+		{name: "first collection succeeded", failureThreshold: 3, collections: []error{nil}, ready: http.StatusOK},
+		{name: "recovered", failureThreshold: 3, collections: []error{failed, nil}, ready: http.StatusOK},
+		{name: "below the threshold", failureThreshold: 3, collections: []error{nil, failed, failed}, ready: http.StatusOK},
+		{name: "at the threshold", failureThreshold: 3, collections: []error{nil, failed, failed, failed}, ready: http.StatusServiceUnavailable},
+		{name: "failures apart", failureThreshold: 2, collections: []error{nil, failed, nil, failed}, ready: http.StatusOK},
+		{name: "no threshold", collections: []error{nil, failed, failed, failed}, ready: http.StatusOK},
+	} {
+		h := newCollectionHealth(tc.failureThreshold)
+		for _, err := range tc.collections {
+			h.record(err)
+		}
+		// Liveness doesn't depend on the collections.
+		w := httptest.NewRecorder()
+		healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: /healthz: expected %d, got %d", tc.name, http.StatusOK, w.Code)
+		}
+		w = httptest.NewRecorder()
+		readyzHandler(h)(w, httptest.NewRequest("GET", "/readyz", nil))
+		if w.Code != tc.ready {
+			t.Fatalf("%s: /readyz: expected %d, got %d: %s", tc.name, tc.ready, w.Code, w.Body)
+		}
+	}
+}
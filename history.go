@@ -0,0 +1,151 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// collectionObserver is notified of every successful collection.
+type collectionObserver interface {
+	observe(now time.Time, families map[iptables.Family]iptables.Tables)
+}
+
+// history keeps the collections of the last retention period in memory.
+type history struct {
+	retention time.Duration
+
+	mu        sync.Mutex
+	snapshots []historySnapshot
+}
+
+type historySnapshot struct {
+	time     time.Time
+	families map[iptables.Family]iptables.Tables
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{retention: retention}
+}
+
+func (h *history) observe(now time.Time, families map[iptables.Family]iptables.Tables) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshots = append(h.snapshots, historySnapshot{time: now, families: families})
+	cutoff := now.Add(-h.retention)
+	i := 0
+	for i < len(h.snapshots) && h.snapshots[i].time.Before(cutoff) {
+		i++
+	}
+	h.snapshots = append(h.snapshots[:0], h.snapshots[i:]...)
+}
+
+// since returns the snapshots taken after t, oldest first.
+func (h *history) since(t time.Time) []historySnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var result []historySnapshot
+	for _, s := range h.snapshots {
+		if !s.time.Before(t) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+type historyResponse struct {
+	Snapshots []historySnapshotJSON `json:"snapshots"`
+}
+
+type historySnapshotJSON struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Chains    []historyChainJSON `json:"chains"`
+}
+
+type historyChainJSON struct {
+	Family  iptables.Family   `json:"ip_family"`
+	Table   string            `json:"table"`
+	Chain   string            `json:"chain"`
+	Policy  string            `json:"policy"`
+	Packets uint64            `json:"packets"`
+	Bytes   uint64            `json:"bytes"`
+	Rules   []historyRuleJSON `json:"rules"`
+}
+
+type historyRuleJSON struct {
+	Rule    string `json:"rule"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// ServeHTTP answers /api/v1/history. The optional parameters ip_family,
+// table and chain select chains, since (a duration, default 10m) limits how
+// far back snapshots are returned.
+func (h *history) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since := 10 * time.Minute
+	if s := q.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = d
+	}
+	family, tableName, chainName := q.Get("ip_family"), q.Get("table"), q.Get("chain")
+
+	response := historyResponse{Snapshots: []historySnapshotJSON{}}
+	for _, s := range h.since(time.Now().Add(-since)) {
+		snapshot := historySnapshotJSON{Timestamp: s.time, Chains: []historyChainJSON{}}
+		for _, f := range iptables.Families {
+			if family != "" && family != string(f) {
+				continue
+			}
+			for _, name := range s.families[f].Names() {
+				if tableName != "" && tableName != name {
+					continue
+				}
+				table := s.families[f][name]
+				for _, cname := range table.ChainNames() {
+					if chainName != "" && chainName != cname {
+						continue
+					}
+					chain := table[cname]
+					entry := historyChainJSON{
+						Family:  f,
+						Table:   name,
+						Chain:   cname,
+						Policy:  chain.Policy,
+						Packets: chain.Packets,
+						Bytes:   chain.Bytes,
+						Rules:   make([]historyRuleJSON, len(chain.Rules)),
+					}
+					for i, rule := range chain.Rules {
+						entry.Rules[i] = historyRuleJSON{Rule: rule.Rule, Packets: rule.Packets, Bytes: rule.Bytes}
+					}
+					snapshot.Chains = append(snapshot.Chains, entry)
+				}
+			}
+		}
+		response.Snapshots = append(response.Snapshots, snapshot)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
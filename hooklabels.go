@@ -0,0 +1,55 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// tablesLabeler is implemented by labelers that need to look at all tables
+// of a family before labeling their rules.
+type tablesLabeler interface {
+	// forTables returns the labeler for the rules of tables.
+	forTables(tables iptables.Tables) ruleLabeler
+}
+
+// hookLabeler exports the netfilter hook a rule's chain is attached to as
+// "hook" label. Custom chains get the hook of the built-in chains jumping to
+// them, if they are only reachable from a single hook.
+type hookLabeler struct {
+	// hooks maps table and chain names to hooks
+	hooks map[string]map[string]string
+}
+
+func (hookLabeler) labelNames() []string {
+	return []string{"hook"}
+}
+
+func (hookLabeler) forTables(tables iptables.Tables) ruleLabeler {
+	l := hookLabeler{hooks: make(map[string]map[string]string)}
+	for tableName, table := range tables {
+		l.hooks[tableName] = make(map[string]string)
+		for chain, hooks := range table.Hooks() {
+			if len(hooks) == 1 {
+				l.hooks[tableName][chain] = hooks[0]
+			}
+		}
+	}
+	return l
+}
+
+func (l hookLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{l.hooks[table][chain]}, false
+}
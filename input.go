@@ -0,0 +1,96 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// fileSource parses dumps of the tables from files instead of running the
+// save commands, e.g. to try capture expressions on a ruleset offline. The
+// files are read again on every collection.
+type fileSource struct {
+	paths map[iptables.Family]string
+	// stdin holds the dump read from stdin at startup, for the family
+	// whose path is "-".
+	stdin []byte
+}
+
+func newFileSource(paths map[iptables.Family]string) (*fileSource, error) {
+	s := &fileSource{paths: paths}
+	for _, path := range paths {
+		if path != "-" {
+			continue
+		}
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		s.stdin = data
+	}
+	return s, nil
+}
+
+func (s *fileSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	path, ok := s.paths[opts.Family]
+	if !ok {
+		return nil, iptables.ParseStats{}, fmt.Errorf("no input file for %s", opts.Family)
+	}
+	if path == "-" {
+		return iptables.ReadTablesStats(bytes.NewReader(s.stdin), opts)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, iptables.ParseStats{}, err
+	}
+	defer f.Close()
+	tables, stats, err := iptables.ReadTablesStats(f, opts)
+	if err != nil {
+		return nil, stats, fmt.Errorf("%s: %s", path, err)
+	}
+	return tables, stats, nil
+}
+
+// familyFileFlags are the values of a pair of flags naming a file for
+// either IP family.
+type familyFileFlags struct {
+	v4, v6 *string
+}
+
+func newInputFileFlags(app *kingpin.Application) familyFileFlags {
+	return familyFileFlags{
+		v4: app.Flag("iptables.input-file", "Parse the IPv4 tables from this dump, the output of iptables-save -c, instead of running iptables-save, or from stdin if -.").String(),
+		v6: app.Flag("iptables.input-file-v6", "Parse the IPv6 tables from this dump instead of running ip6tables-save, or from stdin if -.").String(),
+	}
+}
+
+// files returns the files given per IP family.
+func (f familyFileFlags) files() map[iptables.Family]string {
+	files := make(map[iptables.Family]string)
+	if *f.v4 != "" {
+		files[iptables.IPv4] = *f.v4
+	}
+	if *f.v6 != "" {
+		files[iptables.IPv6] = *f.v6
+	}
+	return files
+}
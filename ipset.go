@@ -0,0 +1,84 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/ipset"
+)
+
+var (
+	ipsetEntriesDesc = prometheus.NewDesc(
+		"iptables_ipset_entries",
+		"iptables_exporter: Number of entries of an IP set.",
+		[]string{"set", "type"},
+		nil,
+	)
+
+	ipsetMaxEntriesDesc = prometheus.NewDesc(
+		"iptables_ipset_max_entries",
+		"iptables_exporter: Maximum number of entries of an IP set, its maxelem or size.",
+		[]string{"set", "type"},
+		nil,
+	)
+
+	ipsetMemoryDesc = prometheus.NewDesc(
+		"iptables_ipset_memory_bytes",
+		"iptables_exporter: Size in memory of an IP set.",
+		[]string{"set", "type"},
+		nil,
+	)
+
+	ipsetReferencesDesc = prometheus.NewDesc(
+		"iptables_ipset_references",
+		"iptables_exporter: Number of rules and list sets referencing an IP set.",
+		[]string{"set", "type"},
+		nil,
+	)
+)
+
+// ipsetCollector exports the size of every IP set, listed with ipset list
+// -t, whether or not a rule matches it: a full set silently stops taking new
+// entries, so the rules matching it stop matching them.
+type ipsetCollector struct{}
+
+func (c ipsetCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- ipsetEntriesDesc
+	descChan <- ipsetMaxEntriesDesc
+	descChan <- ipsetMemoryDesc
+	descChan <- ipsetReferencesDesc
+}
+
+func (c ipsetCollector) update(metricChan chan<- prometheus.Metric) error {
+	start := time.Now()
+	sets, err := ipset.List()
+	timeExec("ipset", "", start)
+	if err != nil {
+		return err
+	}
+	for _, set := range sets {
+		if set.EntriesKnown {
+			metricChan <- prometheus.MustNewConstMetric(ipsetEntriesDesc, prometheus.GaugeValue, float64(set.Entries), set.Name, set.Type)
+		}
+		if max, ok := set.MaxEntries(); ok {
+			metricChan <- prometheus.MustNewConstMetric(ipsetMaxEntriesDesc, prometheus.GaugeValue, float64(max), set.Name, set.Type)
+		}
+		metricChan <- prometheus.MustNewConstMetric(ipsetMemoryDesc, prometheus.GaugeValue, float64(set.MemorySize), set.Name, set.Type)
+		metricChan <- prometheus.MustNewConstMetric(ipsetReferencesDesc, prometheus.GaugeValue, float64(set.References), set.Name, set.Type)
+	}
+	return nil
+}
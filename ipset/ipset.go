@@ -0,0 +1,113 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipset reads the headers of the kernel's IP sets.
+package ipset
+
+import (
+	"bufio"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Set is the header of an IP set as listed by ipset list -t.
+type Set struct {
+	Name string
+	Type string
+	// Header holds the creation options, e.g. "maxelem" or "family".
+	Header       map[string]string
+	MemorySize   uint64
+	References   uint64
+	Entries      uint64
+	EntriesKnown bool
+}
+
+// List runs ipset list -t and parses its output.
+func List() ([]Set, error) {
+	out, err := exec.Command("ipset", "list", "-t").Output()
+	if err != nil {
+		return nil, err
+	}
+	return Parse(strings.NewReader(string(out)))
+}
+
+// Parse parses the output of ipset list -t.
+func Parse(r io.Reader) ([]Set, error) {
+	var sets []Set
+	var current *Set
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if key == "Name" {
+			sets = append(sets, Set{Name: value, Header: make(map[string]string)})
+			current = &sets[len(sets)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch key {
+		case "Type":
+			current.Type = value
+		case "Header":
+			fields := strings.Fields(value)
+			for i := 0; i < len(fields); i++ {
+				if i+1 < len(fields) && !isFlagOnly(fields[i]) {
+					current.Header[fields[i]] = fields[i+1]
+					i++
+				} else {
+					current.Header[fields[i]] = ""
+				}
+			}
+		case "Size in memory":
+			current.MemorySize, _ = strconv.ParseUint(value, 10, 64)
+		case "References":
+			current.References, _ = strconv.ParseUint(value, 10, 64)
+		case "Number of entries":
+			current.Entries, _ = strconv.ParseUint(value, 10, 64)
+			current.EntriesKnown = true
+		}
+	}
+	return sets, scanner.Err()
+}
+
+// MaxEntries returns the maximum number of entries of the set: maxelem of
+// hash sets or the size of list sets. ok is false for other types, e.g.
+// bitmap sets, whose range bounds them instead.
+func (s Set) MaxEntries() (max uint64, ok bool) {
+	value, ok := s.Header["maxelem"]
+	if !ok {
+		value, ok = s.Header["size"]
+	}
+	if !ok {
+		return 0, false
+	}
+	max, err := strconv.ParseUint(value, 10, 64)
+	return max, err == nil
+}
+
+// isFlagOnly reports whether a header option has no value.
+func isFlagOnly(option string) bool {
+	switch option {
+	case "counters", "comment", "skbinfo", "forceadd", "nomatch":
+		return true
+	}
+	return false
+}
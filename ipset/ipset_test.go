@@ -0,0 +1,77 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipset
+
+import (
+	"strings"
+	"testing"
+)
+
+const listOutput = `Name: blocklist
+Type: hash:ip
+Revision: 4
+Header: family inet hashsize 1024 maxelem 65536 counters
+Size in memory: 1264
+References: 1
+Number of entries: 42
+Name: zones
+Type: list:set
+Revision: 3
+Header: size 8
+Size in memory: 216
+References: 0
+Number of entries: 2
+Name: ports
+Type: bitmap:port
+Revision: 3
+Header: range 1024-65535
+Size in memory: 8392
+References: 2
+Number of entries: 0
+`
+
+func TestParse(t *testing.T) {
+	sets, err := Parse(strings.NewReader(listOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 3 {
+		t.Fatalf("expected 3 sets, got %d", len(sets))
+	}
+	for i, expected := range []struct {
+		name, typ                   string
+		entries, memory, references uint64
+		max                         uint64
+		maxKnown                    bool
+	}{
+		{"blocklist", "hash:ip", 42, 1264, 1, 65536, true},
+		{"zones", "list:set", 2, 216, 0, 8, true},
+		{"ports", "bitmap:port", 0, 8392, 2, 0, false},
+	} {
+		set := sets[i]
+		if set.Name != expected.name || set.Type != expected.typ {
+			t.Errorf("set %d: expected %s %s, got %s %s", i, expected.name, expected.typ, set.Name, set.Type)
+		}
+		if !set.EntriesKnown || set.Entries != expected.entries || set.MemorySize != expected.memory || set.References != expected.references {
+			t.Errorf("%s: unexpected sizes %+v", set.Name, set)
+		}
+		if max, ok := set.MaxEntries(); max != expected.max || ok != expected.maxKnown {
+			t.Errorf("%s: expected maximum %d (%v), got %d (%v)", set.Name, expected.max, expected.maxKnown, max, ok)
+		}
+	}
+	if _, ok := sets[0].Header["counters"]; !ok {
+		t.Errorf("counters flag missing from the header %v", sets[0].Header)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Hash returns a digest of the rules and policies of the tables, ignoring
+// counters, so it only changes if the ruleset does.
+func (t Tables) Hash() string {
+	h := sha256.New()
+	for _, tableName := range t.Names() {
+		table := t[tableName]
+		fmt.Fprintf(h, "*%s\n", tableName)
+		for _, chainName := range table.ChainNames() {
+			fmt.Fprintf(h, ":%s %s\n", chainName, table[chainName].Policy)
+		}
+		for _, chainName := range table.ChainNames() {
+			for _, rule := range table[chainName].Rules {
+				fmt.Fprintf(h, "-A %s %s\n", chainName, rule.Text)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChainDiff describes how a chain differs between two rulesets.
+type ChainDiff struct {
+	Table string `json:"table"`
+	Chain string `json:"chain"`
+	// Added and Removed are set if the chain exists in only one ruleset.
+	Added   bool `json:"added,omitempty"`
+	Removed bool `json:"removed,omitempty"`
+	// OldPolicy and NewPolicy are set if the policy changed.
+	OldPolicy string `json:"old_policy,omitempty"`
+	NewPolicy string `json:"new_policy,omitempty"`
+	// AddedRules and RemovedRules list rule texts only found in one ruleset.
+	AddedRules   []string `json:"added_rules,omitempty"`
+	RemovedRules []string `json:"removed_rules,omitempty"`
+	// Reordered is true if both rulesets hold the same rules in a different
+	// order.
+	Reordered bool `json:"reordered,omitempty"`
+}
+
+// Diff compares the rulesets old and new, ignoring counters. Chains without
+// differences are left out; the result is sorted by table and chain.
+func Diff(old, new Tables) []ChainDiff {
+	var diffs []ChainDiff
+	tableNames := make(map[string]bool)
+	for name := range old {
+		tableNames[name] = true
+	}
+	for name := range new {
+		tableNames[name] = true
+	}
+	for tableName := range tableNames {
+		oldTable, newTable := old[tableName], new[tableName]
+		chainNames := make(map[string]bool)
+		for name := range oldTable {
+			chainNames[name] = true
+		}
+		for name := range newTable {
+			chainNames[name] = true
+		}
+		for chainName := range chainNames {
+			oldChain, inOld := oldTable[chainName]
+			newChain, inNew := newTable[chainName]
+			d := ChainDiff{Table: tableName, Chain: chainName, Added: !inOld, Removed: !inNew}
+			if inOld && inNew && oldChain.Policy != newChain.Policy {
+				d.OldPolicy, d.NewPolicy = oldChain.Policy, newChain.Policy
+			}
+			d.AddedRules, d.RemovedRules = diffRules(oldChain.Rules, newChain.Rules)
+			if len(d.AddedRules) == 0 && len(d.RemovedRules) == 0 && !sameOrder(oldChain.Rules, newChain.Rules) {
+				d.Reordered = true
+			}
+			if d.Added || d.Removed || d.OldPolicy != "" || d.Reordered || len(d.AddedRules) > 0 || len(d.RemovedRules) > 0 {
+				diffs = append(diffs, d)
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Table != diffs[j].Table {
+			return diffs[i].Table < diffs[j].Table
+		}
+		return diffs[i].Chain < diffs[j].Chain
+	})
+	return diffs
+}
+
+// diffRules compares rules as multisets of their texts.
+func diffRules(old, new []Rule) (added, removed []string) {
+	counts := make(map[string]int)
+	for _, r := range old {
+		counts[r.Text]++
+	}
+	for _, r := range new {
+		if counts[r.Text] > 0 {
+			counts[r.Text]--
+		} else {
+			added = append(added, r.Text)
+		}
+	}
+	for _, r := range old {
+		if counts[r.Text] > 0 {
+			counts[r.Text]--
+			removed = append(removed, r.Text)
+		}
+	}
+	return added, removed
+}
+
+func sameOrder(old, new []Rule) bool {
+	if len(old) != len(new) {
+		return false
+	}
+	for i := range old {
+		if old[i].Text != new[i].Text {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDiff(t *testing.T) {
+	old := Tables{"filter": {
+		"INPUT": {Policy: "ACCEPT", Rules: []Rule{
+			{Packets: 1, Text: "-p tcp -j ACCEPT"},
+			{Text: "-p udp -j ACCEPT"},
+		}},
+		"OUTPUT": {Policy: "ACCEPT", Rules: []Rule{{Text: "-j A"}, {Text: "-j B"}}},
+		"OLD":    {Policy: "-"},
+	}}
+	new := Tables{"filter": {
+		"INPUT": {Policy: "DROP", Rules: []Rule{
+			{Packets: 2, Text: "-p tcp -j ACCEPT"},
+			{Text: "-p icmp -j ACCEPT"},
+		}},
+		"OUTPUT": {Policy: "ACCEPT", Rules: []Rule{{Text: "-j B"}, {Text: "-j A"}}},
+	}}
+	expected := []ChainDiff{
+		{
+			Table:        "filter",
+			Chain:        "INPUT",
+			OldPolicy:    "ACCEPT",
+			NewPolicy:    "DROP",
+			AddedRules:   []string{"-p icmp -j ACCEPT"},
+			RemovedRules: []string{"-p udp -j ACCEPT"},
+		},
+		{Table: "filter", Chain: "OLD", Removed: true},
+		{Table: "filter", Chain: "OUTPUT", Reordered: true},
+	}
+	if mismatch := deep.Equal(expected, Diff(old, new)); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+	if old.Hash() == new.Hash() {
+		t.Fatalf("different rulesets have the same hash")
+	}
+	if Diff(old, old) != nil {
+		t.Fatalf("unexpected differences of a ruleset to itself")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"regexp"
+)
+
+// Filter selects tables and chains by name. A name is selected if it is
+// included, by name or matching an include regexp, or if there are no
+// includes at all, and it is not excluded by name or exclude regexp.
+type Filter struct {
+	IncludeTables   []string
+	ExcludeTables   []string
+	IncludeTablesRE *regexp.Regexp
+	ExcludeTablesRE *regexp.Regexp
+
+	IncludeChains   []string
+	ExcludeChains   []string
+	IncludeChainsRE *regexp.Regexp
+	ExcludeChainsRE *regexp.Regexp
+}
+
+// selects reports whether name is selected by the includes and excludes.
+func selects(name string, include, exclude []string, includeRE, excludeRE *regexp.Regexp) bool {
+	if contains(exclude, name) || excludeRE != nil && excludeRE.MatchString(name) {
+		return false
+	}
+	if len(include) == 0 && includeRE == nil {
+		return true
+	}
+	return contains(include, name) || includeRE != nil && includeRE.MatchString(name)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Table reports whether the filter selects the table.
+func (f *Filter) Table(table string) bool {
+	return selects(table, f.IncludeTables, f.ExcludeTables, f.IncludeTablesRE, f.ExcludeTablesRE)
+}
+
+// Chain reports whether the filter selects the chain and its table. It can
+// be given to ParseIptablesSaveChains to skip the rules of other chains.
+func (f *Filter) Chain(table, chain string) bool {
+	return f.Table(table) && selects(chain, f.IncludeChains, f.ExcludeChains, f.IncludeChainsRE, f.ExcludeChainsRE)
+}
+
+// Tables returns the tables to dump with the save command, or nil for all
+// of them if the tables aren't only included by name.
+func (f *Filter) Tables() []string {
+	if f.IncludeTablesRE != nil {
+		return nil
+	}
+	var tables []string
+	for _, table := range f.IncludeTables {
+		if f.Table(table) {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// Apply removes the tables and chains the filter doesn't select.
+func (f *Filter) Apply(tables Tables) {
+	for tableName, table := range tables {
+		if !f.Table(tableName) {
+			delete(tables, tableName)
+			continue
+		}
+		for chainName := range table {
+			if !f.Chain(tableName, chainName) {
+				delete(table, chainName)
+			}
+		}
+	}
+}
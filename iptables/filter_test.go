@@ -0,0 +1,68 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestFilter(t *testing.T) {
+	tables := func() Tables {
+		return Tables{
+			"filter": {"INPUT": {}, "FORWARD": {}, "KUBE-SERVICES": {}, "KUBE-SVC-1": {}},
+			"nat":    {"PREROUTING": {}, "KUBE-SERVICES": {}},
+			"raw":    {"PREROUTING": {}},
+		}
+	}
+	for _, c := range []struct {
+		name     string
+		filter   Filter
+		dump     []string
+		expected Tables
+	}{
+		{
+			name:     "empty",
+			expected: tables(),
+		},
+		{
+			name:     "include tables",
+			filter:   Filter{IncludeTables: []string{"nat", "raw"}, ExcludeTables: []string{"raw"}},
+			dump:     []string{"nat"},
+			expected: Tables{"nat": {"PREROUTING": {}, "KUBE-SERVICES": {}}},
+		},
+		{
+			name:     "exclude chains by regexp",
+			filter:   Filter{ExcludeTables: []string{"raw"}, ExcludeChainsRE: regexp.MustCompile("^KUBE-")},
+			expected: Tables{"filter": {"INPUT": {}, "FORWARD": {}}, "nat": {"PREROUTING": {}}},
+		},
+		{
+			name:     "include chains",
+			filter:   Filter{IncludeTablesRE: regexp.MustCompile("^(filter|raw)$"), IncludeChains: []string{"INPUT"}, IncludeChainsRE: regexp.MustCompile("^KUBE-SVC-")},
+			expected: Tables{"filter": {"INPUT": {}, "KUBE-SVC-1": {}}, "raw": {}},
+		},
+	} {
+		if mismatch := deep.Equal(c.dump, c.filter.Tables()); mismatch != nil {
+			t.Errorf("%s: tables to dump: %+v", c.name, mismatch)
+		}
+		result := tables()
+		c.filter.Apply(result)
+		if mismatch := deep.Equal(c.expected, result); mismatch != nil {
+			t.Errorf("%s: %+v", c.name, mismatch)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import "sort"
+
+// builtinHooks maps built-in chains to the netfilter hook they are attached
+// to.
+var builtinHooks = map[string]string{
+	"PREROUTING":  "prerouting",
+	"INPUT":       "input",
+	"FORWARD":     "forward",
+	"OUTPUT":      "output",
+	"POSTROUTING": "postrouting",
+}
+
+// BuiltinHook returns the hook a built-in chain is attached to.
+func BuiltinHook(chain string) (string, bool) {
+	hook, ok := builtinHooks[chain]
+	return hook, ok
+}
+
+// Jump is a rule passing packets on to another chain of the same table.
+type Jump struct {
+	From string
+	To   string
+	// Goto is true for -g, false for -j.
+	Goto bool
+	// Packets and Bytes are the counters of the jumping rule.
+	Packets uint64
+	Bytes   uint64
+}
+
+// Jumps returns all rules of the table targeting one of its chains, sorted
+// by source and target chain.
+func (t Table) Jumps() []Jump {
+	var jumps []Jump
+	for name, chain := range t {
+		for _, rule := range chain.Rules {
+			spec := rule.Spec()
+			if _, ok := t[spec.Target]; !ok {
+				continue
+			}
+			jumps = append(jumps, Jump{
+				From:    name,
+				To:      spec.Target,
+				Goto:    spec.Goto,
+				Packets: rule.Packets,
+				Bytes:   rule.Bytes,
+			})
+		}
+	}
+	sort.SliceStable(jumps, func(i, j int) bool {
+		if jumps[i].From != jumps[j].From {
+			return jumps[i].From < jumps[j].From
+		}
+		return jumps[i].To < jumps[j].To
+	})
+	return jumps
+}
+
+// Hooks returns, for every chain of the table, the sorted hooks of the
+// built-in chains it can be reached from.
+func (t Table) Hooks() map[string][]string {
+	next := make(map[string][]string)
+	for _, jump := range t.Jumps() {
+		next[jump.From] = append(next[jump.From], jump.To)
+	}
+	reached := make(map[string]map[string]bool)
+	for chain := range t {
+		hook, ok := BuiltinHook(chain)
+		if !ok {
+			continue
+		}
+		queue := []string{chain}
+		seen := map[string]bool{chain: true}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			if reached[current] == nil {
+				reached[current] = make(map[string]bool)
+			}
+			reached[current][hook] = true
+			for _, to := range next[current] {
+				if !seen[to] {
+					seen[to] = true
+					queue = append(queue, to)
+				}
+			}
+		}
+	}
+	hooks := make(map[string][]string)
+	for chain, set := range reached {
+		for hook := range set {
+			hooks[chain] = append(hooks[chain], hook)
+		}
+		sort.Strings(hooks[chain])
+	}
+	return hooks
+}
+
+// targetExtensions are the targets that are not chains: the built-in
+// verdicts, the target extensions shipped with iptables and those of
+// xtables-addons.
+var targetExtensions = map[string]bool{
+	"ACCEPT": true, "DROP": true, "RETURN": true, "QUEUE": true,
+	"AUDIT": true, "CHECKSUM": true, "CLASSIFY": true, "CLUSTERIP": true,
+	"CONNMARK": true, "CONNSECMARK": true, "CT": true, "DNAT": true,
+	"DNPT": true, "DSCP": true, "ECN": true, "HL": true, "HMARK": true,
+	"IDLETIMER": true, "LED": true, "LOG": true, "MARK": true,
+	"MASQUERADE": true, "MIRROR": true, "NETMAP": true, "NFLOG": true,
+	"NFQUEUE": true, "NOTRACK": true, "RATEEST": true, "REDIRECT": true,
+	"REJECT": true, "SAME": true, "SECMARK": true, "SET": true, "SNAT": true,
+	"SNPT": true, "SYNPROXY": true, "TCPMSS": true, "TCPOPTSTRIP": true,
+	"TEE": true, "TOS": true, "TPROXY": true, "TRACE": true, "TTL": true,
+	"ULOG":    true,
+	"ACCOUNT": true, "CHAOS": true, "DELUDE": true, "DHCPMAC": true,
+	"DNETMAP": true, "ECHO": true, "IPMARK": true, "LOGMARK": true,
+	"PROTO": true, "RAWDNAT": true, "RAWSNAT": true, "STEAL": true,
+	"SYSRQ": true, "TARPIT": true,
+}
+
+// IsTargetExtension reports whether target is a verdict or target extension
+// rather than a chain.
+func IsTargetExtension(target string) bool {
+	return targetExtensions[target]
+}
+
+// ChainReference counts the rules of a chain targeting another chain.
+type ChainReference struct {
+	Chain  string
+	Target string
+	Rules  int
+}
+
+// UndefinedReferences returns references to chains missing from the table,
+// sorted by chain and target.
+func (t Table) UndefinedReferences() []ChainReference {
+	counts := make(map[[2]string]int)
+	for name, chain := range t {
+		for _, rule := range chain.Rules {
+			target := rule.Spec().Target
+			if target == "" || IsTargetExtension(target) {
+				continue
+			}
+			if _, ok := t[target]; !ok {
+				counts[[2]string{name, target}]++
+			}
+		}
+	}
+	refs := make([]ChainReference, 0, len(counts))
+	for key, n := range counts {
+		refs = append(refs, ChainReference{Chain: key[0], Target: key[1], Rules: n})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Chain != refs[j].Chain {
+			return refs[i].Chain < refs[j].Chain
+		}
+		return refs[i].Target < refs[j].Target
+	})
+	return refs
+}
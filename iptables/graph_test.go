@@ -0,0 +1,67 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+var graphTestTable = Table{
+	"INPUT": {Policy: "DROP", Rules: []Rule{
+		{Text: "-p tcp -j SSH"},
+		{Text: "-j LOGDROP"},
+	}},
+	"OUTPUT": {Policy: "ACCEPT", Rules: []Rule{
+		{Text: "-g LOGDROP"},
+	}},
+	"SSH":     {Policy: "-", Rules: []Rule{{Text: "-s 192.0.2.1/32 -j DROP"}}},
+	"LOGDROP": {Policy: "-", Rules: []Rule{{Text: "-j LOG"}, {Text: "-j DROP"}}},
+	"UNUSED":  {Policy: "-"},
+}
+
+func TestTableHooks(t *testing.T) {
+	expected := map[string][]string{
+		"INPUT":   {"input"},
+		"OUTPUT":  {"output"},
+		"SSH":     {"input"},
+		"LOGDROP": {"input", "output"},
+	}
+	if mismatch := deep.Equal(expected, graphTestTable.Hooks()); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
+
+func TestTableUndefinedReferences(t *testing.T) {
+	table := Table{
+		"INPUT": {Policy: "ACCEPT", Rules: []Rule{
+			{Text: "-p tcp -j KUBE-SVC-GONE"},
+			{Text: "-p udp -j KUBE-SVC-GONE"},
+			{Text: "-j REJECT --reject-with tcp-reset"},
+			{Text: "-j SSH"},
+			{Text: "-j TARPIT"},
+			{Text: "-j DOCKER"},
+		}},
+		"SSH": {Policy: "-"},
+	}
+	expected := []ChainReference{
+		{Chain: "INPUT", Target: "DOCKER", Rules: 1},
+		{Chain: "INPUT", Target: "KUBE-SVC-GONE", Rules: 2},
+	}
+	if mismatch := deep.Equal(expected, table.UndefinedReferences()); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
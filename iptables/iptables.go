@@ -15,39 +15,247 @@
 package iptables
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"regexp"
+	"strings"
+	"time"
 )
 
+// Family is an IP protocol family with its own set of tables.
+type Family string
+
+const (
+	IPv4 Family = "ipv4"
+	IPv6 Family = "ipv6"
+)
+
+// Families lists every family in the order it is collected.
+var Families = []Family{IPv4, IPv6}
+
+// SaveCommand returns the name of the command dumping the family's tables.
+func (f Family) SaveCommand() string {
+	if f == IPv6 {
+		return "ip6tables-save"
+	}
+	return "iptables-save"
+}
+
+// Options control how GetTablesContext collects tables.
+type Options struct {
+	// Family selects the save command to run, IPv4 by default.
+	Family Family
+	// Tables selects the tables to dump; all tables by default.
+	Tables []string
+	// Capture computes the Rule of every rule, see CaptureLabel. Rules it
+	// doesn't match are left out; nil keeps all rules.
+	Capture *regexp.Regexp
+	// SkipRules leaves out all rules, keeping only chains and their policy
+	// counters.
+	SkipRules bool
+	// Chains, if set, selects the chains whose rules are read, see
+	// ParseIptablesSaveChains.
+	Chains func(table, chain string) bool
+	// Timeout, if positive, limits how long the save command may run.
+	Timeout time.Duration
+	// Exec, if set, is the command and arguments to run the save command
+	// with, e.g. ssh and its options to collect a remote host.
+	Exec []string
+	// Exited, if set, is called with the state of every command run once
+	// it exited, e.g. to account for its resource usage. binary is the
+	// command run, the first of Exec if set.
+	Exited func(binary string, state *os.ProcessState)
+}
+
+var matchAll = regexp.MustCompile(".*")
+
+// GetTablesContext runs the save command selected by opts and parses its
+// output. The command is killed if ctx is done or the timeout expires.
+func GetTablesContext(ctx context.Context, opts Options) (Tables, error) {
+	tables, _, err := GetTablesStats(ctx, opts)
+	return tables, err
+}
+
+// GetTablesStats is GetTablesContext, also returning parse statistics.
+func GetTablesStats(ctx context.Context, opts Options) (Tables, ParseStats, error) {
+	family := opts.Family
+	if family == "" {
+		family = IPv4
+	}
+	return dump(ctx, opts, saveCommand{name: family.SaveCommand(), parse: ParseIptablesSaveChains})
+}
+
+// saveCommand is a command writing tables in the format of iptables-save.
+type saveCommand struct {
+	name string
+	// env is added to the environment of the command.
+	env   []string
+	parse func(r io.Reader, capture *regexp.Regexp, chains func(table, chain string) bool) (Tables, ParseStats, error)
+}
+
+// dump runs save, dumping the tables opts selects, and parses its output.
+func dump(ctx context.Context, opts Options, save saveCommand) (Tables, ParseStats, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	capture := opts.capture()
+	if len(opts.Tables) == 0 {
+		return runSave(ctx, opts, save, capture, "-c")
+	}
+	result := make(Tables)
+	var total ParseStats
+	for _, table := range opts.Tables {
+		tables, stats, err := runSave(ctx, opts, save, capture, "-c", "-t", table)
+		total.Lines += stats.Lines
+		total.Rules += stats.Rules
+		total.RulesNotCaptured += stats.RulesNotCaptured
+		total.RulesSkipped += stats.RulesSkipped
+		if err != nil {
+			return nil, total, err
+		}
+		for name, t := range tables {
+			result[name] = t
+		}
+	}
+	return result, total, nil
+}
+
+// ReadTablesStats parses a dump of the tables, e.g. a file written by
+// iptables-save -c, like GetTablesStats parses the output of the save
+// command, keeping only opts.Tables if set. opts.Family, Timeout, Exec and
+// Exited don't apply.
+func ReadTablesStats(r io.Reader, opts Options) (Tables, ParseStats, error) {
+	tables, stats, err := ParseIptablesSaveChains(r, opts.capture(), opts.Chains)
+	if err != nil || len(opts.Tables) == 0 {
+		return tables, stats, err
+	}
+	selected := make(Tables)
+	for _, name := range opts.Tables {
+		if table, ok := tables[name]; ok {
+			selected[name] = table
+		}
+	}
+	return selected, stats, nil
+}
+
+// capture returns the expression computing the Rule of rules, nil if rules
+// are skipped.
+func (opts Options) capture() *regexp.Regexp {
+	if opts.SkipRules {
+		return nil
+	}
+	if opts.Capture == nil {
+		return matchAll
+	}
+	return opts.Capture
+}
+
+// GetTables runs iptables-save and parses its output. See GetTablesContext
+// for other families, timeouts and more options.
+//
+// Deprecated: use GetTablesContext.
 func GetTables(capture *regexp.Regexp) (Tables, error) {
-	cmd := exec.Command("iptables-save", "-c")
+	return GetTablesContext(context.Background(), Options{Family: IPv4, Capture: capture})
+}
+
+// GetFamilyTables runs the save command of family and parses its output. A
+// nil capture skips the rules, see ParseIptablesSave.
+//
+// Deprecated: use GetTablesContext.
+func GetFamilyTables(family Family, capture *regexp.Regexp) (Tables, error) {
+	tables, _, err := GetFamilyTablesStats(family, capture)
+	return tables, err
+}
+
+// GetFamilyTablesStats is GetFamilyTables, also returning parse statistics.
+//
+// Deprecated: use GetTablesStats.
+func GetFamilyTablesStats(family Family, capture *regexp.Regexp) (Tables, ParseStats, error) {
+	return GetTablesStats(context.Background(), Options{Family: family, Capture: capture, SkipRules: capture == nil})
+}
+
+// runSave runs save with args, prefixed by opts.Exec, and parses its output,
+// reading the chains opts.Chains selects.
+func runSave(ctx context.Context, opts Options, save saveCommand, capture *regexp.Regexp, args ...string) (Tables, ParseStats, error) {
+	command := append(append(append([]string(nil), opts.Exec...), save.name), args...)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	if len(save.env) > 0 {
+		cmd.Env = append(os.Environ(), save.env...)
+	}
 	pipe, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return nil, ParseStats{}, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, ParseStats{}, err
 	}
+	var stderr bytes.Buffer
+	stderrDone := make(chan struct{})
+	go func() {
+		io.Copy(&stderr, stderrPipe)
+		close(stderrDone)
+	}()
 
+	// Buffered so the parser doesn't leak if the command fails to start
 	resultCh := make(chan struct {
 		Tables
+		ParseStats
 		error
-	})
+	}, 1)
 	go func() {
-		result, parseErr := ParseIptablesSave(pipe, capture)
+		result, stats, parseErr := save.parse(pipe, capture, opts.Chains)
 		resultCh <- struct {
 			Tables
+			ParseStats
 			error
-		}{result, parseErr}
+		}{result, stats, parseErr}
 	}()
 
 	err = cmd.Start()
 	if err != nil {
-		return nil, err
+		return nil, ParseStats{}, err
 	}
 
-	r := <-resultCh
+	var r struct {
+		Tables
+		ParseStats
+		error
+	}
+	// The command gets killed once ctx is done, but anything it started
+	// may hold its output open, so stop reading rather than wait for that
+	// to exit too.
+	select {
+	case r = <-resultCh:
+	case <-ctx.Done():
+		pipe.Close()
+		r = <-resultCh
+	}
+	select {
+	case <-stderrDone:
+	case <-ctx.Done():
+		stderrPipe.Close()
+		<-stderrDone
+	}
 	err = cmd.Wait()
+	if opts.Exited != nil && cmd.ProcessState != nil {
+		opts.Exited(command[0], cmd.ProcessState)
+	}
+	if ctx.Err() != nil {
+		return nil, r.ParseStats, fmt.Errorf("%s: %w", save.name, ctx.Err())
+	}
 	if err != nil {
-		return nil, err
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, r.ParseStats, err
 	}
 
-	return r.Tables, r.error
+	return r.Tables, r.ParseStats, r.error
 }
@@ -0,0 +1,104 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetTablesTimeout(t *testing.T) {
+	// The shell forks sleep, which keeps the output open after the shell
+	// itself is killed.
+	start := time.Now()
+	_, err := GetTablesContext(context.Background(), Options{
+		Exec:    []string{"sh", "-c", "sleep 10; true"},
+		Timeout: 100 * time.Millisecond,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s", elapsed)
+	}
+}
+
+// TestReadTablesCorpus parses the dumps in testdata, which must be fully
+// understood by the parser, rule by rule.
+func TestReadTablesCorpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.save"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no dumps in testdata")
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ruleLines := 0
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); strings.HasPrefix(line, "[") || strings.HasPrefix(line, "-A ") {
+				ruleLines++
+			}
+		}
+
+		tables, stats, err := ReadTablesStats(bytes.NewReader(data), Options{})
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		rules := 0
+		for _, table := range tables {
+			for _, chain := range table {
+				rules += len(chain.Rules)
+			}
+		}
+		if stats.Rules != ruleLines || rules != ruleLines {
+			t.Errorf("%s: expected %d rules, parsed %d and kept %d", path, ruleLines, stats.Rules, rules)
+		}
+
+		_, _, parseErrors, err := CheckIptablesSave(bytes.NewReader(data), func(int, string, string, Rule) {})
+		if err != nil || len(parseErrors) > 0 {
+			t.Errorf("%s: %v %+v", path, err, parseErrors)
+		}
+
+		filter, _, err := ReadTablesStats(bytes.NewReader(data), Options{Tables: []string{"filter"}, SkipRules: true})
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		for name, table := range filter {
+			if name != "filter" {
+				t.Errorf("%s: table %s not selected", path, name)
+			}
+			for chainName, chain := range table {
+				if len(chain.Rules) > 0 {
+					t.Errorf("%s: rules of %s kept though skipped", path, chainName)
+				}
+			}
+		}
+	}
+}
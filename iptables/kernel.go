@@ -0,0 +1,447 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ErrKernelUnavailable is returned by GetKernelTablesStats if the kernel
+// has no legacy tables of the family, e.g. because it uses nftables, or if
+// they can't be read on this platform.
+var ErrKernelUnavailable = errors.New("no legacy tables in the kernel")
+
+// xtLayout describes the struct ipt_entry or ip6t_entry the kernel returns
+// the rules of a family in.
+type xtLayout struct {
+	// namesFile lists the loaded tables in /proc/net.
+	namesFile string
+	// level is the socket option level, IPPROTO_IP or IPPROTO_IPV6.
+	level int
+	// ipSize is the size of the entry's IP header match.
+	ipSize int
+	// entrySize is the size of the entry up to its matches.
+	entrySize int
+	// targetOffset is where the target_offset and next_offset fields are.
+	targetOffset int
+	// counters is where the packet and byte counters are.
+	counters int
+	// proto, flags and invflags are where these fields of the entry's IP
+	// header match are.
+	proto, flags, invflags int
+	// gotoFlag is the flag marking rules continuing with -g.
+	gotoFlag uint8
+	// fragFlag is the flag of -f, IPv4 only.
+	fragFlag uint8
+}
+
+var xtLayouts = map[Family]xtLayout{
+	IPv4: {
+		namesFile: "ip_tables_names", level: 0,
+		ipSize: 84, entrySize: 112, targetOffset: 88, counters: 96,
+		proto: 80, flags: 82, invflags: 83,
+		gotoFlag: 0x02, fragFlag: 0x01,
+	},
+	IPv6: {
+		namesFile: "ip6_tables_names", level: 41,
+		ipSize: 133, entrySize: 168, targetOffset: 140, counters: 152,
+		proto: 128, flags: 131, invflags: 132,
+		gotoFlag: 0x04,
+	},
+}
+
+// Inverse flags of the IP header match of entries.
+const (
+	xtInvIn    = 0x01
+	xtInvOut   = 0x02
+	xtInvSrc   = 0x08
+	xtInvDst   = 0x10
+	xtInvFrag  = 0x20
+	xtInvProto = 0x40
+)
+
+// xtHeaderSize is the size of struct xt_entry_match and xt_entry_target
+// before their data: the size, the name and the revision.
+const xtHeaderSize = 32
+
+// xtHooks are the built-in chains by netfilter hook.
+var xtHooks = []string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"}
+
+// xtVerdicts are the standard target's verdicts that aren't jumps.
+var xtVerdicts = map[int32]string{-1: "DROP", -2: "ACCEPT", -4: "QUEUE", -5: "RETURN"}
+
+var xtProtocols = map[uint16]string{
+	1: "icmp", 2: "igmp", 6: "tcp", 17: "udp", 41: "ipv6", 47: "gre", 50: "esp", 51: "ah",
+	58: "ipv6-icmp", 132: "sctp", 136: "udplite",
+}
+
+// nativeEndian is the byte order of the kernel's structs.
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	i := uint16(1)
+	if (*[2]byte)(unsafe.Pointer(&i))[0] == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// xtInfo is the part of struct ipt_getinfo locating the built-in chains.
+type xtInfo struct {
+	validHooks uint32
+	hookEntry  [5]uint32
+	underflow  [5]uint32
+}
+
+// xtEntry is a rule as the kernel returns it.
+type xtEntry struct {
+	offset  int
+	data    []byte
+	packets uint64
+	bytes   uint64
+	// target is the target's name, "" for the standard target.
+	target     string
+	targetData []byte
+	matches    []string
+}
+
+// verdict is the verdict of a standard target: negative for a verdict,
+// else the offset of the entry to jump to.
+func (e xtEntry) verdict() int32 {
+	if len(e.targetData) < 4 {
+		return 0
+	}
+	return int32(nativeEndian.Uint32(e.targetData))
+}
+
+// errorName is the name of an ERROR target: the name of the chain it
+// starts, or ERROR for the end of the table.
+func (e xtEntry) errorName() string {
+	return cString(e.targetData)
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// decodeEntries splits the entries of a table.
+func (l xtLayout) decodeEntries(blob []byte) ([]xtEntry, error) {
+	var entries []xtEntry
+	for offset := 0; offset < len(blob); {
+		if offset+l.entrySize > len(blob) {
+			return nil, fmt.Errorf("truncated entry at offset %d", offset)
+		}
+		targetOffset := int(nativeEndian.Uint16(blob[offset+l.targetOffset:]))
+		nextOffset := int(nativeEndian.Uint16(blob[offset+l.targetOffset+2:]))
+		if targetOffset < l.entrySize || nextOffset < targetOffset+xtHeaderSize || offset+nextOffset > len(blob) {
+			return nil, fmt.Errorf("invalid entry at offset %d", offset)
+		}
+		data := blob[offset : offset+nextOffset]
+		e := xtEntry{
+			offset:     offset,
+			data:       data,
+			packets:    nativeEndian.Uint64(data[l.counters:]),
+			bytes:      nativeEndian.Uint64(data[l.counters+8:]),
+			target:     cString(data[targetOffset+2 : targetOffset+xtHeaderSize-1]),
+			targetData: data[targetOffset+xtHeaderSize:],
+		}
+		for m := l.entrySize; m+xtHeaderSize <= targetOffset; {
+			size := int(nativeEndian.Uint16(data[m:]))
+			if size < xtHeaderSize {
+				return nil, fmt.Errorf("invalid match in entry at offset %d", offset)
+			}
+			e.matches = append(e.matches, cString(data[m+2:m+xtHeaderSize-1]))
+			m += size
+		}
+		entries = append(entries, e)
+		offset += nextOffset
+	}
+	return entries, nil
+}
+
+// decodeTable turns the entries of a table into its chains, like
+// iptables-save -c dumps them. The text of the rules only has the IP header
+// match, the names of the other matches and the target, as their options
+// are specific to every extension.
+func (l xtLayout) decodeTable(info xtInfo, blob []byte, table string, r *kernelReader) (Table, error) {
+	entries, err := l.decodeEntries(blob)
+	if err != nil {
+		return nil, err
+	}
+	starts := make(map[int]string)
+	policies := make(map[int]string)
+	for hook, name := range xtHooks {
+		if info.validHooks&(1<<uint(hook)) != 0 {
+			starts[int(info.hookEntry[hook])] = name
+			policies[int(info.underflow[hook])] = name
+		}
+	}
+	for i, e := range entries {
+		if e.target == "ERROR" && e.errorName() != "ERROR" && i+1 < len(entries) {
+			starts[entries[i+1].offset] = e.errorName()
+		}
+	}
+
+	result := make(Table)
+	var current string
+	for i, e := range entries {
+		if name, ok := starts[e.offset]; ok {
+			current = name
+			if _, ok := result[current]; !ok {
+				result[current] = Chain{Policy: "-"}
+			}
+		}
+		if e.target == "ERROR" || current == "" {
+			continue
+		}
+		chain := result[current]
+		if name, ok := policies[e.offset]; ok && name == current {
+			chain.Policy = xtVerdicts[e.verdict()]
+			chain.Packets, chain.Bytes = e.packets, e.bytes
+			result[current] = chain
+			continue
+		}
+		if chain.Policy == "-" && i+1 < len(entries) && entries[i+1].target == "ERROR" && l.chainTail(e) {
+			// The unconditional RETURN ending every user-defined chain.
+			continue
+		}
+		if rule, ok := r.rule(table, current, newRule(e.packets, e.bytes, l.ruleText(e, starts))); ok {
+			chain.Rules = append(chain.Rules, rule)
+			result[current] = chain
+		}
+	}
+	return result, nil
+}
+
+// chainTail reports whether e is an unconditional RETURN.
+func (l xtLayout) chainTail(e xtEntry) bool {
+	if e.target != "" || e.verdict() != -5 || len(e.matches) > 0 {
+		return false
+	}
+	for _, b := range e.data[:l.ipSize] {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleText renders the entry like iptables-save, starts naming the chains
+// by the offset of their first entry for jumps.
+func (l xtLayout) ruleText(e xtEntry, starts map[int]string) string {
+	var b strings.Builder
+	flags, invflags := e.data[l.flags], e.data[l.invflags]
+	option := func(inverse uint8, flag, value string) {
+		if invflags&inverse != 0 {
+			b.WriteString("! ")
+		}
+		b.WriteString(flag + " " + value + " ")
+	}
+	addrSize := net.IPv4len
+	if l.entrySize != xtLayouts[IPv4].entrySize {
+		addrSize = net.IPv6len
+	}
+	for i, flag := range []string{"-s", "-d"} {
+		addr := net.IP(e.data[i*addrSize : (i+1)*addrSize])
+		mask := net.IPMask(e.data[(i+2)*addrSize : (i+3)*addrSize])
+		if ones, _ := mask.Size(); ones > 0 || !addr.IsUnspecified() {
+			option(uint8(xtInvSrc<<uint(i)), flag, cidr(addr, mask))
+		}
+	}
+	ifaces := 4 * addrSize
+	for i, flag := range []string{"-i", "-o"} {
+		name := cString(e.data[ifaces+i*16 : ifaces+(i+1)*16])
+		if name == "" {
+			continue
+		}
+		mask := e.data[ifaces+(2+i)*16 : ifaces+(3+i)*16]
+		if len(name) < 16 && mask[len(name)] == 0 {
+			name += "+"
+		}
+		option(uint8(xtInvIn<<uint(i)), flag, name)
+	}
+	if proto := nativeEndian.Uint16(e.data[l.proto:]); proto != 0 {
+		name, ok := xtProtocols[proto]
+		if !ok {
+			name = strconv.Itoa(int(proto))
+		}
+		option(xtInvProto, "-p", name)
+	}
+	if l.fragFlag != 0 && flags&l.fragFlag != 0 {
+		if invflags&xtInvFrag != 0 {
+			b.WriteString("! ")
+		}
+		b.WriteString("-f ")
+	}
+	for _, match := range e.matches {
+		b.WriteString("-m " + match + " ")
+	}
+	switch {
+	case e.target != "":
+		b.WriteString("-j " + e.target)
+	default:
+		verdict := e.verdict()
+		if name, ok := xtVerdicts[verdict]; ok {
+			b.WriteString("-j " + name)
+			break
+		}
+		name, ok := starts[int(verdict)]
+		if !ok {
+			name = strconv.Itoa(int(verdict))
+		}
+		if flags&l.gotoFlag != 0 {
+			b.WriteString("-g " + name)
+		} else {
+			b.WriteString("-j " + name)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// cidr formats an address and mask like iptables-save, e.g. 10.0.0.0/8.
+func cidr(addr net.IP, mask net.IPMask) string {
+	if ones, bitCount := mask.Size(); bitCount > 0 {
+		return addr.String() + "/" + strconv.Itoa(ones)
+	}
+	// Non-contiguous masks, only valid for IPv4.
+	if len(mask) == net.IPv4len {
+		return addr.String() + "/" + net.IP(mask).String()
+	}
+	ones := 0
+	for _, m := range mask {
+		ones += bits.OnesCount8(m)
+	}
+	return addr.String() + "/" + strconv.Itoa(ones)
+}
+
+// kernelReader applies the options of GetKernelTablesStats to the rules
+// read, counting them in stats like the parser of the save commands.
+type kernelReader struct {
+	capture *regexp.Regexp
+	chains  func(table, chain string) bool
+	stats   ParseStats
+}
+
+func (r *kernelReader) rule(table, chain string, rule Rule) (Rule, bool) {
+	r.stats.Rules++
+	if r.capture == nil {
+		return rule, false
+	}
+	if r.chains != nil && !r.chains(table, chain) {
+		r.stats.RulesSkipped++
+		return rule, false
+	}
+	label, ok := CaptureLabel(r.capture, rule.Text)
+	if !ok {
+		r.stats.RulesNotCaptured++
+		return rule, false
+	}
+	rule.Rule = label
+	return rule, true
+}
+
+// kernelTableNames returns the legacy tables of the family loaded in the
+// kernel, as listed in /proc/net.
+func kernelTableNames(l xtLayout) ([]string, error) {
+	f, err := os.Open(filepath.Join("/proc/net", l.namesFile))
+	if os.IsNotExist(err) {
+		return nil, ErrKernelUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, ErrKernelUnavailable
+	}
+	return names, nil
+}
+
+// GetKernelTablesStats reads the legacy tables of the family selected by
+// opts from the kernel, like libiptc, without running the save command.
+// The rules' text only has their addresses, interfaces and protocol, the
+// names of their matches and their target, see decodeTable. opts.Exec and
+// opts.Timeout don't apply. Only the tables listed in /proc/net are read, as
+// asking for others loads their kernel module. It returns
+// ErrKernelUnavailable if the kernel has no legacy tables of the family.
+func GetKernelTablesStats(opts Options) (Tables, ParseStats, error) {
+	family := opts.Family
+	if family == "" {
+		family = IPv4
+	}
+	l, ok := xtLayouts[family]
+	if !ok {
+		return nil, ParseStats{}, fmt.Errorf("unknown family %q", family)
+	}
+	names, err := kernelTableNames(l)
+	if err != nil {
+		return nil, ParseStats{}, err
+	}
+	if len(opts.Tables) > 0 {
+		// Tables not loaded aren't asked for, which would load them.
+		var selected []string
+		for _, name := range names {
+			if contains(opts.Tables, name) {
+				selected = append(selected, name)
+			}
+		}
+		names = selected
+	}
+	capture := opts.Capture
+	if capture == nil {
+		capture = matchAll
+	}
+	if opts.SkipRules {
+		capture = nil
+	}
+	r := &kernelReader{capture: capture, chains: opts.Chains}
+	result := make(Tables)
+	for _, name := range names {
+		info, blob, err := getKernelEntries(l, name)
+		if err != nil {
+			return nil, r.stats, fmt.Errorf("reading table %s: %s", name, err)
+		}
+		table, err := l.decodeTable(info, blob, name, r)
+		if err != nil {
+			return nil, r.stats, fmt.Errorf("decoding table %s: %s", name, err)
+		}
+		result[name] = table
+	}
+	return result, r.stats, nil
+}
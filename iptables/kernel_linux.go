@@ -0,0 +1,96 @@
+//go:build linux && !386
+// +build linux,!386
+
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Socket options of ip_tables and ip6_tables, IPT_SO_GET_INFO and
+// IPT_SO_GET_ENTRIES, equal to the IP6T_ ones.
+const (
+	soGetInfo    = 64
+	soGetEntries = 65
+)
+
+// Sizes of struct ipt_getinfo and of struct ipt_get_entries up to its
+// entries, also those of IPv6.
+const (
+	getInfoSize    = 84
+	getEntriesSize = 40
+)
+
+// getKernelEntries returns the location of the built-in chains of a table
+// and its entries, retrying if the table changes in between.
+func getKernelEntries(l xtLayout, table string) (xtInfo, []byte, error) {
+	if len(table) >= 32 {
+		return xtInfo{}, nil, fmt.Errorf("invalid table name %q", table)
+	}
+	af := syscall.AF_INET
+	if l.level != 0 {
+		af = syscall.AF_INET6
+	}
+	fd, err := syscall.Socket(af, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return xtInfo{}, nil, err
+	}
+	defer syscall.Close(fd)
+
+	for attempt := 0; ; attempt++ {
+		buf := make([]byte, getInfoSize)
+		copy(buf, table)
+		if err := getsockopt(fd, l.level, soGetInfo, buf); err != nil {
+			if err == syscall.ENOPROTOOPT {
+				return xtInfo{}, nil, ErrKernelUnavailable
+			}
+			return xtInfo{}, nil, err
+		}
+		var info xtInfo
+		info.validHooks = nativeEndian.Uint32(buf[32:])
+		for i := range info.hookEntry {
+			info.hookEntry[i] = nativeEndian.Uint32(buf[36+4*i:])
+			info.underflow[i] = nativeEndian.Uint32(buf[56+4*i:])
+		}
+		size := nativeEndian.Uint32(buf[80:])
+
+		entries := make([]byte, getEntriesSize+int(size))
+		copy(entries, table)
+		nativeEndian.PutUint32(entries[32:], size)
+		err := getsockopt(fd, l.level, soGetEntries, entries)
+		if err == syscall.EAGAIN && attempt < 3 {
+			// The table was replaced since its size was read.
+			continue
+		}
+		if err != nil {
+			return xtInfo{}, nil, err
+		}
+		return info, entries[getEntriesSize:], nil
+	}
+}
+
+func getsockopt(fd, level, name int, buf []byte) error {
+	size := uint32(len(buf))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"net"
+	"regexp"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+// testEntry is an entry to encode like the kernel does.
+type testEntry struct {
+	src, dst       string
+	in             string
+	inverse, flags uint8
+	proto          uint16
+	matches        []string
+	target         string
+	// verdict is that of the standard target, or the offset of the
+	// entry to jump to.
+	verdict        int32
+	errorName      string
+	packets, bytes uint64
+}
+
+func encodeEntries(t *testing.T, l xtLayout, entries []testEntry) []byte {
+	var blob []byte
+	for _, e := range entries {
+		data := make([]byte, l.entrySize)
+		for i, cidr := range []string{e.src, e.dst} {
+			if cidr == "" {
+				continue
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			copy(data[4*i:], network.IP.To4())
+			copy(data[8+4*i:], network.Mask)
+		}
+		if e.in != "" {
+			name := e.in
+			wildcard := name[len(name)-1] == '+'
+			if wildcard {
+				name = name[:len(name)-1]
+			}
+			copy(data[16:], name)
+			for i := 0; i < len(name); i++ {
+				data[48+i] = 0xff
+			}
+			if !wildcard {
+				data[48+len(name)] = 0xff
+			}
+		}
+		nativeEndian.PutUint16(data[l.proto:], e.proto)
+		data[l.flags] = e.flags
+		data[l.invflags] = e.inverse
+		nativeEndian.PutUint64(data[l.counters:], e.packets)
+		nativeEndian.PutUint64(data[l.counters+8:], e.bytes)
+		for _, m := range e.matches {
+			match := make([]byte, xtHeaderSize+8)
+			nativeEndian.PutUint16(match, uint16(len(match)))
+			copy(match[2:], m)
+			data = append(data, match...)
+		}
+		targetOffset := len(data)
+		target := make([]byte, xtHeaderSize+32)
+		nativeEndian.PutUint16(target, uint16(len(target)))
+		copy(target[2:], e.target)
+		switch {
+		case e.target == "ERROR":
+			copy(target[xtHeaderSize:], e.errorName)
+		case e.target == "":
+			nativeEndian.PutUint32(target[xtHeaderSize:], uint32(e.verdict))
+		}
+		data = append(data, target...)
+		nativeEndian.PutUint16(data[l.targetOffset:], uint16(targetOffset))
+		nativeEndian.PutUint16(data[l.targetOffset+2:], uint16(len(data)))
+		blob = append(blob, data...)
+	}
+	return blob
+}
+
+func TestDecodeTable(t *testing.T) {
+	l := xtLayouts[IPv4]
+	entries := []testEntry{
+		// INPUT
+		{src: "10.0.0.0/8", in: "eth+", proto: 6, matches: []string{"tcp", "comment"}, verdict: -2, packets: 3, bytes: 300},
+		{packets: 4, bytes: 400},
+		{verdict: -2, packets: 1, bytes: 100},
+		// FORWARD
+		{in: "eth0", inverse: xtInvIn, target: "REJECT"},
+		{verdict: -1, packets: 2, bytes: 200},
+		// WAN
+		{target: "ERROR", errorName: "WAN"},
+		{dst: "192.168.1.1/32", inverse: xtInvDst, verdict: -1, packets: 5, bytes: 500},
+		{verdict: -5},
+		{target: "ERROR", errorName: "ERROR"},
+	}
+	// Offsets are only known once encoded, so they are patched in.
+	blob := encodeEntries(t, l, entries)
+	decoded, err := l.decodeEntries(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[1].verdict = int32(decoded[6].offset)
+	blob = encodeEntries(t, l, entries)
+	info := xtInfo{validHooks: 1<<1 | 1<<2}
+	info.hookEntry[1], info.underflow[1] = 0, uint32(decoded[2].offset)
+	info.hookEntry[2], info.underflow[2] = uint32(decoded[3].offset), uint32(decoded[4].offset)
+
+	r := &kernelReader{capture: regexp.MustCompile(".*"), chains: func(table, chain string) bool { return chain != "FORWARD" }}
+	table, err := l.decodeTable(info, blob, "filter", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Table{
+		"INPUT": {Policy: "ACCEPT", Packets: 1, Bytes: 100, Rules: []Rule{
+			{Packets: 3, Bytes: 300, Rule: "-s 10.0.0.0/8 -i eth+ -p tcp -m tcp -m comment -j ACCEPT", Text: "-s 10.0.0.0/8 -i eth+ -p tcp -m tcp -m comment -j ACCEPT"},
+			{Packets: 4, Bytes: 400, Rule: "-j WAN", Text: "-j WAN"},
+		}},
+		"FORWARD": {Policy: "DROP", Packets: 2, Bytes: 200},
+		"WAN": {Policy: "-", Rules: []Rule{
+			{Packets: 5, Bytes: 500, Rule: "! -d 192.168.1.1/32 -j DROP", Text: "! -d 192.168.1.1/32 -j DROP"},
+		}},
+	}
+	if mismatch := deep.Equal(expected, table); mismatch != nil {
+		t.Errorf("%+v", mismatch)
+	}
+	if r.stats.Rules != 4 || r.stats.RulesSkipped != 1 {
+		t.Errorf("expected 4 rules, 1 skipped, got %+v", r.stats)
+	}
+}
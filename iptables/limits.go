@@ -0,0 +1,117 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultBurst is the burst of the limit and hashlimit matches if none is
+// given.
+const defaultBurst = 5
+
+// RateLimit is the rate a limit or hashlimit match allows, or, with
+// --hashlimit-above, the rate above which it matches.
+type RateLimit struct {
+	// Match is limit or hashlimit.
+	Match string
+	// Rate is per second, in packets or, if Bytes, in bytes.
+	Rate  float64
+	Burst float64
+	Bytes bool
+}
+
+var rateUnits = map[string]float64{
+	"s": 1, "sec": 1, "second": 1,
+	"m": 60, "min": 60, "minute": 60,
+	"h": 3600, "hour": 3600,
+	"d": 86400, "day": 86400,
+}
+
+var byteUnits = map[string]float64{
+	"b": 1, "kb": 1 << 10, "mb": 1 << 20,
+}
+
+// ParseRate parses a rate like "5/min", or "512kb/s" for hashlimit, into
+// the amount per second and whether it counts bytes rather than packets.
+// A missing unit means per second.
+func ParseRate(value string) (perSecond float64, bytes bool, err error) {
+	amount, unit := value, "s"
+	if i := strings.IndexByte(value, '/'); i >= 0 {
+		amount, unit = value[:i], value[i+1:]
+	}
+	seconds, ok := rateUnits[unit]
+	if !ok {
+		return 0, false, fmt.Errorf("invalid rate %q", value)
+	}
+	n, size, bytes, err := parseAmount(amount)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid rate %q", value)
+	}
+	return n * size / seconds, bytes, nil
+}
+
+// parseAmount parses a number optionally followed by a byte unit.
+func parseAmount(s string) (n, size float64, bytes bool, err error) {
+	size = 1
+	for suffix, factor := range byteUnits {
+		if strings.HasSuffix(s, suffix) && len(s) > len(suffix) && s[len(s)-len(suffix)-1] >= '0' && s[len(s)-len(suffix)-1] <= '9' {
+			s, size, bytes = s[:len(s)-len(suffix)], factor, true
+			break
+		}
+	}
+	n, err = strconv.ParseFloat(s, 64)
+	return n, size, bytes, err
+}
+
+// RateLimit returns the rate allowed by the limit or hashlimit match of
+// the rule, if it has one.
+func (s RuleSpec) RateLimit() (RateLimit, bool) {
+	if o, ok := s.MatchOption("limit", "--limit"); ok && len(o.Values) == 1 {
+		limit := RateLimit{Match: "limit", Burst: defaultBurst}
+		var err error
+		if limit.Rate, _, err = ParseRate(o.Values[0]); err != nil {
+			return RateLimit{}, false
+		}
+		if b, ok := s.MatchOption("limit", "--limit-burst"); ok && len(b.Values) == 1 {
+			if limit.Burst, err = strconv.ParseFloat(b.Values[0], 64); err != nil {
+				return RateLimit{}, false
+			}
+		}
+		return limit, true
+	}
+	for _, flag := range []string{"--hashlimit-upto", "--hashlimit", "--hashlimit-above"} {
+		o, ok := s.MatchOption("hashlimit", flag)
+		if !ok || len(o.Values) != 1 {
+			continue
+		}
+		limit := RateLimit{Match: "hashlimit", Burst: defaultBurst}
+		var err error
+		if limit.Rate, limit.Bytes, err = ParseRate(o.Values[0]); err != nil {
+			return RateLimit{}, false
+		}
+		if b, ok := s.MatchOption("hashlimit", "--hashlimit-burst"); ok && len(b.Values) == 1 {
+			n, size, _, err := parseAmount(b.Values[0])
+			if err != nil {
+				return RateLimit{}, false
+			}
+			limit.Burst = n * size
+		}
+		return limit, true
+	}
+	return RateLimit{}, false
+}
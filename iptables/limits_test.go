@@ -0,0 +1,82 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value     string
+		perSecond float64
+		bytes     bool
+	}{
+		{"10/sec", 10, false},
+		{"5/min", 5.0 / 60, false},
+		{"3/hour", 3.0 / 3600, false},
+		{"20", 20, false},
+		{"512kb/s", 512 * 1024, true},
+		{"1mb/m", 1024 * 1024 / 60.0, true},
+	}
+	for _, test := range tests {
+		perSecond, bytes, err := ParseRate(test.value)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %s", test.value, err)
+			continue
+		}
+		if perSecond != test.perSecond || bytes != test.bytes {
+			t.Errorf("ParseRate(%q) = %v, %v, expected %v, %v", test.value, perSecond, bytes, test.perSecond, test.bytes)
+		}
+	}
+	if _, _, err := ParseRate("5/fortnight"); err == nil {
+		t.Fatalf("expected error for unknown unit")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected RateLimit
+	}{
+		{
+			text:     "-p icmp -m limit --limit 1/sec --limit-burst 10 -j ACCEPT",
+			expected: RateLimit{Match: "limit", Rate: 1, Burst: 10},
+		},
+		{
+			text:     "-m limit --limit 6/min -j LOG",
+			expected: RateLimit{Match: "limit", Rate: 0.1, Burst: 5},
+		},
+		{
+			text:     "-m hashlimit --hashlimit-above 1kb/s --hashlimit-burst 2kb --hashlimit-mode srcip --hashlimit-name bulk -j DROP",
+			expected: RateLimit{Match: "hashlimit", Rate: 1024, Burst: 2048, Bytes: true},
+		},
+	}
+	for _, test := range tests {
+		limit, ok := ParseRuleSpec(test.text).RateLimit()
+		if !ok {
+			t.Errorf("no rate limit found in %q", test.text)
+			continue
+		}
+		if mismatch := deep.Equal(test.expected, limit); mismatch != nil {
+			t.Errorf("%q: %+v", test.text, mismatch)
+		}
+	}
+	if _, ok := ParseRuleSpec("-p tcp -j ACCEPT").RateLimit(); ok {
+		t.Fatalf("expected no rate limit")
+	}
+}
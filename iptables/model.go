@@ -14,10 +14,35 @@
 
 package iptables
 
+import (
+	"sort"
+	"sync"
+)
+
 type Tables map[string]Table
 
+// Names returns the sorted names of the tables.
+func (t Tables) Names() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Table map[string]Chain
 
+// ChainNames returns the sorted names of the table's chains.
+func (t Table) ChainNames() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type Chain struct {
 	Policy  string
 	Packets uint64
@@ -28,5 +53,34 @@ type Chain struct {
 type Rule struct {
 	Packets uint64
 	Bytes   uint64
-	Rule    string
+	// Rule identifies the rule in metrics: Text or the bits of it captured
+	// by the capture regexp.
+	Rule string
+	// Text is the rule as dumped, without its chain and counters.
+	Text string
+	// spec holds the interpretation of Text, shared by the copies of the
+	// rule. It's nil for rules not read by this package.
+	spec *ruleSpecCache
+}
+
+type ruleSpecCache struct {
+	once sync.Once
+	spec RuleSpec
+}
+
+// newRule returns a rule whose text is only interpreted once, however often
+// Spec is called on it and its copies.
+func newRule(packets, bytes uint64, text string) Rule {
+	return Rule{Packets: packets, Bytes: bytes, Text: text, spec: &ruleSpecCache{}}
+}
+
+// Spec interprets the text of the rule.
+func (r Rule) Spec() RuleSpec {
+	if r.spec == nil {
+		return ParseRuleSpec(r.Text)
+	}
+	r.spec.once.Do(func() {
+		r.spec.spec = ParseRuleSpec(r.Text)
+	})
+	return r.spec.spec
 }
@@ -23,21 +23,108 @@ import (
 	"strings"
 )
 
+// ParseIptablesSave parses the output of iptables-save -c. A nil capture
+// skips the rules, leaving only the chains with their policy counters.
 func ParseIptablesSave(r io.Reader, capture *regexp.Regexp) (Tables, error) {
+	tables, _, err := ParseIptablesSaveStats(r, capture)
+	return tables, err
+}
+
+// ParseStats counts what a parse went through.
+type ParseStats struct {
+	Lines int
+	Rules int
+	// RulesNotCaptured counts the rules ignored because the capture regexp
+	// didn't match them.
+	RulesNotCaptured int
+	// RulesSkipped counts the rules of chains left out by the chain
+	// selection, which are not tokenized.
+	RulesSkipped int
+}
+
+// maxLineLength bounds the length of a line of iptables-save output, which
+// can exceed bufio's default for rules with long comments or many ports.
+const maxLineLength = 1024 * 1024
+
+// ParseIptablesSaveStats is ParseIptablesSave, also returning statistics.
+func ParseIptablesSaveStats(r io.Reader, capture *regexp.Regexp) (Tables, ParseStats, error) {
+	return ParseIptablesSaveChains(r, capture, nil)
+}
+
+// ParseIptablesSaveChains is ParseIptablesSaveStats, only reading the rules
+// of the chains chains returns true for, unless it is nil. The rules of
+// other chains are skipped before they are tokenized, keeping large
+// rulesets cheap when only some chains matter; their chains are kept with
+// their policy counters.
+func ParseIptablesSaveChains(r io.Reader, capture *regexp.Regexp, chains func(table, chain string) bool) (Tables, ParseStats, error) {
+	return parseSave(r, capture, parser{chains: chains})
+}
+
+// ParseEbtablesSave is ParseIptablesSaveChains for the output of
+// ebtables-save and arptables-save, which also write chains without
+// counters, counting zero, and rules with their counters at the end as
+// -c packets bytes.
+func ParseEbtablesSave(r io.Reader, capture *regexp.Regexp, chains func(table, chain string) bool) (Tables, ParseStats, error) {
+	return parseSave(r, capture, parser{chains: chains, ebtables: true})
+}
+
+func parseSave(r io.Reader, capture *regexp.Regexp, parser parser) (Tables, ParseStats, error) {
 	scanner := bufio.NewScanner(r)
-	var parser parser
+	scanner.Buffer(nil, maxLineLength)
 	for scanner.Scan() {
 		parser.handleLine(scanner.Text(), capture)
 	}
 	parser.flush()
 	err := scanner.Err()
 	if err != nil {
-		return nil, err
+		return nil, parser.stats, err
 	}
 	if len(parser.errors) > 0 {
-		return nil, parser.errors[0]
+		return nil, parser.stats, parser.errors[0]
+	}
+	return parser.result, parser.stats, nil
+}
+
+// ParseFunc parses the output of iptables-save -c, calling fn for every
+// rule as soon as it is read instead of collecting the tables, so memory use
+// doesn't grow with the size of the ruleset. The Rule of every rule is its
+// Text. Parsing stops at the first malformed line or error returned by fn.
+func ParseFunc(r io.Reader, fn func(table, chain string, rule Rule) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	parser := parser{emit: fn}
+	for scanner.Scan() {
+		parser.handleLine(scanner.Text(), matchAll)
+		if len(parser.errors) > 0 {
+			return parser.errors[0]
+		}
 	}
-	return parser.result, nil
+	return scanner.Err()
+}
+
+// CheckIptablesSave parses the output of iptables-save -c like ParseFunc,
+// calling fn for every rule along with its line number, but reports every
+// malformed line instead of stopping at the first one. The tables returned
+// hold the chains without their rules.
+func CheckIptablesSave(r io.Reader, fn func(line int, table, chain string, rule Rule)) (Tables, ParseStats, []ParseError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLength)
+	parser := parser{strict: true}
+	parser.emit = func(table, chain string, rule Rule) error {
+		fn(parser.line, table, chain, rule)
+		return nil
+	}
+	for scanner.Scan() {
+		parser.handleLine(scanner.Text(), matchAll)
+	}
+	parser.flush()
+	var errors []ParseError
+	for _, err := range parser.errors {
+		if pe, ok := err.(ParseError); ok {
+			errors = append(errors, pe)
+		}
+	}
+	return parser.result, parser.stats, errors, scanner.Err()
 }
 
 type ParseError struct {
@@ -56,6 +143,19 @@ type parser struct {
 	currentTable     Table
 	line             int
 	errors           []error
+	stats            ParseStats
+	// emit, if set, receives the rules instead of result
+	emit func(table, chain string, rule Rule) error
+	// strict reports rules outside of a table or of undeclared chains,
+	// which are otherwise accepted
+	strict bool
+	// chains, if set, selects the chains whose rules are read, the
+	// decisions kept in selected by table and chain
+	chains   func(table, chain string) bool
+	selected map[[2]string]bool
+	// ebtables accepts the counters as written by ebtables-save, see
+	// ParseEbtablesSave
+	ebtables bool
 }
 
 func (p *parser) flush() {
@@ -71,11 +171,14 @@ func (p *parser) flush() {
 
 func (p *parser) handleNewChain(line string) {
 	fields := strings.Fields(line)
-	if len(fields) != 3 {
+	if len(fields) != 3 && !(p.ebtables && len(fields) == 2) {
 		p.errors = append(p.errors, ParseError{"expected 3 fields", p.line, line})
 		return
 	}
 	name := strings.TrimPrefix(fields[0], ":")
+	if p.ebtables && len(fields) == 2 {
+		fields = append(fields, "[0:0]")
+	}
 	packets, bytes, ok := parseCounters(fields[2])
 	if !ok {
 		p.errors = append(p.errors, ParseError{"expected [packets:bytes]", p.line, line})
@@ -84,22 +187,25 @@ func (p *parser) handleNewChain(line string) {
 	if p.currentTable == nil {
 		p.currentTable = make(map[string]Chain)
 	}
-	chain := Chain{
-		Policy:  fields[1],
-		Packets: packets,
-		Bytes:   bytes,
-	}
+	// A chain declared again by a later block of the same table keeps
+	// the rules read so far. Its policy and counters are those of the
+	// last declaration: every declaration carries the chain's totals, so
+	// adding them up would count packets twice.
+	chain := p.currentTable[name]
+	chain.Policy = fields[1]
+	chain.Packets = packets
+	chain.Bytes = bytes
 	p.currentTable[name] = chain
 }
 
 func (p *parser) handleRule(line string, capture *regexp.Regexp) {
 	fields := strings.Fields(line)
-	var subParser ruleParser
+	subParser := ruleParser{counterOption: p.ebtables}
 	for _, token := range fields {
 		subParser.handleToken(token)
 	}
 	subParser.flush()
-	if !subParser.countersOk {
+	if !subParser.countersOk && strings.HasPrefix(line, "[") {
 		p.errors = append(p.errors, ParseError{"expected [packets:bytes]", p.line, line})
 		return
 	}
@@ -107,28 +213,79 @@ func (p *parser) handleRule(line string, capture *regexp.Regexp) {
 		p.errors = append(p.errors, ParseError{"expected -A chain ...", p.line, line})
 		return
 	}
-	r := Rule{
-		Packets: subParser.packets,
-		Bytes:   subParser.bytes,
-		Rule:    strings.Join(subParser.flags, " "),
+	if p.strict {
+		if p.currentTableName == "" {
+			p.errors = append(p.errors, ParseError{"rule outside of a table", p.line, line})
+			return
+		}
+		if _, ok := p.currentTable[subParser.chain]; !ok {
+			p.errors = append(p.errors, ParseError{"rule of undeclared chain", p.line, line})
+		}
 	}
-	captureResult := capture.FindStringSubmatch(r.Rule)
+	r := newRule(subParser.packets, subParser.bytes, strings.Join(subParser.flags, " "))
+	label, ok := CaptureLabel(capture, r.Text)
 	// Regexp didn't match, ignore rule
-	if len(captureResult) == 0 {
+	if !ok {
+		p.stats.RulesNotCaptured++
+		return
+	}
+	r.Rule = label
+	if p.emit != nil {
+		if err := p.emit(p.currentTableName, subParser.chain, r); err != nil {
+			p.errors = append(p.errors, err)
+		}
 		return
-		// } else if len(captureResult) == 1
-		// Skip as no modification of rule will happen (captured the whole result)
-	} else if len(captureResult) > 1 {
-		// Join all regexp capture groups
-		r.Rule = strings.Join(captureResult[1:], " ")
+	}
+	if p.currentTable == nil {
+		p.currentTable = make(map[string]Chain)
 	}
 	chain := p.currentTable[subParser.chain]
 	chain.Rules = append(chain.Rules, r)
 	p.currentTable[subParser.chain] = chain
 }
 
+// CaptureLabel returns the label of a rule with the given text: the text
+// itself if capture has no unnamed groups, or the unnamed groups joined by
+// spaces. Named groups are left out, see CaptureGroups. ok is false if
+// capture doesn't match, in which case the rule is ignored.
+func CaptureLabel(capture *regexp.Regexp, text string) (label string, ok bool) {
+	captureResult := capture.FindStringSubmatch(text)
+	if len(captureResult) == 0 {
+		return "", false
+	}
+	var groups []string
+	for i, name := range capture.SubexpNames()[1:] {
+		if name == "" {
+			groups = append(groups, captureResult[i+1])
+		}
+	}
+	if len(groups) == 0 {
+		// No modification of rule will happen (captured the whole result)
+		return text, true
+	}
+	// Join all unnamed regexp capture groups
+	return strings.Join(groups, " "), true
+}
+
+// CaptureGroups returns the text captured by the named groups of capture,
+// by name, or nil if capture doesn't match.
+func CaptureGroups(capture *regexp.Regexp, text string) map[string]string {
+	captureResult := capture.FindStringSubmatch(text)
+	if len(captureResult) == 0 {
+		return nil
+	}
+	groups := make(map[string]string)
+	for i, name := range capture.SubexpNames() {
+		if name != "" {
+			groups[name] = captureResult[i]
+		}
+	}
+	return groups
+}
+
 func (p *parser) handleLine(line string, capture *regexp.Regexp) {
 	p.line++
+	p.stats.Lines++
 	line = strings.TrimSpace(line)
 	if line == "" || strings.HasPrefix(line, "#") {
 		return
@@ -140,19 +297,56 @@ func (p *parser) handleLine(line string, capture *regexp.Regexp) {
 	if name := strings.TrimPrefix(line, "*"); name != line {
 		p.flush()
 		p.currentTableName = name
+		// Concatenated dumps can contain the same table several times,
+		// whose blocks are merged.
+		p.currentTable = p.result[name]
 		return
 	}
 	if strings.HasPrefix(line, ":") {
 		p.handleNewChain(line)
 		return
 	}
-	if strings.HasPrefix(line, "[") {
+	// Rules without counters, as written by iptables-save without -c, e.g.
+	// to persist the ruleset, count zero.
+	if strings.HasPrefix(line, "[") || strings.HasPrefix(line, "-A ") {
+		p.stats.Rules++
+		if capture == nil {
+			return
+		}
+		if p.chains != nil && !p.selectedChain(line) {
+			p.stats.RulesSkipped++
+			return
+		}
 		p.handleRule(line, capture)
 		return
 	}
 	p.errors = append(p.errors, ParseError{"unhandled line", p.line, line})
 }
 
+// selectedChain reports whether the rule on line belongs to a chain selected
+// by p.chains, looking no further into the line than its chain name.
+func (p *parser) selectedChain(line string) bool {
+	i := strings.Index(line, "-A ")
+	if i < 0 {
+		// Malformed, for handleRule to report.
+		return true
+	}
+	chain := line[i+3:]
+	if j := strings.IndexAny(chain, " \t"); j >= 0 {
+		chain = chain[:j]
+	}
+	key := [2]string{p.currentTableName, chain}
+	selected, ok := p.selected[key]
+	if !ok {
+		if p.selected == nil {
+			p.selected = make(map[[2]string]bool)
+		}
+		selected = p.chains(p.currentTableName, chain)
+		p.selected[key] = selected
+	}
+	return selected
+}
+
 var countersRegexp = regexp.MustCompile(`^\[(\d+):(\d+)]$`)
 
 func parseCounters(field string) (packets, bytes uint64, ok bool) {
@@ -16,7 +16,9 @@ package iptables
 
 import (
 	"os"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -56,21 +58,25 @@ var parserTestCases = []parserTestCase{
 							Packets: 7981319024,
 							Bytes:   1536987862973,
 							Rule:    "-p tcp -m tcp --dport 7000 -j ACCEPT",
+							Text:    "-p tcp -m tcp --dport 7000 -j ACCEPT",
 						},
 						{
 							Packets: 1335166082,
 							Bytes:   279365222746,
 							Rule:    "-p tcp -m tcp --dport 9160 -j ACCEPT",
+							Text:    "-p tcp -m tcp --dport 9160 -j ACCEPT",
 						},
 						{
 							Packets: 27438740,
 							Bytes:   6089401408,
 							Rule:    "-p tcp -m tcp --dport 7199 -j ACCEPT",
+							Text:    "-p tcp -m tcp --dport 7199 -j ACCEPT",
 						},
 						{
 							Packets: 1285509559,
 							Bytes:   346897300390,
 							Rule:    "-p tcp -m tcp --dport 9042 -j ACCEPT",
+							Text:    "-p tcp -m tcp --dport 9042 -j ACCEPT",
 						},
 					},
 				},
@@ -86,21 +92,25 @@ var parserTestCases = []parserTestCase{
 							Packets: 7903596488,
 							Bytes:   341918393697,
 							Rule:    "-p tcp -m tcp --sport 7000 -j ACCEPT",
+							Text:    "-p tcp -m tcp --sport 7000 -j ACCEPT",
 						},
 						{
 							Packets: 973128122,
 							Bytes:   70345269557,
 							Rule:    "-p tcp -m tcp --sport 9160 -j ACCEPT",
+							Text:    "-p tcp -m tcp --sport 9160 -j ACCEPT",
 						},
 						{
 							Packets: 26463368,
 							Bytes:   3097440049,
 							Rule:    "-p tcp -m tcp --sport 7199 -j ACCEPT",
+							Text:    "-p tcp -m tcp --sport 7199 -j ACCEPT",
 						},
 						{
 							Packets: 813815825,
 							Bytes:   429136005552,
 							Rule:    "-p tcp -m tcp --sport 9042 -j ACCEPT",
+							Text:    "-p tcp -m tcp --sport 9042 -j ACCEPT",
 						},
 					},
 				},
@@ -146,21 +156,25 @@ var parserTestCases = []parserTestCase{
 							Packets: 7981319024,
 							Bytes:   1536987862973,
 							Rule:    "7000 ACCEPT",
+							Text:    "-p tcp -m tcp --dport 7000 -j ACCEPT",
 						},
 						{
 							Packets: 1335166082,
 							Bytes:   279365222746,
 							Rule:    "9160 ACCEPT",
+							Text:    "-p tcp -m tcp --dport 9160 -j ACCEPT",
 						},
 						{
 							Packets: 27438740,
 							Bytes:   6089401408,
 							Rule:    "7199 ACCEPT",
+							Text:    "-p tcp -m tcp --dport 7199 -j ACCEPT",
 						},
 						{
 							Packets: 1285509559,
 							Bytes:   346897300390,
 							Rule:    "9042 ACCEPT",
+							Text:    "-p tcp -m tcp --dport 9042 -j ACCEPT",
 						},
 					},
 				},
@@ -214,21 +228,25 @@ var parserTestCases = []parserTestCase{
 							Packets: 7981319024,
 							Bytes:   1536987862973,
 							Rule:    "7000",
+							Text:    "-p tcp -m tcp --dport 7000 -j ACCEPT",
 						},
 						{
 							Packets: 1335166082,
 							Bytes:   279365222746,
 							Rule:    "9160",
+							Text:    "-p tcp -m tcp --dport 9160 -j ACCEPT",
 						},
 						{
 							Packets: 27438740,
 							Bytes:   6089401408,
 							Rule:    "7199",
+							Text:    "-p tcp -m tcp --dport 7199 -j ACCEPT",
 						},
 						{
 							Packets: 1285509559,
 							Bytes:   346897300390,
 							Rule:    "9042",
+							Text:    "-p tcp -m tcp --dport 9042 -j ACCEPT",
 						},
 					},
 				},
@@ -331,16 +349,19 @@ var parserTestCases = []parserTestCase{
 							Packets: 12,
 							Bytes:   720,
 							Rule:    "-s 10.10.10.0/24 -d 10.10.10.1/32 -p icmp -j ACCEPT",
+							Text:    "-s 10.10.10.0/24 -d 10.10.10.1/32 -p icmp -j ACCEPT",
 						},
 						{
 							Packets: 17256030,
 							Bytes:   2279773210,
 							Rule:    "-s 10.10.10.0/24 -d 10.10.10.1/32 -p tcp -m tcp --dport 80 -j ACCEPT",
+							Text:    "-s 10.10.10.0/24 -d 10.10.10.1/32 -p tcp -m tcp --dport 80 -j ACCEPT",
 						},
 						{
 							Packets: 60372,
 							Bytes:   6729099,
 							Rule:    "-s 10.10.10.0/24 -j DROP",
+							Text:    "-s 10.10.10.0/24 -j DROP",
 						},
 					},
 				},
@@ -357,6 +378,24 @@ var parserTestCases = []parserTestCase{
 			},
 		},
 	},
+	{
+		name:    "server.iptables-save",
+		capture: nil,
+		expected: Tables{
+			"filter": {
+				"INPUT":   {Policy: "ACCEPT", Packets: 8202915326, Bytes: 443356185985},
+				"FORWARD": {Policy: "ACCEPT"},
+				"OUTPUT":  {Policy: "ACCEPT", Packets: 8189941891, Bytes: 1885661899958},
+			},
+			"mangle": {
+				"PREROUTING":  {Policy: "ACCEPT", Packets: 18832348733, Bytes: 2612695974158},
+				"INPUT":       {Policy: "ACCEPT", Packets: 18832348731, Bytes: 2612695973502},
+				"FORWARD":     {Policy: "ACCEPT"},
+				"OUTPUT":      {Policy: "ACCEPT", Packets: 17906945694, Bytes: 2730159008813},
+				"POSTROUTING": {Policy: "ACCEPT", Packets: 17906945694, Bytes: 2730159008813},
+			},
+		},
+	},
 }
 
 func TestParseIptablesSave(t *testing.T) {
@@ -370,3 +409,238 @@ func TestParseIptablesSave(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRepeatedTable(t *testing.T) {
+	input := `*filter
+:INPUT ACCEPT [1:10]
+[2:20] -A INPUT -p tcp -j ACCEPT
+COMMIT
+*nat
+:PREROUTING ACCEPT [0:0]
+COMMIT
+*filter
+:INPUT DROP [3:30]
+:OUTPUT ACCEPT [0:0]
+[4:40] -A INPUT -p udp -j ACCEPT
+COMMIT
+`
+	expected := Tables{
+		"filter": {
+			"INPUT": {
+				Policy:  "DROP",
+				Packets: 3,
+				Bytes:   30,
+				Rules: []Rule{
+					{Packets: 2, Bytes: 20, Rule: "-p tcp -j ACCEPT", Text: "-p tcp -j ACCEPT"},
+					{Packets: 4, Bytes: 40, Rule: "-p udp -j ACCEPT", Text: "-p udp -j ACCEPT"},
+				},
+			},
+			"OUTPUT": {Policy: "ACCEPT"},
+		},
+		"nat": {
+			"PREROUTING": {Policy: "ACCEPT"},
+		},
+	}
+	result, err := ParseIptablesSave(strings.NewReader(input), regexp.MustCompile(".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal(expected, result); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
+
+func TestParseWithoutCounters(t *testing.T) {
+	input := `*filter
+:INPUT DROP [0:0]
+-A INPUT -p tcp -j ACCEPT
+COMMIT
+`
+	expected := Tables{
+		"filter": {
+			"INPUT": {
+				Policy: "DROP",
+				Rules:  []Rule{{Rule: "-p tcp -j ACCEPT", Text: "-p tcp -j ACCEPT"}},
+			},
+		},
+	}
+	result, err := ParseIptablesSave(strings.NewReader(input), regexp.MustCompile(".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal(expected, result); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
+
+func TestParseIptablesSaveChains(t *testing.T) {
+	input := `*filter
+:INPUT DROP [1:2]
+:KUBE-SVC-1 - [0:0]
+[3:4] -A INPUT -p tcp -j KUBE-SVC-1
+[5:6] -A KUBE-SVC-1 -j ACCEPT
+[5:6] -A KUBE-SVC-1 -j DROP
+COMMIT
+`
+	expected := Tables{
+		"filter": {
+			"INPUT": {
+				Policy:  "DROP",
+				Packets: 1,
+				Bytes:   2,
+				Rules:   []Rule{{Rule: "-p tcp -j KUBE-SVC-1", Text: "-p tcp -j KUBE-SVC-1", Packets: 3, Bytes: 4}},
+			},
+			"KUBE-SVC-1": {Policy: "-"},
+		},
+	}
+	var asked []string
+	result, stats, err := ParseIptablesSaveChains(strings.NewReader(input), regexp.MustCompile(".*"), func(table, chain string) bool {
+		asked = append(asked, table+"/"+chain)
+		return !strings.HasPrefix(chain, "KUBE-")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal(expected, result); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+	if stats.Rules != 3 || stats.RulesSkipped != 2 {
+		t.Errorf("expected 3 rules, 2 skipped, got %+v", stats)
+	}
+	if mismatch := deep.Equal([]string{"filter/INPUT", "filter/KUBE-SVC-1"}, asked); mismatch != nil {
+		t.Errorf("chains asked: %+v", mismatch)
+	}
+}
+
+func TestParseEbtablesSave(t *testing.T) {
+	input := `# Generated by ebtables-save v1.8.7 (nf_tables)
+*filter
+:INPUT ACCEPT
+:FORWARD DROP
+:VM-1 RETURN
+-A INPUT -p IPv4 -i br0 -j ACCEPT -c 3 4
+-A FORWARD -j VM-1 -c 5 6
+-A VM-1 -s 52:54:0:12:34:56 -j ACCEPT
+COMMIT
+*filter
+:OUTPUT ACCEPT [7:8]
+[9:10] -A OUTPUT -j ACCEPT
+COMMIT
+`
+	expected := Tables{
+		"filter": {
+			"INPUT": {
+				Policy: "ACCEPT",
+				Rules:  []Rule{{Rule: "-p IPv4 -i br0 -j ACCEPT", Text: "-p IPv4 -i br0 -j ACCEPT", Packets: 3, Bytes: 4}},
+			},
+			"FORWARD": {
+				Policy: "DROP",
+				Rules:  []Rule{{Rule: "-j VM-1", Text: "-j VM-1", Packets: 5, Bytes: 6}},
+			},
+			"VM-1": {
+				Policy: "RETURN",
+				Rules:  []Rule{{Rule: "-s 52:54:0:12:34:56 -j ACCEPT", Text: "-s 52:54:0:12:34:56 -j ACCEPT"}},
+			},
+			"OUTPUT": {
+				Policy:  "ACCEPT",
+				Packets: 7,
+				Bytes:   8,
+				Rules:   []Rule{{Rule: "-j ACCEPT", Text: "-j ACCEPT", Packets: 9, Bytes: 10}},
+			},
+		},
+	}
+	result, _, err := ParseEbtablesSave(strings.NewReader(input), regexp.MustCompile(".*"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal(expected, result); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+	if _, err := ParseIptablesSave(strings.NewReader(input), regexp.MustCompile(".*")); err == nil {
+		t.Error("expected chains without counters to fail outside of ParseEbtablesSave")
+	}
+}
+
+func TestCaptureLabel(t *testing.T) {
+	text := "-p tcp -m tcp --dport 22 -m comment --comment service=ssh -j ACCEPT"
+	for _, c := range []struct {
+		capture string
+		label   string
+		groups  map[string]string
+	}{
+		{capture: ".*", label: text, groups: map[string]string{}},
+		{capture: `--dport (\d+).*-j (\w+)`, label: "22 ACCEPT", groups: map[string]string{}},
+		{capture: `--dport (?P<port>\d+).*service=(?P<service>\w+)`, label: text, groups: map[string]string{"port": "22", "service": "ssh"}},
+		{capture: `service=(?P<service>\w+) -j (\w+)`, label: "ACCEPT", groups: map[string]string{"service": "ssh"}},
+		{capture: `-j DROP`},
+	} {
+		capture := regexp.MustCompile(c.capture)
+		label, ok := CaptureLabel(capture, text)
+		if ok != (c.label != "") || label != c.label {
+			t.Errorf("%s: expected label %q, got %q (%t)", c.capture, c.label, label, ok)
+		}
+		if mismatch := deep.Equal(c.groups, CaptureGroups(capture, text)); mismatch != nil {
+			t.Errorf("%s: groups %+v", c.capture, mismatch)
+		}
+	}
+}
+
+func TestCheckIptablesSave(t *testing.T) {
+	input := `*filter
+:INPUT ACCEPT [0:0]
+[1:2] -A INPUT -j ACCEPT
+[1:2] -A OUTPUT -j ACCEPT
+bogus
+COMMIT
+`
+	var lines []int
+	tables, stats, errors, err := CheckIptablesSave(strings.NewReader(input), func(line int, table, chain string, rule Rule) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal([]int{3, 4}, lines); mismatch != nil {
+		t.Errorf("rule lines: %+v", mismatch)
+	}
+	if mismatch := deep.Equal(Tables{"filter": {"INPUT": {Policy: "ACCEPT"}}}, tables); mismatch != nil {
+		t.Errorf("tables: %+v", mismatch)
+	}
+	if stats.Lines != 6 || stats.Rules != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	expected := []ParseError{
+		{"rule of undeclared chain", 4, "[1:2] -A OUTPUT -j ACCEPT"},
+		{"unhandled line", 5, "bogus"},
+	}
+	if !reflect.DeepEqual(expected, errors) {
+		t.Errorf("expected errors %+v, got %+v", expected, errors)
+	}
+}
+
+func TestParseFunc(t *testing.T) {
+	f, err := os.Open("server.iptables-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	expected := make(map[string][]Rule)
+	for tableName, table := range parserTestCases[0].expected {
+		for chainName, chain := range table {
+			if len(chain.Rules) > 0 {
+				expected[tableName+" "+chainName] = chain.Rules
+			}
+		}
+	}
+	result := make(map[string][]Rule)
+	err = ParseFunc(f, func(table, chain string, rule Rule) error {
+		result[table+" "+chain] = append(result[table+" "+chain], rule)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal(expected, result); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
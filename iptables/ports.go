@@ -0,0 +1,86 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports; a single port has From == To.
+type PortRange struct {
+	From, To int
+}
+
+// ParsePorts parses port values like RuleSpec.DPort: a port, a range
+// "1024:65535" or, for multiport, a comma-separated list of both, optionally
+// prefixed with "!". Open ranges like ":1023" or "1024:" are accepted.
+func ParsePorts(value string) (ranges []PortRange, negated bool, err error) {
+	if strings.HasPrefix(value, "!") {
+		negated = true
+		value = value[1:]
+	}
+	for _, part := range strings.Split(value, ",") {
+		from, to := part, part
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			from, to = part[:i], part[i+1:]
+			if from == "" {
+				from = "0"
+			}
+			if to == "" {
+				to = "65535"
+			}
+		}
+		var r PortRange
+		if r.From, err = parsePort(from); err != nil {
+			return nil, false, err
+		}
+		if r.To, err = parsePort(to); err != nil {
+			return nil, false, err
+		}
+		if r.From > r.To {
+			r.From, r.To = r.To, r.From
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, negated, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// PortsContain reports whether port matches port values like RuleSpec.DPort.
+// An empty value matches every port.
+func PortsContain(value string, port int) bool {
+	if value == "" {
+		return true
+	}
+	ranges, negated, err := ParsePorts(value)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if port >= r.From && port <= r.To {
+			return !negated
+		}
+	}
+	return negated
+}
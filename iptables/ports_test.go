@@ -0,0 +1,59 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParsePorts(t *testing.T) {
+	ranges, negated, err := ParsePorts("!22,1024:2048,:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PortRange{{22, 22}, {1024, 2048}, {0, 80}}
+	if mismatch := deep.Equal(expected, ranges); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+	if !negated {
+		t.Fatalf("expected negated ports")
+	}
+	if _, _, err := ParsePorts("ssh"); err == nil {
+		t.Fatalf("expected error for named port")
+	}
+}
+
+func TestPortsContain(t *testing.T) {
+	tests := []struct {
+		value    string
+		port     int
+		expected bool
+	}{
+		{"", 22, true},
+		{"22", 22, true},
+		{"22", 23, false},
+		{"20:25", 22, true},
+		{"80,443", 443, true},
+		{"!22", 22, false},
+		{"!22", 80, true},
+	}
+	for _, test := range tests {
+		if got := PortsContain(test.value, test.port); got != test.expected {
+			t.Errorf("PortsContain(%q, %d) = %v, expected %v", test.value, test.port, got, test.expected)
+		}
+	}
+}
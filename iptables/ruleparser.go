@@ -24,6 +24,9 @@ type ruleParser struct {
 	currentValues []string
 	chain         string
 	flags         []string
+	// counterOption reads the counters from -c packets bytes, as written
+	// by ebtables-save
+	counterOption bool
 }
 
 func (p *ruleParser) flush() {
@@ -34,6 +37,12 @@ func (p *ruleParser) flush() {
 		if len(p.currentValues) > 0 {
 			p.chain = p.currentValues[0]
 		}
+	case "-c":
+		if p.counterOption && len(p.currentValues) == 2 {
+			p.packets, p.bytes, p.countersOk = parseCounters("[" + p.currentValues[0] + ":" + p.currentValues[1] + "]")
+			break
+		}
+		fallthrough
 	default:
 		p.flags = append(p.flags, p.current)
 		p.flags = append(p.flags, p.currentValues...)
@@ -0,0 +1,240 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import "strings"
+
+// RuleSpec is the interpretation of a rule's text.
+type RuleSpec struct {
+	Source       string
+	Destination  string
+	Proto        string
+	InInterface  string
+	OutInterface string
+	SPort        string
+	DPort        string
+	Comment      string
+	Target       string
+	// Goto is true if the rule continues in Target with -g instead of -j.
+	Goto bool
+	// Matches lists the match extensions loaded with -m, in order.
+	Matches []string
+	// Options lists every option of the rule, in order.
+	Options []Option
+}
+
+// Option is a single flag of a rule together with its values.
+type Option struct {
+	// Match is the extension the option belongs to: the match loaded by the
+	// last preceding -m, the target for options following -j, or empty for
+	// the generic options.
+	Match   string
+	Flag    string
+	Values  []string
+	Negated bool
+}
+
+// Value returns the values of the option joined by spaces, prefixed with
+// "!" if the option is negated.
+func (o Option) Value() string {
+	v := strings.Join(o.Values, " ")
+	if o.Negated {
+		return "!" + v
+	}
+	return v
+}
+
+// Option returns the first option with the given flag.
+func (s RuleSpec) Option(flag string) (Option, bool) {
+	for _, o := range s.Options {
+		if o.Flag == flag {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// MatchOption returns the first option with the given flag belonging to the
+// given match extension or target.
+func (s RuleSpec) MatchOption(match, flag string) (Option, bool) {
+	for _, o := range s.Options {
+		if o.Match == match && o.Flag == flag {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// HasMatch reports whether the rule loads the given match extension.
+func (s RuleSpec) HasMatch(match string) bool {
+	for _, m := range s.Matches {
+		if m == match {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRuleSpec interprets the text of a rule as found in Rule.Text.
+func ParseRuleSpec(text string) RuleSpec {
+	var spec RuleSpec
+	var current *Option
+	var match string
+	negate := false
+	for _, token := range splitRule(text) {
+		if token.raw == "!" {
+			if current != nil && len(current.Values) == 0 && !valuelessFlags[current.Flag] {
+				// Old style "-s ! 10.0.0.0/8"
+				current.Negated = true
+			} else {
+				negate = true
+			}
+			continue
+		}
+		if isFlag(token.raw) {
+			option := Option{Match: match, Flag: token.raw, Negated: negate}
+			if genericFlags[token.raw] {
+				option.Match = ""
+			}
+			spec.Options = append(spec.Options, option)
+			current = &spec.Options[len(spec.Options)-1]
+			negate = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		current.Values = append(current.Values, token.value)
+		switch current.Flag {
+		case "-m", "--match":
+			match = token.value
+			spec.Matches = append(spec.Matches, match)
+		case "-j", "--jump", "-g", "--goto":
+			match = token.value
+		}
+	}
+	for _, o := range spec.Options {
+		switch o.Flag {
+		case "-s", "--source":
+			spec.Source = o.Value()
+		case "-d", "--destination":
+			spec.Destination = o.Value()
+		case "-p", "--protocol":
+			spec.Proto = o.Value()
+		case "-i", "--in-interface":
+			spec.InInterface = o.Value()
+		case "-o", "--out-interface":
+			spec.OutInterface = o.Value()
+		case "-j", "--jump":
+			spec.Target = o.Value()
+		case "-g", "--goto":
+			spec.Target = o.Value()
+			spec.Goto = true
+		case "--sport", "--source-port", "--sports", "--source-ports":
+			if spec.SPort == "" {
+				spec.SPort = o.Value()
+			}
+		case "--dport", "--destination-port", "--dports", "--destination-ports":
+			if spec.DPort == "" {
+				spec.DPort = o.Value()
+			}
+		case "--comment":
+			if o.Match == "comment" {
+				spec.Comment = o.Value()
+			}
+		}
+	}
+	return spec
+}
+
+func isFlag(token string) bool {
+	return len(token) > 1 && token[0] == '-' && (token[1] < '0' || token[1] > '9')
+}
+
+// genericFlags are the options not belonging to any extension.
+var genericFlags = map[string]bool{
+	"-s": true, "--source": true,
+	"-d": true, "--destination": true,
+	"-p": true, "--protocol": true,
+	"-i": true, "--in-interface": true,
+	"-o": true, "--out-interface": true,
+	"-f": true, "--fragment": true,
+	"-m": true, "--match": true,
+	"-j": true, "--jump": true,
+	"-g": true, "--goto": true,
+}
+
+// valuelessFlags are the options taking no value, so a "!" following them
+// negates the next option rather than them.
+var valuelessFlags = map[string]bool{
+	"-f": true, "--fragment": true,
+	"--syn": true,
+	"--set": true, "--rcheck": true, "--update": true, "--remove": true,
+	"--rsource": true, "--rdest": true, "--reap": true, "--rttl": true,
+	"--log-tcp-sequence": true, "--log-tcp-options": true, "--log-ip-options": true,
+	"--log-uid": true, "--log-macdecode": true,
+	"--clamp-mss-to-pmtu": true, "--checksum-fill": true, "--notrack": true,
+	"--random": true, "--random-fully": true, "--persistent": true,
+	"--ecn-tcp-cwr": true, "--ecn-tcp-ece": true, "--ecn-tcp-remove": true,
+	"--physdev-is-in": true, "--physdev-is-out": true, "--physdev-is-bridged": true,
+	"--socket-exists": true, "--transparent": true, "--nowildcard": true,
+	"--restore": true, "--save": true, "--exist": true,
+	"--strict": true, "--loose": true, "--validmark": true, "--invert": true,
+}
+
+type ruleToken struct {
+	// raw is the token as written, value the token with quotes removed
+	raw   string
+	value string
+}
+
+// splitRule splits a rule into tokens, keeping double quoted strings (with
+// backslash escapes, as written by iptables-save) together.
+func splitRule(text string) []ruleToken {
+	var tokens []ruleToken
+	var raw, value strings.Builder
+	inToken, quoted, escaped := false, false, false
+	emit := func() {
+		if inToken {
+			tokens = append(tokens, ruleToken{raw: raw.String(), value: value.String()})
+		}
+		raw.Reset()
+		value.Reset()
+		inToken = false
+	}
+	for _, r := range text {
+		switch {
+		case escaped:
+			raw.WriteRune(r)
+			value.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			raw.WriteRune(r)
+			escaped = true
+		case r == '"':
+			raw.WriteRune(r)
+			inToken = true
+			quoted = !quoted
+		case !quoted && (r == ' ' || r == '\t'):
+			emit()
+		default:
+			raw.WriteRune(r)
+			value.WriteRune(r)
+			inToken = true
+		}
+	}
+	emit()
+	return tokens
+}
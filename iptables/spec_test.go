@@ -0,0 +1,137 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+var ruleSpecTestCases = []struct {
+	text     string
+	expected RuleSpec
+}{
+	{
+		text: "-s 10.10.10.0/24 -d 10.10.10.1/32 -p tcp -m tcp --dport 80 -j ACCEPT",
+		expected: RuleSpec{
+			Source:      "10.10.10.0/24",
+			Destination: "10.10.10.1/32",
+			Proto:       "tcp",
+			DPort:       "80",
+			Target:      "ACCEPT",
+			Matches:     []string{"tcp"},
+			Options: []Option{
+				{Flag: "-s", Values: []string{"10.10.10.0/24"}},
+				{Flag: "-d", Values: []string{"10.10.10.1/32"}},
+				{Flag: "-p", Values: []string{"tcp"}},
+				{Flag: "-m", Values: []string{"tcp"}},
+				{Match: "tcp", Flag: "--dport", Values: []string{"80"}},
+				{Flag: "-j", Values: []string{"ACCEPT"}},
+			},
+		},
+	},
+	{
+		text: `! -i lo -p udp -m multiport --dports 53,123 -m comment --comment "team=dns \"core\"" -g DNS`,
+		expected: RuleSpec{
+			InInterface: "!lo",
+			Proto:       "udp",
+			DPort:       "53,123",
+			Comment:     `team=dns "core"`,
+			Target:      "DNS",
+			Goto:        true,
+			Matches:     []string{"multiport", "comment"},
+			Options: []Option{
+				{Flag: "-i", Values: []string{"lo"}, Negated: true},
+				{Flag: "-p", Values: []string{"udp"}},
+				{Flag: "-m", Values: []string{"multiport"}},
+				{Match: "multiport", Flag: "--dports", Values: []string{"53,123"}},
+				{Flag: "-m", Values: []string{"comment"}},
+				{Match: "comment", Flag: "--comment", Values: []string{`team=dns "core"`}},
+				{Flag: "-g", Values: []string{"DNS"}},
+			},
+		},
+	},
+	{
+		text: `-p icmp -j REJECT --reject-with icmp-port-unreachable`,
+		expected: RuleSpec{
+			Proto:  "icmp",
+			Target: "REJECT",
+			Options: []Option{
+				{Flag: "-p", Values: []string{"icmp"}},
+				{Flag: "-j", Values: []string{"REJECT"}},
+				{Match: "REJECT", Flag: "--reject-with", Values: []string{"icmp-port-unreachable"}},
+			},
+		},
+	},
+	{
+		text: `-s ! 10.0.0.0/8 -j ACCEPT`,
+		expected: RuleSpec{
+			Source: "!10.0.0.0/8",
+			Target: "ACCEPT",
+			Options: []Option{
+				{Flag: "-s", Values: []string{"10.0.0.0/8"}, Negated: true},
+				{Flag: "-j", Values: []string{"ACCEPT"}},
+			},
+		},
+	},
+	{
+		// A "!" after an option without value negates the next option.
+		text: `-p tcp -m tcp --syn ! --dport 22 -m recent --set --name SSH --rsource ! --rcheck -j DROP`,
+		expected: RuleSpec{
+			Proto:   "tcp",
+			DPort:   "!22",
+			Target:  "DROP",
+			Matches: []string{"tcp", "recent"},
+			Options: []Option{
+				{Flag: "-p", Values: []string{"tcp"}},
+				{Flag: "-m", Values: []string{"tcp"}},
+				{Match: "tcp", Flag: "--syn"},
+				{Match: "tcp", Flag: "--dport", Values: []string{"22"}, Negated: true},
+				{Flag: "-m", Values: []string{"recent"}},
+				{Match: "recent", Flag: "--set"},
+				{Match: "recent", Flag: "--name", Values: []string{"SSH"}},
+				{Match: "recent", Flag: "--rsource"},
+				{Match: "recent", Flag: "--rcheck", Negated: true},
+				{Flag: "-j", Values: []string{"DROP"}},
+			},
+		},
+	},
+}
+
+func TestParseRuleSpec(t *testing.T) {
+	for _, tc := range ruleSpecTestCases {
+		if mismatch := deep.Equal(tc.expected, ParseRuleSpec(tc.text)); mismatch != nil {
+			t.Fatalf("%s: %+v", tc.text, mismatch)
+		}
+	}
+}
+
+func TestRuleSpecCached(t *testing.T) {
+	for _, tc := range ruleSpecTestCases {
+		rule := newRule(0, 0, tc.text)
+		copied := rule
+		for _, r := range []Rule{rule, copied, {Text: tc.text}} {
+			if mismatch := deep.Equal(tc.expected, r.Spec()); mismatch != nil {
+				t.Fatalf("%s: %+v", tc.text, mismatch)
+			}
+		}
+		// The copy shares the interpretation made for the original.
+		copied.Text = ""
+		if mismatch := deep.Equal(tc.expected, copied.Spec()); mismatch != nil {
+			t.Fatalf("%s: %+v", tc.text, mismatch)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeMatch is the schedule of a rule using the time match.
+type TimeMatch struct {
+	// Start and Stop are the daily window as offsets from midnight. Stop
+	// before Start wraps around midnight.
+	Start, Stop time.Duration
+	// Weekdays and Monthdays are the days the rule applies, with the
+	// negation as written, or empty for every day.
+	Weekdays  string
+	Monthdays string
+	// DateStart and DateStop bound the schedule if not zero.
+	DateStart, DateStop time.Time
+	// KernelTZ is true for times in the kernel's time zone rather than
+	// UTC.
+	KernelTZ bool
+	// Contiguous makes a window wrapping around midnight belong to the day
+	// it starts on.
+	Contiguous bool
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday, "Thu": time.Thursday,
+	"Fri": time.Friday, "Sat": time.Saturday, "Sun": time.Sunday,
+	"1": time.Monday, "2": time.Tuesday, "3": time.Wednesday, "4": time.Thursday,
+	"5": time.Friday, "6": time.Saturday, "7": time.Sunday,
+}
+
+const dateLayout = "2006-01-02T15:04:05"
+
+// TimeMatch returns the schedule of the time match of the rule, if it has
+// a valid one.
+func (s RuleSpec) TimeMatch() (TimeMatch, bool) {
+	if !s.HasMatch("time") {
+		return TimeMatch{}, false
+	}
+	m := TimeMatch{Stop: 24*time.Hour - time.Second}
+	for _, o := range s.Options {
+		if o.Match != "time" {
+			continue
+		}
+		var err error
+		switch o.Flag {
+		case "--timestart":
+			m.Start, err = parseDayTime(o.Value())
+		case "--timestop":
+			m.Stop, err = parseDayTime(o.Value())
+		case "--weekdays":
+			m.Weekdays = o.Value()
+			_, err = parseWeekdays(o.Values)
+		case "--monthdays":
+			m.Monthdays = o.Value()
+			_, err = parseMonthdays(o.Values)
+		case "--datestart":
+			m.DateStart, err = parseDate(o.Value())
+		case "--datestop":
+			m.DateStop, err = parseDate(o.Value())
+		case "--kerneltz":
+			m.KernelTZ = true
+		case "--contiguous":
+			m.Contiguous = true
+		}
+		if err != nil {
+			return TimeMatch{}, false
+		}
+	}
+	return m, true
+}
+
+func parseDayTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second}[:len(parts)] {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || (i == 0 && n > 23) || (i > 0 && n > 59) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	layout := dateLayout
+	if len(s) < len(layout) {
+		layout = layout[:len(s)]
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
+
+// parseWeekdays parses the values of --weekdays, without negation.
+func parseWeekdays(values []string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			day, ok := weekdayNames[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %q", name)
+			}
+			days[day] = true
+		}
+	}
+	return days, nil
+}
+
+// parseMonthdays parses the values of --monthdays, without negation.
+func parseMonthdays(values []string) (map[int]bool, error) {
+	days := make(map[int]bool)
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			day, err := strconv.Atoi(s)
+			if err != nil || day < 1 || day > 31 {
+				return nil, fmt.Errorf("invalid day of month %q", s)
+			}
+			days[day] = true
+		}
+	}
+	return days, nil
+}
+
+// daysInclude reports whether spec, as in TimeMatch.Weekdays or Monthdays,
+// includes a day, given whether its values without negation include it.
+func daysInclude(spec string, include func(values []string) bool) bool {
+	if spec == "" {
+		return true
+	}
+	negated := strings.HasPrefix(spec, "!")
+	return include(strings.Fields(strings.TrimPrefix(spec, "!"))) != negated
+}
+
+// Active reports whether the schedule includes t, evaluated in UTC or, with
+// KernelTZ, in the local time zone like the kernel does.
+func (m TimeMatch) Active(t time.Time) bool {
+	if m.KernelTZ {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+	// Dates are compared in the same zone as the times.
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	if !m.DateStart.IsZero() && wall.Before(m.DateStart) {
+		return false
+	}
+	if !m.DateStop.IsZero() && wall.After(m.DateStop) {
+		return false
+	}
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t
+	if m.Start <= m.Stop {
+		if now < m.Start || now > m.Stop {
+			return false
+		}
+	} else {
+		if now < m.Start && now > m.Stop {
+			return false
+		}
+		if m.Contiguous && now <= m.Stop {
+			day = t.AddDate(0, 0, -1)
+		}
+	}
+	weekday := daysInclude(m.Weekdays, func(values []string) bool {
+		days, err := parseWeekdays(values)
+		return err == nil && days[day.Weekday()]
+	})
+	monthday := daysInclude(m.Monthdays, func(values []string) bool {
+		days, err := parseMonthdays(values)
+		return err == nil && days[day.Day()]
+	})
+	return weekday && monthday
+}
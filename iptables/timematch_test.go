@@ -0,0 +1,61 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeMatch(t *testing.T) {
+	// 2024-03-04 is a Monday.
+	monday := func(clock string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", "2024-03-04 "+clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	tests := []struct {
+		text     string
+		at       time.Time
+		expected bool
+	}{
+		{"-m time --timestart 09:00:00 --timestop 17:00:00 --weekdays Mon,Tue,Wed,Thu,Fri -j ACCEPT", monday("12:00"), true},
+		{"-m time --timestart 09:00:00 --timestop 17:00:00 --weekdays Mon,Tue,Wed,Thu,Fri -j ACCEPT", monday("18:00"), false},
+		{"-m time --timestart 09:00:00 --timestop 17:00:00 --weekdays Sat,Sun -j ACCEPT", monday("12:00"), false},
+		{"-m time --timestart 09:00:00 --timestop 17:00:00 ! --weekdays Sat,Sun -j ACCEPT", monday("12:00"), true},
+		{"-m time --timestart 22:00:00 --timestop 06:00:00 -j DROP", monday("03:00"), true},
+		{"-m time --timestart 22:00:00 --timestop 06:00:00 -j DROP", monday("12:00"), false},
+		{"-m time --timestart 22:00:00 --timestop 06:00:00 --weekdays Sun --contiguous -j DROP", monday("03:00"), true},
+		{"-m time --timestart 22:00:00 --timestop 06:00:00 --weekdays Sun -j DROP", monday("03:00"), false},
+		{"-m time --monthdays 1,4 -j ACCEPT", monday("12:00"), true},
+		{"-m time --datestart 2024-03-05T00:00:00 -j ACCEPT", monday("12:00"), false},
+		{"-m time --datestop 2024-03-05T00:00:00 -j ACCEPT", monday("12:00"), true},
+	}
+	for _, test := range tests {
+		m, ok := ParseRuleSpec(test.text).TimeMatch()
+		if !ok {
+			t.Errorf("no time match found in %q", test.text)
+			continue
+		}
+		if got := m.Active(test.at); got != test.expected {
+			t.Errorf("%q active at %s = %v, expected %v", test.text, test.at, got, test.expected)
+		}
+	}
+	if _, ok := ParseRuleSpec("-m time --weekdays Funday -j ACCEPT").TimeMatch(); ok {
+		t.Fatalf("expected invalid weekday to be rejected")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import "context"
+
+// Tool is a netfilter tool besides iptables whose save command writes the
+// format of iptables-save, parsed by ParseEbtablesSave.
+type Tool string
+
+const (
+	Ebtables  Tool = "ebtables"
+	Arptables Tool = "arptables"
+)
+
+// SaveCommand returns the command dumping the tables of t.
+func (t Tool) SaveCommand() string {
+	return string(t) + "-save"
+}
+
+// GetToolTablesStats is GetTablesStats for the save command of tool,
+// ignoring opts.Family.
+func GetToolTablesStats(ctx context.Context, tool Tool, opts Options) (Tables, ParseStats, error) {
+	save := saveCommand{name: tool.SaveCommand(), parse: ParseEbtablesSave}
+	if tool == Ebtables {
+		// The legacy ebtables-save only writes counters if told so by
+		// the environment.
+		save.env = []string{"EBTABLES_SAVE_COUNTER=yes"}
+	}
+	return dump(ctx, opts, save)
+}
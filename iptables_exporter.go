@@ -15,28 +15,150 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
 	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/steigr/iptables_exporter/ipset"
 	"github.com/steigr/iptables_exporter/iptables"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 type collector struct {
-	capture *regexp.Regexp
+	source tablesSource
+	// timeout limits how long the save commands of a family may run
+	timeout time.Duration
+	// ipFamilies are the families to collect
+	ipFamilies []iptables.Family
+	capture    *regexp.Regexp
+	// captures override capture for some tables and chains
+	captures chainCaptures
+	// unmatched tells what to do with rules capture doesn't match
+	unmatched string
+	health    *collectionHealth
+	families  *familyAvailability
+
+	mergeFamilies bool
+	ruleTemplate  *template.Template
+	// labelFromComment exports only commented rules, by their comment
+	labelFromComment bool
+	setEntries       bool
+	// chains selects the chains whose rules are read, nil for all
+	chains func(table, chain string) bool
+	// filter, if set, selects the tables and chains collected
+	filter *iptables.Filter
+	// policiesOnly skips parsing and exporting rules
+	policiesOnly bool
+
+	labelers        []ruleLabeler
+	ruleBytesDesc   *prometheus.Desc
+	rulePacketsDesc *prometheus.Desc
+
+	quotaDesc          *prometheus.Desc
+	quotaRemainingDesc *prometheus.Desc
+	limitRateDesc      *prometheus.Desc
+	limitBurstDesc     *prometheus.Desc
+	scheduleDesc       *prometheus.Desc
+	scheduleActiveDesc *prometheus.Desc
+
+	observers []collectionObserver
+	checks    []complianceCheck
+
+	// worldOpenPorts are the ports to report rules opening to everyone for
+	worldOpenPorts []int
+	// protocols exports the counters of every chain per protocol
+	protocols bool
+	// logPrefixes exports the counters of logging rules per prefix
+	logPrefixes bool
+	// chainJumps exports the jumps between chains
+	chainJumps bool
+	// multiportExpand exports rules once per destination port, with a dport
+	// label
+	multiportExpand bool
+	// baselines are the files holding the approved ruleset of each family
+	baselines map[iptables.Family]string
+	// savedRules are the files holding the saved ruleset of each family
+	savedRules map[iptables.Family]string
+	// csf exports the deny lists of ConfigServer Firewall
+	csf bool
+	// cache, if set, serves scrapes from the last collection for a while
+	cache *tablesCache
+
+	// continuity, if set, compensates for counter resets of rules that were
+	// removed and inserted again
+	continuity                *counterState
+	continuousRuleBytesDesc   *prometheus.Desc
+	continuousRulePacketsDesc *prometheus.Desc
+
+	// lastActive, if set, tracks when the packet counter of each rule last
+	// increased
+	lastActive     *counterState
+	lastActiveDesc *prometheus.Desc
+}
+
+// anyFamily labels rules merged across IP families.
+const anyFamily iptables.Family = "any"
+
+// ruleLabeler adds labels of its own to the rule metrics.
+type ruleLabeler interface {
+	// labelNames returns the names of the labels added by the labeler.
+	labelNames() []string
+	// labelValues returns a value for each of labelNames, or skip to not
+	// export the rule at all.
+	labelValues(table, chain string, rule iptables.Rule) (values []string, skip bool)
 }
 
+// ruleCounter maps the joined label values of a rule series to its counters.
 type ruleCounter map[string]*ruleValues
 
 type ruleValues struct {
+	labels  []string
 	bytes   float64
 	packets float64
+	// quota is the sum of the byte quotas of the rules, if hasQuota, and
+	// quotaUsed the bytes matched by these rules
+	quota     float64
+	quotaUsed float64
+	hasQuota  bool
+	// limit is the rate limit of the first rule having one
+	limit *iptables.RateLimit
+	// schedule is the time match of the first rule having one
+	schedule *iptables.TimeMatch
+}
+
+// add adds the counters and quota of rule.
+func (v *ruleValues) add(rule iptables.Rule) {
+	v.bytes += float64(rule.Bytes)
+	v.packets += float64(rule.Packets)
+	if quota, ok := ruleQuota(rule); ok {
+		v.quota += quota
+		v.quotaUsed += float64(rule.Bytes)
+		v.hasQuota = true
+	}
+	if v.limit == nil {
+		if limit, ok := ruleRateLimit(rule); ok {
+			v.limit = &limit
+		}
+	}
+	if v.schedule == nil {
+		if schedule, ok := ruleSchedule(rule); ok {
+			v.schedule = &schedule
+		}
+	}
 }
 
 var (
@@ -54,67 +176,650 @@ var (
 		nil,
 	)
 
+	familyAvailableDesc = prometheus.NewDesc(
+		"iptables_family_available",
+		"iptables_exporter: Whether the tables of an IP family could be collected.",
+		[]string{"ip_family"},
+		nil,
+	)
+
+	familyTimeoutsDesc = prometheus.NewDesc(
+		"iptables_scrape_timeouts_total",
+		"iptables_exporter: Total collections of an IP family killed as they exceeded the timeout.",
+		[]string{"ip_family"},
+		nil,
+	)
+
+	setEntriesDesc = prometheus.NewDesc(
+		"iptables_set_entries",
+		"iptables_exporter: Number of entries of an IP set matched by rules.",
+		[]string{"set"},
+		nil,
+	)
+
+	undefinedReferencesDesc = prometheus.NewDesc(
+		"iptables_undefined_chain_references",
+		"iptables_exporter: Number of rules of a chain targeting a chain that doesn't exist in its table.",
+		[]string{"table", "chain", "target", "ip_family"},
+		nil,
+	)
+
+	rulesNotCapturedDesc = prometheus.NewDesc(
+		"iptables_rules_not_captured",
+		"iptables_exporter: Number of rules the capture regexp didn't match in the last collection.",
+		[]string{"ip_family"},
+		nil,
+	)
+
+	chainsDesc = prometheus.NewDesc(
+		"iptables_chains",
+		"iptables_exporter: Number of chains of a table.",
+		[]string{"table", "ip_family"},
+		nil,
+	)
+
 	defaultBytesDesc = prometheus.NewDesc(
 		"iptables_default_bytes_total",
 		"iptables_exporter: Total bytes matching a chain's default policy.",
-		[]string{"table", "chain", "policy"},
+		[]string{"table", "chain", "policy", "ip_family"},
 		nil,
 	)
 
 	defaultPacketsDesc = prometheus.NewDesc(
 		"iptables_default_packets_total",
 		"iptables_exporter: Total packets matching a chain's default policy.",
-		[]string{"table", "chain", "policy"},
+		[]string{"table", "chain", "policy", "ip_family"},
 		nil,
 	)
+)
 
-	ruleBytesDesc = prometheus.NewDesc(
-		"iptables_rule_bytes_total",
-		"iptables_exporter: Total bytes matching a rule.",
-		[]string{"table", "chain", "rule"},
-		nil,
-	)
+// Options configure the collector. The zero value exports every rule with
+// its full text as rule label.
+type Options struct {
+	// CaptureRE selects the rules to export and the bits of them to use as
+	// rule label, see iptables.CaptureLabel. Empty matches every rule.
+	CaptureRE string
+	// Captures override CaptureRE for the rules of some tables and chains.
+	Captures chainCaptures
+	// Unmatched is the policy for rules the capture regexp doesn't match:
+	// skip them (the default), export them with their full text as rule
+	// label, or export them summed up in a "_unmatched" bucket.
+	Unmatched string
+	// RuleTemplate, if set, renders the rule label instead.
+	RuleTemplate *template.Template
+	// LabelFromComment exports only the rules with a comment, using the
+	// comment as rule label instead.
+	LabelFromComment bool
+	// Labelers add labels to the rule metrics, or skip rules.
+	Labelers []ruleLabeler
 
-	rulePacketsDesc = prometheus.NewDesc(
-		"iptables_rule_packets_total",
-		"iptables_exporter: Total packets matching a rule.",
-		[]string{"table", "chain", "rule"},
-		nil,
-	)
-)
+	// Families are the IP families to collect, by default all of them.
+	Families []iptables.Family
+
+	// PoliciesOnly skips the rules, exporting only chains and their policy
+	// counters.
+	PoliciesOnly bool
+	// MergeFamilies exports rules identical across IP families once.
+	MergeFamilies bool
+	// SetEntries exports the size of IP sets matched by rules.
+	SetEntries bool
+	// Continuity, if set, compensates for counter resets of rules that
+	// were removed and inserted again.
+	Continuity *counterState
+	// LastActive, if set, tracks when rules last matched a packet to export
+	// iptables_rule_last_active_timestamp_seconds.
+	LastActive *counterState
+	// Checks are the compliance checks to export.
+	Checks []complianceCheck
+	// WorldOpenPorts are the ports to report rules open to everyone for.
+	WorldOpenPorts []int
+	// Protocols exports the counters of the rules of every chain summed up
+	// per protocol.
+	Protocols bool
+	// LogPrefixes exports the counters of LOG and NFLOG rules summed up
+	// per prefix.
+	LogPrefixes bool
+	// ChainJumps exports the number of rules jumping from chain to chain.
+	ChainJumps bool
+	// MultiportExpand adds a dport label to the rule metrics, exporting
+	// rules matching several ports once per port, each with the counters
+	// of the whole rule.
+	MultiportExpand bool
+	// SavedRules are the files holding the saved rulesets of the families
+	// to compare the running ones with.
+	SavedRules map[iptables.Family]string
+	// Baselines are the files holding the approved rulesets of the families
+	// to compare the running ones with.
+	Baselines map[iptables.Family]string
+	// CSF exports the size and traffic of the deny lists of ConfigServer
+	// Firewall.
+	CSF bool
+	// CacheTTL, if positive, serves scrapes from the last collection for
+	// that long.
+	CacheTTL time.Duration
+	// Timeout, if positive, kills the save commands of a family running
+	// longer, e.g. waiting for the xtables lock.
+	Timeout time.Duration
+	// ScrapeInterval, if positive, has refreshTables collect the tables
+	// every interval rather than the scrapes, which are served the last
+	// collection. It takes precedence over CacheTTL.
+	ScrapeInterval time.Duration
+
+	// Source dumps the tables, by default by running the save commands
+	// locally.
+	Source tablesSource
+	// Target names the host collected from in log messages if it isn't the
+	// local one.
+	Target string
+
+	// Health, if set, is told the outcome of every collection.
+	Health *collectionHealth
+	// Chains, if set, selects the chains whose rules are read while
+	// parsing; the rules of other chains are left out of everything.
+	Chains func(table, chain string) bool
+	// Filter, if set, selects the tables and chains to collect. The others
+	// are left out of everything, and the rules of excluded chains aren't
+	// even parsed.
+	Filter *iptables.Filter
+	// Observers are notified of every successful collection.
+	Observers []collectionObserver
+}
 
-func NewCollector(captureRE string) collector {
+// NewCollector returns a collector as set up by opts. It fails if the
+// labelers' labels clash with each other or with the collector's own.
+func NewCollector(opts Options) (collector, error) {
+	labelNames := []string{"table", "chain", "rule", "ip_family"}
+	for _, l := range opts.Labelers {
+		labelNames = append(labelNames, l.labelNames()...)
+	}
+	if opts.MultiportExpand {
+		labelNames = append(labelNames, "dport")
+	}
+	if err := checkLabelNames(labelNames); err != nil {
+		return collector{}, err
+	}
+	captureRE := opts.CaptureRE
+	if captureRE == "" {
+		captureRE = ".*"
+	}
+	health := opts.Health
+	if health == nil {
+		health = newCollectionHealth(0)
+	}
+	source := opts.Source
+	if source == nil {
+		source = localSource{}
+	}
+	unmatched := opts.Unmatched
+	if unmatched == "" {
+		unmatched = unmatchedSkip
+	}
+	chains := opts.Chains
+	if opts.Filter != nil {
+		filter, selected := opts.Filter, opts.Chains
+		chains = func(table, chain string) bool {
+			return filter.Chain(table, chain) && (selected == nil || selected(table, chain))
+		}
+	}
+	ipFamilies := opts.Families
+	if len(ipFamilies) == 0 {
+		ipFamilies = iptables.Families
+	}
 	// Let regexp.MustCompile panic if regex is not valid
 	return collector{
-		capture: regexp.MustCompile(captureRE),
+		source:           source,
+		timeout:          opts.Timeout,
+		ipFamilies:       ipFamilies,
+		capture:          regexp.MustCompile(captureRE),
+		captures:         opts.Captures,
+		unmatched:        unmatched,
+		health:           health,
+		families:         newFamilyAvailability(opts.Target),
+		policiesOnly:     opts.PoliciesOnly,
+		chains:           chains,
+		filter:           opts.Filter,
+		mergeFamilies:    opts.MergeFamilies,
+		ruleTemplate:     opts.RuleTemplate,
+		labelFromComment: opts.LabelFromComment,
+		setEntries:       opts.SetEntries,
+		labelers:         opts.Labelers,
+		continuity:       opts.Continuity,
+		lastActive:       opts.LastActive,
+		observers:        opts.Observers,
+		checks:           opts.Checks,
+		worldOpenPorts:   opts.WorldOpenPorts,
+		logPrefixes:      opts.LogPrefixes,
+		protocols:        opts.Protocols,
+		chainJumps:       opts.ChainJumps,
+		multiportExpand:  opts.MultiportExpand,
+		savedRules:       opts.SavedRules,
+		baselines:        opts.Baselines,
+		csf:              opts.CSF,
+		cache:            newTablesCache(opts.CacheTTL, opts.ScrapeInterval),
+		ruleBytesDesc: prometheus.NewDesc(
+			"iptables_rule_bytes_total",
+			"iptables_exporter: Total bytes matching a rule.",
+			labelNames,
+			nil,
+		),
+		rulePacketsDesc: prometheus.NewDesc(
+			"iptables_rule_packets_total",
+			"iptables_exporter: Total packets matching a rule.",
+			labelNames,
+			nil,
+		),
+		continuousRuleBytesDesc: prometheus.NewDesc(
+			"iptables_rule_continuous_bytes_total",
+			"iptables_exporter: Total bytes matching a rule, continued across resets of its counters.",
+			labelNames,
+			nil,
+		),
+		continuousRulePacketsDesc: prometheus.NewDesc(
+			"iptables_rule_continuous_packets_total",
+			"iptables_exporter: Total packets matching a rule, continued across resets of its counters.",
+			labelNames,
+			nil,
+		),
+		quotaDesc: prometheus.NewDesc(
+			"iptables_rule_quota_bytes",
+			"iptables_exporter: Byte quota of a rule using the quota match.",
+			labelNames,
+			nil,
+		),
+		quotaRemainingDesc: prometheus.NewDesc(
+			"iptables_rule_quota_remaining_bytes",
+			"iptables_exporter: Bytes left of the quota of a rule using the quota match.",
+			labelNames,
+			nil,
+		),
+		limitRateDesc: prometheus.NewDesc(
+			"iptables_rule_limit_rate",
+			"iptables_exporter: Rate per second configured by the limit or hashlimit match of a rule.",
+			append(labelNames[:len(labelNames):len(labelNames)], "limit_match", "limit_unit"),
+			nil,
+		),
+		limitBurstDesc: prometheus.NewDesc(
+			"iptables_rule_limit_burst",
+			"iptables_exporter: Burst configured by the limit or hashlimit match of a rule.",
+			append(labelNames[:len(labelNames):len(labelNames)], "limit_match", "limit_unit"),
+			nil,
+		),
+		scheduleDesc: prometheus.NewDesc(
+			"iptables_rule_time_schedule",
+			"iptables_exporter: Schedule configured by the time match of a rule, always 1.",
+			append(labelNames[:len(labelNames):len(labelNames)], "time_start", "time_stop", "time_weekdays", "time_monthdays"),
+			nil,
+		),
+		scheduleActiveDesc: prometheus.NewDesc(
+			"iptables_rule_time_active",
+			"iptables_exporter: Whether the schedule of the time match of a rule currently applies.",
+			labelNames,
+			nil,
+		),
+		lastActiveDesc: prometheus.NewDesc(
+			"iptables_rule_last_active_timestamp_seconds",
+			"iptables_exporter: When the packet counter of a rule was last seen increasing.",
+			labelNames,
+			nil,
+		),
+	}, nil
+}
+
+func (c *collector) getTables(family iptables.Family, trace *scrapeTrace) (iptables.Tables, iptables.ParseStats, error) {
+	start := time.Now()
+	capture := c.capture
+	postCapture := c.captures != nil || c.unmatched != unmatchedSkip
+	if postCapture {
+		// Captured below, per chain.
+		capture = nil
+	}
+	var dump []string
+	if c.filter != nil {
+		dump = c.filter.Tables()
 	}
+	tables, stats, err := c.source.getTables(context.Background(), iptables.Options{
+		Family:    family,
+		Tables:    dump,
+		Capture:   capture,
+		SkipRules: c.policiesOnly,
+		Chains:    c.chains,
+		Timeout:   c.timeout,
+		Exited:    recordExit,
+	})
+	if err == nil && c.filter != nil {
+		c.filter.Apply(tables)
+	}
+	if err == nil && postCapture && !c.policiesOnly {
+		c.captures.apply(tables, c.capture, c.unmatched, &stats)
+	}
+	if _, local := c.source.(localSource); local {
+		timeExec(family.SaveCommand(), "", start)
+	}
+	if err == nil && len(tables) == 0 {
+		err = fmt.Errorf("no output from %s; this is probably due to insufficient permissions", family.SaveCommand())
+	}
+	c.families.record(family, err)
+	trace.command(family.SaveCommand(), family, start, err).parsed(stats)
+	return tables, stats, err
+}
+
+// getAllTables collects every enabled family, telling observe, unless it is
+// nil, how long each took, what was parsed and whether it failed. It only
+// fails if no family at all could be collected. trace may be nil.
+func (c *collector) getAllTables(trace *scrapeTrace, observe func(family iptables.Family, duration time.Duration, stats iptables.ParseStats, err error)) (map[iptables.Family]iptables.Tables, error) {
+	result := make(map[iptables.Family]iptables.Tables)
+	var firstErr error
+	for _, family := range c.ipFamilies {
+		start := time.Now()
+		tables, stats, err := c.getTables(family, trace)
+		if observe != nil {
+			observe(family, time.Since(start), stats, err)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		result[family] = tables
+	}
+	if len(result) == 0 {
+		return nil, firstErr
+	}
+	return result, nil
 }
 
 func (c *collector) Describe(descChan chan<- *prometheus.Desc) {
 	descChan <- scrapeDurationDesc
 	descChan <- scrapeSuccessDesc
+	descChan <- exporterSeriesDesc
+	descChan <- familyAvailableDesc
+	descChan <- familyTimeoutsDesc
+	descChan <- collectorDurationDesc
+	descChan <- collectorSuccessDesc
+	descChan <- chainsDesc
 	descChan <- defaultBytesDesc
 	descChan <- defaultPacketsDesc
-	descChan <- ruleBytesDesc
-	descChan <- rulePacketsDesc
+	if c.cache != nil {
+		descChan <- cacheAgeDesc
+		descChan <- lastScrapeDesc
+		descChan <- cacheHitsDesc
+		descChan <- cacheMissesDesc
+		descChan <- cacheRulesDesc
+		descChan <- cacheSizeDesc
+	}
+	if c.policiesOnly {
+		return
+	}
+	descChan <- undefinedReferencesDesc
+	descChan <- rulesNotCapturedDesc
+	descChan <- rulesExportedDesc
+	descChan <- c.ruleBytesDesc
+	descChan <- c.rulePacketsDesc
+	descChan <- c.quotaDesc
+	descChan <- c.quotaRemainingDesc
+	descChan <- c.limitRateDesc
+	descChan <- c.limitBurstDesc
+	descChan <- c.scheduleDesc
+	descChan <- c.scheduleActiveDesc
+	if c.continuity != nil {
+		descChan <- c.continuousRuleBytesDesc
+		descChan <- c.continuousRulePacketsDesc
+	}
+	if c.lastActive != nil {
+		descChan <- c.lastActiveDesc
+	}
+	if c.setEntries {
+		descChan <- setEntriesDesc
+	}
+	if len(c.checks) > 0 {
+		descChan <- complianceCheckDesc
+	}
+	if len(c.worldOpenPorts) > 0 {
+		descChan <- worldOpenRulesDesc
+	}
+	if c.chainJumps {
+		descChan <- chainJumpsDesc
+	}
+	descChan <- firewallManagerDesc
+	descChan <- masqueradePacketsDesc
+	descChan <- masqueradeBytesDesc
+	if c.protocols {
+		descChan <- protocolPacketsDesc
+		descChan <- protocolBytesDesc
+	}
+	if c.logPrefixes {
+		descChan <- logPrefixPacketsDesc
+		descChan <- logPrefixBytesDesc
+	}
+	if len(c.savedRules) > 0 {
+		descChan <- savedDriftDesc
+		descChan <- savedReadableDesc
+	}
+	if len(c.baselines) > 0 {
+		descChan <- baselineDriftDesc
+	}
+	if c.csf {
+		descChan <- csfDenyEntriesDesc
+		descChan <- csfBlockedPacketsDesc
+		descChan <- csfBlockedBytesDesc
+	}
 }
 
 func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
+	countSeries(metricChan, func(metricChan chan<- prometheus.Metric) {
+		c.collect(metricChan, nil)
+	})
+}
+
+// collect implements Collect, recording what it does in trace unless it is
+// nil.
+func (c *collector) collect(metricChan chan<- prometheus.Metric, trace *scrapeTrace) {
 	start := time.Now()
-	tables, err := iptables.GetTables(c.capture)
+	families, cached, err := c.getCachedTables(trace, func(family iptables.Family, duration time.Duration, stats iptables.ParseStats, err error) {
+		collectorMetrics(metricChan, familyCollector(family), duration, err)
+		if err == nil && !c.policiesOnly {
+			metricChan <- prometheus.MustNewConstMetric(rulesNotCapturedDesc, prometheus.GaugeValue, float64(stats.RulesNotCaptured), string(family))
+		}
+	})
 	duration := time.Since(start)
-	if err == nil && len(tables) == 0 {
-		err = errors.New("no output from iptables-save; this is probably due to insufficient permissions")
+	if !cached {
+		c.health.record(err)
 	}
+	c.collectCache(metricChan)
 	metricChan <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds())
+	for _, family := range c.ipFamilies {
+		available := 0.0
+		if _, ok := families[family]; ok {
+			available = 1
+		}
+		metricChan <- prometheus.MustNewConstMetric(familyAvailableDesc, prometheus.GaugeValue, available, string(family))
+		metricChan <- prometheus.MustNewConstMetric(familyTimeoutsDesc, prometheus.CounterValue, float64(c.families.timedOut(family)), string(family))
+	}
 	if err != nil {
 		metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
 		log.Error(err)
 		return
 	}
 	metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
+	if !cached {
+		for _, o := range c.observers {
+			o.observe(start, families)
+		}
+	}
+
+	for family, tables := range families {
+		c.collectDefaults(metricChan, family, tables)
+	}
+	if c.policiesOnly {
+		return
+	}
+
+	if c.setEntries {
+		c.collectSetEntries(metricChan, families, trace)
+	}
+	c.collectCompliance(metricChan, families)
+	c.collectFirewallManagers(metricChan, families)
+
+	rules := make(map[iptables.Family]ruleCounter)
+	for family, tables := range families {
+		c.collectUndefinedReferences(metricChan, family, tables)
+		c.collectWorldOpen(metricChan, family, tables)
+		c.collectLogPrefixes(metricChan, family, tables)
+		c.collectProtocols(metricChan, family, tables)
+		c.collectMasquerade(metricChan, family, tables)
+		c.collectCSF(metricChan, family, tables)
+		c.collectSavedDrift(metricChan, family, tables)
+		c.collectBaselineDrift(metricChan, family, tables)
+		c.collectChainJumps(metricChan, family, tables)
+		rules[family] = c.countRules(tables, trace)
+	}
+	if c.mergeFamilies {
+		mergeFamilies(rules)
+	}
+	collectRulesExported(metricChan, rules)
+	now := time.Now()
+	for family, counters := range rules {
+		for key, ruleData := range counters {
+			labels := familyLabels(ruleData.labels, family)
+			seriesKey := string(family) + "\x00" + key
+			if c.continuity != nil {
+				metricChan <- prometheus.MustNewConstMetric(
+					c.continuousRulePacketsDesc,
+					prometheus.CounterValue,
+					c.continuity.adjust("packets\x00"+seriesKey, ruleData.packets, now),
+					labels...,
+				)
+				metricChan <- prometheus.MustNewConstMetric(
+					c.continuousRuleBytesDesc,
+					prometheus.CounterValue,
+					c.continuity.adjust("bytes\x00"+seriesKey, ruleData.bytes, now),
+					labels...,
+				)
+			}
+			if c.lastActive != nil {
+				if active, ok := c.lastActive.activity("active\x00"+seriesKey, ruleData.packets, now); ok {
+					metricChan <- prometheus.MustNewConstMetric(
+						c.lastActiveDesc,
+						prometheus.GaugeValue,
+						float64(active.UnixNano())/1e9,
+						labels...,
+					)
+				}
+			}
+			metricChan <- prometheus.MustNewConstMetric(
+				c.rulePacketsDesc,
+				prometheus.CounterValue,
+				ruleData.packets,
+				labels...,
+			)
+			metricChan <- prometheus.MustNewConstMetric(
+				c.ruleBytesDesc,
+				prometheus.CounterValue,
+				ruleData.bytes,
+				labels...,
+			)
+			if ruleData.hasQuota {
+				metricChan <- prometheus.MustNewConstMetric(
+					c.quotaDesc,
+					prometheus.GaugeValue,
+					ruleData.quota,
+					labels...,
+				)
+				metricChan <- prometheus.MustNewConstMetric(
+					c.quotaRemainingDesc,
+					prometheus.GaugeValue,
+					quotaRemaining(ruleData.quota, ruleData.quotaUsed),
+					labels...,
+				)
+			}
+			if ruleData.limit != nil {
+				limitLabels := rateLimitLabels(labels, *ruleData.limit)
+				metricChan <- prometheus.MustNewConstMetric(
+					c.limitRateDesc,
+					prometheus.GaugeValue,
+					ruleData.limit.Rate,
+					limitLabels...,
+				)
+				metricChan <- prometheus.MustNewConstMetric(
+					c.limitBurstDesc,
+					prometheus.GaugeValue,
+					ruleData.limit.Burst,
+					limitLabels...,
+				)
+			}
+			if ruleData.schedule != nil {
+				metricChan <- prometheus.MustNewConstMetric(
+					c.scheduleDesc,
+					prometheus.GaugeValue,
+					1,
+					scheduleLabels(labels, *ruleData.schedule)...,
+				)
+				active := 0.0
+				if ruleData.schedule.Active(now) {
+					active = 1
+				}
+				metricChan <- prometheus.MustNewConstMetric(
+					c.scheduleActiveDesc,
+					prometheus.GaugeValue,
+					active,
+					labels...,
+				)
+			}
+		}
+	}
+}
 
+// collectSetEntries exports the size of every IP set matched by rules.
+func (c *collector) collectSetEntries(metricChan chan<- prometheus.Metric, families map[iptables.Family]iptables.Tables, trace *scrapeTrace) {
+	referenced := referencedSets(families)
+	if len(referenced) == 0 {
+		return
+	}
+	start := time.Now()
+	sets, err := ipset.List()
+	timeExec("ipset", "", start)
+	trace.command("ipset", "", start, err)
+	if err != nil {
+		log.Errorf("Listing IP sets: %s", err)
+		return
+	}
+	for _, set := range sets {
+		if referenced[set.Name] && set.EntriesKnown {
+			metricChan <- prometheus.MustNewConstMetric(setEntriesDesc, prometheus.GaugeValue, float64(set.Entries), set.Name)
+		}
+	}
+}
+
+func (c *collector) collectUndefinedReferences(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
 	for tableName, table := range tables {
+		for _, ref := range table.UndefinedReferences() {
+			if c.filter != nil && !c.filter.Chain(tableName, ref.Target) {
+				// Defined, but not collected.
+				continue
+			}
+			metricChan <- prometheus.MustNewConstMetric(
+				undefinedReferencesDesc,
+				prometheus.GaugeValue,
+				float64(ref.Rules),
+				tableName,
+				ref.Chain,
+				ref.Target,
+				string(family),
+			)
+		}
+	}
+}
+
+func (c *collector) collectDefaults(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	for tableName, table := range tables {
+		metricChan <- prometheus.MustNewConstMetric(
+			chainsDesc,
+			prometheus.GaugeValue,
+			float64(len(table)),
+			tableName,
+			string(family),
+		)
 		for chainName, chain := range table {
 			metricChan <- prometheus.MustNewConstMetric(
 				defaultPacketsDesc,
@@ -123,6 +828,7 @@ func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
 				tableName,
 				chainName,
 				chain.Policy,
+				string(family),
 			)
 			metricChan <- prometheus.MustNewConstMetric(
 				defaultBytesDesc,
@@ -131,77 +837,634 @@ func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
 				tableName,
 				chainName,
 				chain.Policy,
+				string(family),
 			)
-			// Dedup rules if they have the same identifier
-			rulesCounters := make(ruleCounter)
+		}
+	}
+}
+
+// familyLabels inserts family after the table, chain and rule labels.
+func familyLabels(labels []string, family iptables.Family) []string {
+	result := make([]string, 0, len(labels)+1)
+	result = append(result, labels[:3]...)
+	result = append(result, string(family))
+	return append(result, labels[3:]...)
+}
+
+// ruleLabels returns the label values of rule without its ip_family, or
+// skip if a labeler asked for the rule to be left out.
+func (c *collector) ruleLabels(labelers []ruleLabeler, table, chain string, rule iptables.Rule) (labels []string, skip bool) {
+	ruleLabel := rule.Rule
+	if c.labelFromComment {
+		ruleLabel = rule.Spec().Comment
+		if ruleLabel == "" {
+			return nil, true
+		}
+	} else if c.ruleTemplate != nil {
+		rendered, err := renderRuleTemplate(c.ruleTemplate, table, chain, rule)
+		if err != nil {
+			log.Debugf("Rendering rule template for %q in chain %s[%s]: %s", rule.Text, chain, table, err)
+		} else {
+			ruleLabel = rendered
+		}
+	}
+	labels = []string{table, chain, sanitizeLabelValue(ruleLabel)}
+	for _, l := range labelers {
+		values, skip := l.labelValues(table, chain, rule)
+		if skip {
+			return nil, true
+		}
+		for _, v := range values {
+			labels = append(labels, sanitizeLabelValue(v))
+		}
+	}
+	return labels, false
+}
+
+// countRules sums up the counters of rules sharing the same identifier.
+func (c *collector) countRules(tables iptables.Tables, trace *scrapeTrace) ruleCounter {
+	labelers := make([]ruleLabeler, len(c.labelers))
+	for i, l := range c.labelers {
+		if tl, ok := l.(tablesLabeler); ok {
+			l = tl.forTables(tables)
+		}
+		labelers[i] = l
+	}
+	rulesCounters := make(ruleCounter)
+	for tableName, table := range tables {
+		for chainName, chain := range table {
 			for _, rule := range chain.Rules {
-				if _, ok := rulesCounters[rule.Rule]; ok {
-					log.Debugf("Merging counters for %s in chain %s[%s]", rule.Rule, chainName, tableName)
-					rulesCounters[rule.Rule].bytes += float64(rule.Bytes)
-					rulesCounters[rule.Rule].packets += float64(rule.Packets)
-				} else {
-					rulesCounters[rule.Rule] = &ruleValues{
-						bytes:   float64(rule.Bytes),
-						packets: float64(rule.Packets),
+				labels, skip := c.ruleLabels(labelers, tableName, chainName, rule)
+				if skip {
+					trace.ruleFiltered()
+					continue
+				}
+				series := [][]string{labels}
+				if c.multiportExpand {
+					series = series[:0]
+					for _, port := range ruleDPorts(rule.Spec()) {
+						series = append(series, append(labels[:len(labels):len(labels)], port))
 					}
 				}
-			}
-			for ruleName, ruleData := range rulesCounters {
-				metricChan <- prometheus.MustNewConstMetric(
-					rulePacketsDesc,
-					prometheus.CounterValue,
-					ruleData.packets,
-					tableName,
-					chainName,
-					ruleName,
-				)
-				metricChan <- prometheus.MustNewConstMetric(
-					ruleBytesDesc,
-					prometheus.CounterValue,
-					ruleData.bytes,
-					tableName,
-					chainName,
-					ruleName,
-				)
+				for _, seriesLabels := range series {
+					key := strings.Join(seriesLabels, "\x00")
+					values, ok := rulesCounters[key]
+					if ok {
+						log.Debugf("Merging counters for %s in chain %s[%s]", rule.Rule, chainName, tableName)
+					} else {
+						values = &ruleValues{labels: seriesLabels}
+						rulesCounters[key] = values
+					}
+					values.add(rule)
+				}
 			}
 		}
 	}
+	return rulesCounters
+}
+
+// mergeFamilies moves rules present in both IPv4 and IPv6 into a combined
+// anyFamily counter holding their sums.
+func mergeFamilies(rules map[iptables.Family]ruleCounter) {
+	v4, v6 := rules[iptables.IPv4], rules[iptables.IPv6]
+	if v4 == nil || v6 == nil {
+		return
+	}
+	merged := make(ruleCounter)
+	for key, v4Data := range v4 {
+		v6Data, ok := v6[key]
+		if !ok {
+			continue
+		}
+		merged[key] = &ruleValues{
+			labels:    v4Data.labels,
+			bytes:     v4Data.bytes + v6Data.bytes,
+			packets:   v4Data.packets + v6Data.packets,
+			quota:     v4Data.quota + v6Data.quota,
+			quotaUsed: v4Data.quotaUsed + v6Data.quotaUsed,
+			hasQuota:  v4Data.hasQuota || v6Data.hasQuota,
+			limit:     v4Data.limit,
+			schedule:  v4Data.schedule,
+		}
+		delete(v4, key)
+		delete(v6, key)
+	}
+	rules[anyFamily] = merged
 }
 
 func main() {
 	// Adapted from github.com/prometheus/node_exporter
 
 	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":9455").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		captureRE     = kingpin.Flag("iptables.capture-re", "Regular expression used to export as 'rule' label desired bits from iptables rule").Default(`.*`).String()
+		webFlag             = newWebFlags(kingpin.CommandLine)
+		captureRE           = kingpin.Flag("iptables.capture-re", "Regular expression used to export as 'rule' label desired bits from iptables rule").Default(`.*`).String()
+		rateLimitFlag       = newRateLimitFlags(kingpin.CommandLine)
+		mergeFamilies       = kingpin.Flag("iptables.merge-families", "Export rules that exist identically for IPv4 and IPv6 once, with ip_family=\"any\" and summed counters.").Bool()
+		ruleTemplateText    = kingpin.Flag("iptables.rule-template", "Go template rendering the 'rule' label from the parsed rule, e.g. '{{.Target}} {{.Proto}}/{{.DPort}}'. Overrides the text captured by --iptables.capture-re.").String()
+		configFile          = kingpin.Flag("config.file", "Path to a YAML configuration file.").String()
+		labelerFlag         = newLabelerFlags(kingpin.CommandLine)
+		setEntries          = kingpin.Flag("iptables.set-entries", "Export the number of entries of every IP set matched by rules, as reported by ipset.").Bool()
+		procPath            = kingpin.Flag("path.procfs", "procfs mountpoint.").Default("/proc").String()
+		netnsPath           = kingpin.Flag("path.netns", "Directory of the named network namespaces.").Default("/var/run/netns").String()
+		collectorFlag       = newCollectorFlags(kingpin.CommandLine)
+		stateFlag           = newStateFlags(kingpin.CommandLine)
+		counterContinuity   = kingpin.Flag("iptables.counter-continuity", "Also export iptables_rule_continuous_*_total, which keep increasing when a rule is removed and inserted again with the same labels.").Bool()
+		historyRetention    = kingpin.Flag("history.retention", "How long to keep collections in memory for /api/v1/history (0 disables the history).").Default("0").Duration()
+		changesInterval     = kingpin.Flag("changes.interval", "How often to check for ruleset changes in between scrapes (0 only checks on scrapes).").Default("0").Duration()
+		worldOpenPortValues = kingpin.Flag("iptables.world-open-ports", "Sensitive port to report ACCEPT rules open to any source for in iptables_world_open_rules. Can be repeated or comma-separated.").Strings()
+		policiesOnly        = kingpin.Flag("iptables.policies-only", "Only export chain policy counters and chain counts, skipping the rules entirely.").Bool()
+		tlsFlag             = newTLSFlags(kingpin.CommandLine)
+		ruleLastActive      = kingpin.Flag("iptables.rule-last-active", "Also export iptables_rule_last_active_timestamp_seconds, when the packet counter of each rule last increased. Persisted with --state.file.").Bool()
+		chainJumps          = kingpin.Flag("iptables.chain-jumps", "Export iptables_chain_jumps, the number of rules jumping from chain to chain with -j or -g.").Bool()
+		multiportExpand     = kingpin.Flag("iptables.multiport-expand", "Add a 'dport' label to the rule metrics, exporting rules matching several destination ports with -m multiport once per port, each with the counters of the whole rule.").Bool()
+		captureUnmatched    = kingpin.Flag("iptables.capture-unmatched", "What to do with rules --iptables.capture-re doesn't match: skip them, export them with their full text as rule label (full), or export them summed up as rule=\"_unmatched\" (bucket).").Default(unmatchedSkip).Enum(unmatchedSkip, unmatchedFull, unmatchedBucket)
+		logOutput           = kingpin.Flag("log.output", "Where to log to: stderr, syslog, or the systemd journal (journal), the latter with structured fields.").Default(logStderr).Enum(logStderr, logSyslog, logJournal)
+		cacheTTL            = kingpin.Flag("iptables.cache-ttl", "Serve scrapes arriving within this time of a collection from its results, e.g. for several Prometheus servers scraping the same exporter (0 disables the cache).").Default("0").Duration()
+		scrapeInterval      = kingpin.Flag("iptables.scrape-interval", "Collect the tables of the local host in the background at this interval and serve scrapes the last collection right away rather than running the save commands for each (0 collects on scrape).").Default("0").Duration()
+		savedRulesFlag      = newSavedRulesFlags(kingpin.CommandLine)
+		inputFileFlag       = newInputFileFlags(kingpin.CommandLine)
+		dumpFile            = kingpin.Flag("debug.dump-file", "File to write the internal state of the exporter to on SIGUSR1, instead of the log.").String()
+		baselineFlag        = newBaselineFlags(kingpin.CommandLine)
+		protocolCounters    = kingpin.Flag("iptables.protocol-counters", "Export the counters of the rules of every chain summed up per protocol matched with -p: tcp, udp, icmp, all or other.").Bool()
+		rateHistogramOn     = kingpin.Flag("iptables.rate-histogram", "Export a histogram of the packet rates of the rules between collections, without a series per rule.").Bool()
+		skipFilteredChains  = kingpin.Flag("iptables.skip-filtered-chains", "Skip the rules of chains that the rules filter of the configuration file leaves out whatever their rules are, e.g. table == \"nat\" && ..., while parsing. Saves CPU on large rulesets, but these rules are then missing from aggregates and compliance checks, too.").Bool()
+		compressionFlag     = newCompressionFlags(kingpin.CommandLine)
+		labelFromComment    = kingpin.Flag("iptables.label-from-comment", "Export only the rules with a comment, using the comment as 'rule' label. Rules sharing a comment are summed up.").Bool()
+		filterFlag          = newTableFilterFlags(kingpin.CommandLine)
+		saveTimeout         = kingpin.Flag("iptables.timeout", "Kill the save commands of an IP family running longer than this, e.g. waiting for the xtables lock, and fail its collection (0 waits forever).").Default("0").Duration()
+		onceFlag            = newOnceFlags(kingpin.CommandLine)
 	)
 
+	kingpin.Command("serve", "Run the exporter.").Default()
+	generateCmd := kingpin.Command("generate-config", "Inspect the system and print a starter configuration file.")
+	validateCmd := kingpin.Command("validate", "Apply a capture regular expression to the current ruleset and print the resulting rule labels as JSON.")
+	validateRE := validateCmd.Arg("regex", "Candidate for --iptables.capture-re.").Required().String()
+	parseCheckCmd := kingpin.Command("parse-check", "Parse an iptables-save dump strictly and report, as JSON, every line the exporter doesn't fully interpret.")
+	parseCheckFile := parseCheckCmd.Arg("file", "Output of iptables-save -c, read from stdin if not given.").String()
+	parseCheckMin := parseCheckCmd.Flag("min-coverage", "Exit with status 1 if the share of lines fully interpreted is lower.").Default("1").Float64()
+	healthcheckCmd := kingpin.Command("healthcheck", "Check the readiness of the exporter running with the same flags and exit with status 1 unless it is ready.")
+	healthcheckURLFlag := healthcheckCmd.Flag("url", "URL to check instead of /readyz at --web.listen-address.").String()
+	healthcheckTimeout := healthcheckCmd.Flag("timeout", "Time to wait for the answer.").Default("5s").Duration()
+
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("iptables_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	if err := setLogOutput(*logOutput); err != nil {
+		log.Fatalf("Setting up --log.output: %s", err)
+	}
+	level := newLogLevel(kingpin.CommandLine.GetFlag("log.level").Model().Value.String())
+	switch command {
+	case generateCmd.FullCommand():
+		generateConfig(os.Stdout, inspectSystem(*procPath))
+		return
+	case healthcheckCmd.FullCommand():
+		url := *healthcheckURLFlag
+		if url == "" {
+			var err error
+			useTLS := *tlsFlag.certFile != ""
+			if *tlsFlag.webConfigFile != "" {
+				useTLS, err = webConfigTLS(*tlsFlag.webConfigFile)
+				if err != nil {
+					log.Fatalf("Reading --web.config.file: %s", err)
+				}
+			}
+			url, err = healthcheckURL(*webFlag.listenAddress, useTLS)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := healthcheck(url, *healthcheckTimeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Not ready: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	case parseCheckCmd.FullCommand():
+		result, err := runParseCheck(*parseCheckFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		e.Encode(result)
+		if result.Coverage < *parseCheckMin {
+			os.Exit(1)
+		}
+		return
+	}
 
 	log.Infoln("Starting iptables_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	c := NewCollector(*captureRE)
-	prometheus.MustRegister(&c)
-
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-			<head><title>iptables exporter</title></head>
-			<body>
-			<h1>iptables exporter</h1>
-			<p><a href="` + *metricsPath + `">Metrics</a></p>
-			</body>
-			</html>`))
+	allowedNets, err := parseCIDRs(*webFlag.allowCIDR)
+	if err != nil {
+		log.Fatalf("Invalid --web.allow-cidr: %s", err)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Loading configuration: %s", err)
+	}
+
+	var labelers []ruleLabeler
+	exprLabels, err := newExprLabeler(cfg.Rules)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
+	if exprLabels.enabled() {
+		labelers = append(labelers, exprLabels)
+	}
+	var chains func(table, chain string) bool
+	if *skipFilteredChains {
+		chains = exprLabels.chains()
+	}
+	filter, err := filterFlag.filter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	flagLabelers, err := labelerFlag.labelers(cfg.Marks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	labelers = append(labelers, flagLabelers...)
+
+	captures, err := newChainCaptures(cfg.Captures)
+	if err != nil {
+		log.Fatalf("Invalid captures in %s: %s", *configFile, err)
+	}
+	capture, err := regexp.Compile(*captureRE)
+	if err != nil {
+		log.Fatalf("Invalid --iptables.capture-re: %s", err)
+	}
+	groups, err := newCaptureGroupLabeler(capture, captures)
+	if err != nil {
+		log.Fatalf("Invalid capture regexp: %s", err)
+	}
+	if groups != nil {
+		// Named groups identify rules, so their labels come first.
+		labelers = append([]ruleLabeler{groups}, labelers...)
+	}
+
+	ruleTemplate, err := parseRuleTemplate(*ruleTemplateText)
+	if err != nil {
+		log.Fatalf("Invalid --iptables.rule-template: %s", err)
+	}
+	if ruleTemplate != nil && *labelFromComment {
+		log.Fatalf("--iptables.rule-template and --iptables.label-from-comment both set the rule label, use one of them")
+	}
+
+	state, err := loadCounterState(*stateFlag.file)
+	if err != nil {
+		log.Fatalf("Loading counter state: %s", err)
+	}
+	var continuity, lastActive *counterState
+	if *counterContinuity {
+		continuity = state
+	}
+	if *ruleLastActive {
+		lastActive = state
+	}
+	if *stateFlag.file != "" && !*onceFlag.enabled {
+		go persistCounterState(state, *stateFlag.saveInterval, *stateFlag.retention)
+	}
+
+	var observers []collectionObserver
+	var hist *history
+	if *historyRetention > 0 {
+		hist = newHistory(*historyRetention)
+		observers = append(observers, hist)
+	}
+	var rates *rateHistogram
+	if *rateHistogramOn {
+		rates = newRateHistogram()
+		observers = append(observers, rates)
+	}
+	listeners := []changeListener{flushDetector{}}
+	for _, wc := range cfg.Webhooks {
+		w, err := newWebhook(wc)
+		if err != nil {
+			log.Fatalf("Invalid webhook in %s: %s", *configFile, err)
+		}
+		listeners = append(listeners, w)
+	}
+	observers = append(observers, newChangeDetector(listeners))
+
+	checks, err := newComplianceChecks(cfg.Compliance)
+	if err != nil {
+		log.Fatalf("Invalid compliance checks in %s: %s", *configFile, err)
+	}
+
+	worldOpenPorts, err := parseWorldOpenPorts(*worldOpenPortValues)
+	if err != nil {
+		log.Fatalf("Invalid --iptables.world-open-ports: %s", err)
+	}
+
+	ipFamilies := collectorFlag.families()
+	if len(ipFamilies) == 0 {
+		log.Fatal("At least one of --collector.iptables and --collector.ip6tables is required")
+	}
+
+	savedRules := savedRulesFlag.files()
+	baselines := baselineFlag.files()
+
+	health := newCollectionHealth(*webFlag.readyThreshold)
+	var localTables tablesSource = localSource{}
+	if *collectorFlag.backend == backendNetlink {
+		localTables = &kernelSource{}
+	}
+	if paths := inputFileFlag.files(); len(paths) > 0 {
+		if paths[iptables.IPv4] == "-" && paths[iptables.IPv6] == "-" {
+			log.Fatal("Only one of --iptables.input-file and --iptables.input-file-v6 can read stdin")
+		}
+		// Only the families dumps are given for are collected.
+		inputFiles := make(map[iptables.Family]string)
+		var families []iptables.Family
+		for _, family := range ipFamilies {
+			if paths[family] != "" {
+				inputFiles[family] = paths[family]
+				families = append(families, family)
+			}
+		}
+		if len(families) == 0 {
+			log.Fatal("No input file is given for the enabled IP families")
+		}
+		ipFamilies = families
+		localTables, err = newFileSource(inputFiles)
+		if err != nil {
+			log.Fatalf("Reading the input file from stdin: %s", err)
+		}
+	}
+	c, err := NewCollector(Options{
+		Source:           localTables,
+		Families:         ipFamilies,
+		CaptureRE:        *captureRE,
+		Captures:         captures,
+		Unmatched:        *captureUnmatched,
+		RuleTemplate:     ruleTemplate,
+		LabelFromComment: *labelFromComment,
+		Labelers:         labelers,
+		PoliciesOnly:     *policiesOnly,
+		Chains:           chains,
+		Filter:           filter,
+		MergeFamilies:    *mergeFamilies,
+		SetEntries:       *setEntries,
+		Continuity:       continuity,
+		LastActive:       lastActive,
+		Checks:           checks,
+		WorldOpenPorts:   worldOpenPorts,
+		LogPrefixes:      *labelerFlag.logPrefix,
+		Protocols:        *protocolCounters,
+		ChainJumps:       *chainJumps,
+		MultiportExpand:  *multiportExpand,
+		CacheTTL:         *cacheTTL,
+		Timeout:          *saveTimeout,
+		ScrapeInterval:   *scrapeInterval,
+		CSF:              *labelerFlag.csf,
+		SavedRules:       savedRules,
+		Baselines:        baselines,
+		Health:           health,
+		Observers:        observers,
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if command == validateCmd.FullCommand() {
+		result, err := c.validateCapture(*validateRE)
+		if err != nil {
+			log.Fatal(err)
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		e.Encode(result)
+		return
+	}
+	collectorSet := newCollectorSet()
+	local := collectorSet.familyRegisterer(ipFamilies)
+	if len(cfg.Targets) > 0 {
+		local = prometheus.WrapRegistererWith(prometheus.Labels{"instance": localTarget}, local)
+	}
+	local.MustRegister(&c)
+	if rates != nil {
+		local.MustRegister(rates)
+	}
+	probeTargets := []probeTarget{{name: localTarget, kind: "local", collector: &c}}
+	// remoteCollector collects another host or namespace like the local
+	// one, but without the features keeping state on the local host.
+	remoteCollector := func(name string, source tablesSource) *collector {
+		rc, err := NewCollector(Options{
+			Families:         ipFamilies,
+			CaptureRE:        *captureRE,
+			Captures:         captures,
+			Unmatched:        *captureUnmatched,
+			RuleTemplate:     ruleTemplate,
+			LabelFromComment: *labelFromComment,
+			Labelers:         labelers,
+			PoliciesOnly:     *policiesOnly,
+			Chains:           chains,
+			Filter:           filter,
+			MergeFamilies:    *mergeFamilies,
+			Checks:           checks,
+			WorldOpenPorts:   worldOpenPorts,
+			LogPrefixes:      *labelerFlag.logPrefix,
+			Protocols:        *protocolCounters,
+			ChainJumps:       *chainJumps,
+			MultiportExpand:  *multiportExpand,
+			CacheTTL:         *cacheTTL,
+			Timeout:          *saveTimeout,
+			CSF:              *labelerFlag.csf,
+			Source:           source,
+			Target:           name,
+		})
+		if err != nil {
+			// The labels are the local collector's, checked already.
+			log.Fatalf("Collecting %s: %s", name, err)
+		}
+		return &rc
+	}
+	for _, t := range cfg.Targets {
+		source, err := newTargetSource(t)
+		if err != nil {
+			log.Fatalf("Invalid target %s in %s: %s", t.Name, *configFile, err)
+		}
+		tc := remoteCollector(t.Name, source)
+		prometheus.WrapRegistererWith(prometheus.Labels{"instance": t.Name}, collectorSet.familyRegisterer(ipFamilies)).MustRegister(tc)
+		probeTargets = append(probeTargets, probeTarget{name: t.Name, kind: t.kind(), collector: tc})
+	}
+	if *collectorFlag.lxc && *collectorFlag.netns {
+		log.Fatal("--collector.netns collects the containers of --collector.lxc too")
+	}
+	var discovered []targetDiscoverer
+	if *collectorFlag.lxc {
+		containers := newLXCContainers(*procPath, remoteCollector)
+		collectorSet.addGatherer("lxc", containers)
+		discovered = append(discovered, containers)
+	}
+	if *collectorFlag.netns {
+		namespaces := newNetworkNamespaces(*procPath, *netnsPath, remoteCollector)
+		collectorSet.addGatherer("netns", namespaces)
+		discovered = append(discovered, namespaces)
+	}
+	if len(cfg.Targets) > 0 || len(discovered) > 0 {
+		collectorSet.familyRegisterer(ipFamilies).MustRegister(targetStatsCollector{probeTargets, discovered})
+	}
+	if *changesInterval > 0 && !*onceFlag.enabled {
+		go watchChanges(&c, *changesInterval)
+	}
+	if *scrapeInterval > 0 && !*onceFlag.enabled {
+		go refreshTables(&c)
+	}
+	go dumpStateOnSignal(*dumpFile, probeTargets)
+	go level.toggleOnSignal()
+	if *collectorFlag.nflog {
+		collectorSet.registerer("nflog").MustRegister(instrumentedCollector{"nflog", nflogCollector{procPath: *procPath}})
+	}
+	if *collectorFlag.conntrack {
+		collectorSet.registerer("conntrack").MustRegister(instrumentedCollector{"conntrack", conntrackCollector{procPath: *procPath}})
+	}
+	if *collectorFlag.ipset {
+		collectorSet.registerer("ipset").MustRegister(instrumentedCollector{"ipset", ipsetCollector{}})
+	}
+	if *collectorFlag.ebtables {
+		collectorSet.registerer("ebtables").MustRegister(instrumentedCollector{"ebtables", newToolCollector(iptables.Ebtables, *saveTimeout)})
+	}
+	if *collectorFlag.arptables {
+		collectorSet.registerer("arptables").MustRegister(instrumentedCollector{"arptables", newToolCollector(iptables.Arptables, *saveTimeout)})
+	}
+	if *collectorFlag.nftables {
+		collectorSet.registerer("nftables").MustRegister(instrumentedCollector{"nftables", newNftablesCollector(*saveTimeout, *labelerFlag.openwrt)})
+	}
+	if *onceFlag.enabled {
+		if err := writeOnce(collectorSet, *onceFlag.output); err != nil {
+			log.Fatalf("Writing the metrics: %s", err)
+		}
+		if err := state.persist(*stateFlag.retention); err != nil {
+			log.Fatalf("Saving counter state: %s", err)
+		}
+		return
+	}
+
+	var collectors []string
+	for name, enabled := range map[string]bool{
+		"iptables":           *collectorFlag.iptables,
+		"ip6tables":          *collectorFlag.ip6tables,
+		"policies_only":      *policiesOnly,
+		"set_entries":        *setEntries,
+		"nflog":              *collectorFlag.nflog,
+		"conntrack":          *collectorFlag.conntrack,
+		"ipset":              *collectorFlag.ipset,
+		"ebtables":           *collectorFlag.ebtables,
+		"arptables":          *collectorFlag.arptables,
+		"nftables":           *collectorFlag.nftables,
+		"lxc":                *collectorFlag.lxc,
+		"netns":              *collectorFlag.netns,
+		"counter_continuity": continuity != nil,
+		"last_active":        lastActive != nil,
+		"history":            hist != nil,
+		"compliance":         len(checks) > 0,
+		"world_open":         len(worldOpenPorts) > 0,
+		"webhooks":           len(cfg.Webhooks) > 0,
+	} {
+		if enabled {
+			collectors = append(collectors, name)
+		}
+	}
+	sort.Strings(collectors)
 
-	log.Infoln("Listening on", *listenAddress)
-	err := http.ListenAndServe(*listenAddress, nil)
+	limiter := rateLimitFlag.limiter()
+	inFlight := newInFlightLimiter(*webFlag.maxRequests)
+	compress, err := compressionFlag.compression()
 	if err != nil {
 		log.Fatal(err)
 	}
+	http.Handle(*webFlag.metricsPath, limitRate(limiter, instrumentHandler(inFlight, "metrics", compress.handler("metrics", collectorSet.handler()))))
+	if hist != nil {
+		http.Handle("/api/v1/history", hist)
+	}
+	if *webFlag.adminTokenFile != "" {
+		token, err := readAdminToken(*webFlag.adminTokenFile)
+		if err != nil {
+			log.Fatalf("Reading --web.admin-token-file: %s", err)
+		}
+		http.Handle("/-/validate", requireToken(token, validateHandler(&c)))
+		http.Handle("/api/v1/save", requireToken(token, http.HandlerFunc(saveHandler)))
+		http.Handle("/-/loglevel", requireToken(token, level))
+		http.Handle("/api/v1/diff", requireToken(token, diffHandler(&c)))
+		http.Handle("/debug/scrape", requireToken(token, limitRate(limiter, debugScrapeHandler(&c))))
+		http.Handle("/api/v1/graph", requireToken(token, limitRate(limiter, graphHandler(&c))))
+	}
+	http.Handle("/probe", limitRate(limiter, instrumentHandler(inFlight, "probe", compress.handler("probe", probeHandler(probeTargets)))))
+	http.HandleFunc("/sd", sdHandler(probeTargets))
+	http.HandleFunc("/version", versionHandler(collectors))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler(health))
+	if *webFlag.landingPage {
+		links := []landingLink{
+			{*webFlag.metricsPath, "Metrics", "metrics of the local host"},
+			{"/probe", "Probe", "metrics of a remote target, /probe?target=<name>"},
+			{"/sd", "Service discovery", "remote targets for Prometheus' HTTP service discovery"},
+			{"/version", "Version", "build information and backends"},
+			{"/healthz", "Health", ""},
+			{"/readyz", "Readiness", ""},
+		}
+		if hist != nil {
+			links = append(links, landingLink{"/api/v1/history", "History", "recent counter values"})
+		}
+		if *webFlag.adminTokenFile != "" {
+			links = append(links,
+				landingLink{"/-/validate", "Validate", "apply a capture expression, requires the admin token"},
+				landingLink{"/-/loglevel", "Log level", "get or set the log level, requires the admin token"},
+				landingLink{"/api/v1/diff", "Diff", "difference between the running ruleset and a baseline, requires the admin token"},
+				landingLink{"/api/v1/save", "Save", "raw save output for agent targets, requires the admin token"},
+				landingLink{"/debug/scrape", "Scrape breakdown", "run time and series of one collection, requires the admin token"},
+				landingLink{"/api/v1/graph", "Chain graph", "jumps between chains with their packet counts, ?format=dot for Graphviz, requires the admin token"},
+			)
+		}
+		http.Handle("/", landingHandler(newLandingPage(collectors, links)))
+	}
+
+	server := &http.Server{
+		Addr:    *webFlag.listenAddress,
+		Handler: allowCIDRs(allowedNets, http.DefaultServeMux),
+	}
+	// Stop serving on SIGINT or SIGTERM, letting in-flight scrapes finish,
+	// so the counter state saved last is that of their collections.
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		log.Infof("Received %s, shutting down", <-signals)
+		ctx, cancel := context.WithTimeout(context.Background(), *webFlag.shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("Shutting down: %s", err)
+		}
+		close(stopped)
+	}()
+	log.Infoln("Listening on", *webFlag.listenAddress)
+	if *tlsFlag.webConfigFile != "" {
+		if tlsFlag.certFiles() {
+			log.Fatalf("--web.config.file and --web.tls-cert-file both configure TLS, use one of them")
+		}
+		if err := web.Validate(*tlsFlag.webConfigFile); err != nil {
+			log.Fatalf("Invalid --web.config.file: %s", err)
+		}
+		err = web.ListenAndServe(server, *tlsFlag.webConfigFile, kitLogger{})
+	} else if tlsFlag.certFiles() {
+		certs, err := newCertReloader(*tlsFlag.certFile, *tlsFlag.keyFile)
+		if err != nil {
+			log.Fatalf("Loading TLS certificate: %s", err)
+		}
+		go certs.watch(*tlsFlag.reloadInterval)
+		server.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-stopped
+	if err := state.persist(*stateFlag.retention); err != nil {
+		log.Fatalf("Saving counter state: %s", err)
+	}
 }
@@ -0,0 +1,123 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// icmpv6Types names the ICMPv6 types ip6tables-save prints as numbers, with
+// the names ip6tables accepts.
+var icmpv6Types = map[string]string{
+	"1":   "destination-unreachable",
+	"2":   "packet-too-big",
+	"3":   "time-exceeded",
+	"4":   "parameter-problem",
+	"128": "echo-request",
+	"129": "echo-reply",
+	"130": "mld-listener-query",
+	"131": "mld-listener-report",
+	"132": "mld-listener-done",
+	"133": "router-solicitation",
+	"134": "router-advertisement",
+	"135": "neighbour-solicitation",
+	"136": "neighbour-advertisement",
+	"137": "redirect",
+	"143": "mld2-listener-report",
+}
+
+// fragFlags are the flags of -m frag without values, in the order their
+// names are joined in the frag label.
+var fragFlags = []struct{ flag, name string }{
+	{"--fragfirst", "first"},
+	{"--fragmore", "more"},
+	{"--fraglast", "last"},
+	{"--fragres", "res"},
+}
+
+// ipv6Labeler exports the IPv6-only matches of a rule: the ICMPv6 type of
+// -m icmp6 as "icmpv6_type", the hop limit of -m hl as "hop_limit", what
+// -m frag matches of fragment headers as "frag" and the routing header type
+// of -m rt as "rt_type". IPv4 rules have none of them.
+type ipv6Labeler struct{}
+
+func (ipv6Labeler) labelNames() []string {
+	return []string{"icmpv6_type", "hop_limit", "frag", "rt_type"}
+}
+
+func (ipv6Labeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	values := make([]string, 4)
+	if option, ok := spec.MatchOption("icmp6", "--icmpv6-type"); ok {
+		values[0] = icmpv6TypeName(option)
+	}
+	values[1] = hopLimit(spec)
+	values[2] = fragMatch(spec)
+	if option, ok := spec.MatchOption("rt", "--rt-type"); ok {
+		values[3] = option.Value()
+	}
+	return values, false
+}
+
+// icmpv6TypeName returns the name of the ICMPv6 type matched, keeping codes
+// and unknown types as written, e.g. "destination-unreachable/4".
+func icmpv6TypeName(option iptables.Option) string {
+	value := strings.Join(option.Values, " ")
+	parts := strings.SplitN(value, "/", 2)
+	if name, ok := icmpv6Types[parts[0]]; ok {
+		parts[0] = name
+	}
+	value = strings.Join(parts, "/")
+	if option.Negated {
+		return "!" + value
+	}
+	return value
+}
+
+// hopLimit returns the hop limit matched by -m hl: the limit for --hl-eq,
+// prefixed with < for --hl-lt and > for --hl-gt.
+func hopLimit(spec iptables.RuleSpec) string {
+	for _, op := range []struct{ flag, prefix string }{
+		{"--hl-eq", ""},
+		{"--hl-lt", "<"},
+		{"--hl-gt", ">"},
+	} {
+		if option, ok := spec.MatchOption("hl", op.flag); ok {
+			value := op.prefix + strings.Join(option.Values, " ")
+			if option.Negated {
+				return "!" + value
+			}
+			return value
+		}
+	}
+	return ""
+}
+
+// fragMatch returns the fragment header fields -m frag matches, joined by
+// commas, e.g. "id=0:100,first".
+func fragMatch(spec iptables.RuleSpec) string {
+	var parts []string
+	if option, ok := spec.MatchOption("frag", "--fragid"); ok {
+		parts = append(parts, "id="+option.Value())
+	}
+	for _, f := range fragFlags {
+		if _, ok := spec.MatchOption("frag", f.flag); ok {
+			parts = append(parts, f.name)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// labelerFlags are the flags adding labels to the rule metrics.
+type labelerFlags struct {
+	commentLabels, plugins                                          *[]string
+	servicesFile, dedupKey                                          *string
+	mark, cgroup, owner, service, set, hook, ctstate, reject, proxy *bool
+	logPrefix, verdictKind, physdev, ipv6, dscp                     *bool
+	openwrt, vyos, shorewall, csf                                   *bool
+}
+
+func newLabelerFlags(app *kingpin.Application) labelerFlags {
+	return labelerFlags{
+		commentLabels: app.Flag("iptables.comment-labels", "Key of key=value pairs in rule comments to export as label. Can be repeated or comma-separated.").Strings(),
+		mark:          app.Flag("iptables.mark-label", "Export the firewall mark matched or set by rules as 'mark' label, named according to the marks section of the configuration file.").Bool(),
+		cgroup:        app.Flag("iptables.cgroup-unit-label", "Export the systemd unit owning the cgroup matched by -m cgroup --path as 'unit' label.").Bool(),
+		owner:         app.Flag("iptables.owner-labels", "Export the user and group matched by -m owner as 'owner_user' and 'owner_group' labels, resolving IDs to names.").Bool(),
+		service:       app.Flag("iptables.service-label", "Export the name of the port matched by rules as 'service' label.").Bool(),
+		servicesFile:  app.Flag("iptables.services-file", "File mapping ports to service names for --iptables.service-label.").Default("/etc/services").String(),
+		set:           app.Flag("iptables.set-label", "Export the IP set matched by -m set as 'set' label.").Bool(),
+		hook:          app.Flag("iptables.hook-label", "Export the netfilter hook of the rule's chain as 'hook' label.").Bool(),
+		ctstate:       app.Flag("iptables.ctstate-label", "Export the connection tracking states matched by -m conntrack --ctstate or -m state --state as 'ctstate' label.").Bool(),
+		reject:        app.Flag("iptables.reject-label", "Export the --reject-with type of REJECT rules as 'reject_with' label.").Bool(),
+		proxy:         app.Flag("iptables.proxy-port-label", "Export the --on-port of TPROXY rules and the --to-ports of REDIRECT rules as 'proxy_port' label.").Bool(),
+		logPrefix:     app.Flag("iptables.log-prefix-label", "Export the prefix of LOG and NFLOG rules as 'log_prefix' label, and iptables_log_prefix_*_total summed up per prefix.").Bool(),
+		verdictKind:   app.Flag("iptables.verdict-kind-label", "Export whether rules continue in their target with -j or -g as 'verdict_kind' label.").Bool(),
+		physdev:       app.Flag("iptables.physdev-labels", "Export the bridge ports matched by -m physdev as 'physdev_in' and 'physdev_out' labels.").Bool(),
+		ipv6:          app.Flag("iptables.ipv6-labels", "Export the IPv6-only matches of rules as 'icmpv6_type', 'hop_limit', 'frag' and 'rt_type' labels.").Bool(),
+		dscp:          app.Flag("iptables.dscp-labels", "Export the DSCP class rules match or set with the dscp and tos matches or the DSCP and TOS targets as a 'dscp' label.").Bool(),
+		openwrt:       app.Flag("iptables.openwrt-labels", "Export the zone of chains generated by OpenWrt's firewall as 'zone' and the configuration section of rules as 'section' label.").Bool(),
+		vyos:          app.Flag("iptables.vyos-labels", "Export the firewall name, rule number and description of rules generated by VyOS as 'firewall', 'rule_number' and 'description' labels.").Bool(),
+		shorewall:     app.Flag("iptables.shorewall-labels", "Export 'managed_by=\"shorewall\"' for chains generated by Shorewall and the zones of its zone-pair chains as 'src_zone' and 'dst_zone' labels.").Bool(),
+		csf:           app.Flag("iptables.csf", "Export the lists and directions of ConfigServer Firewall chains as 'csf_list' and 'csf_direction' labels, and the size and traffic of its deny lists.").Bool(),
+		dedupKey:      app.Flag("iptables.dedup-key", "Key rules sharing a series are merged by: label merges rules with the same labels, rule and hash rules with the same text, comment rules with the same comment. Other keys than label are exported as 'rule_key' label.").Default(dedupLabel).Enum(dedupLabel, dedupRule, dedupComment, dedupHash),
+		plugins:       app.Flag("plugin.path", "Go plugin adding labels to or skipping rules. Can be repeated.").Strings(),
+	}
+}
+
+// labelers returns the labelers the flags enable, in the order their labels
+// are exported. marks are those of the configuration file.
+func (f labelerFlags) labelers(marks map[string]string) ([]ruleLabeler, error) {
+	var labelers []ruleLabeler
+	if len(*f.commentLabels) > 0 {
+		l, err := newCommentLabeler(*f.commentLabels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --iptables.comment-labels: %s", err)
+		}
+		labelers = append(labelers, l)
+	}
+	if *f.mark {
+		l, err := newMarkLabeler(marks)
+		if err != nil {
+			return nil, fmt.Errorf("invalid configuration: marks: %s", err)
+		}
+		labelers = append(labelers, l)
+	}
+	if *f.cgroup {
+		labelers = append(labelers, cgroupLabeler{})
+	}
+	if *f.owner {
+		labelers = append(labelers, newOwnerLabeler())
+	}
+	if *f.service {
+		l, err := newServiceLabeler(*f.servicesFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading services: %s", err)
+		}
+		labelers = append(labelers, l)
+	}
+	for _, l := range []struct {
+		enabled bool
+		labeler ruleLabeler
+	}{
+		{*f.set, setLabeler{}},
+		{*f.hook, hookLabeler{}},
+		{*f.ctstate, ctstateLabeler{}},
+		{*f.reject, rejectLabeler{}},
+		{*f.proxy, proxyLabeler{}},
+		{*f.logPrefix, logPrefixLabeler{}},
+		{*f.verdictKind, verdictKindLabeler{}},
+		{*f.physdev, physdevLabeler{}},
+		{*f.ipv6, ipv6Labeler{}},
+		{*f.dscp, dscpLabeler{}},
+		{*f.openwrt, openwrtLabeler{}},
+		{*f.vyos, vyosLabeler{}},
+		{*f.shorewall, shorewallLabeler{}},
+		{*f.csf, csfLabeler{}},
+		{*f.dedupKey != dedupLabel, dedupKeyLabeler{key: *f.dedupKey}},
+	} {
+		if l.enabled {
+			labelers = append(labelers, l.labeler)
+		}
+	}
+	for _, path := range *f.plugins {
+		l, err := loadPlugin(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading plugin: %s", err)
+		}
+		log.Infof("Loaded plugin %s adding labels %v", path, l.labelNames())
+		labelers = append(labelers, l)
+	}
+	return labelers, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// labelerCase is a rule of the filter table and the label values a
+// labeler is expected to return for it.
+type labelerCase struct {
+	chain  string
+	text   string
+	values []string
+	skip   bool
+}
+
+func testLabeler(t *testing.T, l ruleLabeler, cases []labelerCase) {
+	t.Helper()
+	for _, tc := range cases {
+		chain := tc.chain
+		if chain == "" {
+			chain = "INPUT"
+		}
+		values, skip := l.labelValues("filter", chain, iptables.Rule{Text: tc.text})
+		if skip != tc.skip {
+			t.Fatalf("%T %s: expected skip %v, got %v", l, tc.text, tc.skip, skip)
+		}
+		if skip {
+			continue
+		}
+		if len(values) != len(l.labelNames()) {
+			t.Fatalf("%T %s: %d values for %d labels", l, tc.text, len(values), len(l.labelNames()))
+		}
+		if mismatch := deep.Equal(tc.values, values); mismatch != nil {
+			t.Fatalf("%T %s: %+v", l, tc.text, mismatch)
+		}
+	}
+}
+
+func TestCheckLabelNames(t *testing.T) {
+	for _, tc := range []struct {
+		names []string
+		ok    bool
+	}{
+		{names: []string{"owner", "ticket"}, ok: true},
+		{names: nil, ok: true},
+		{names: []string{"owner", "owner"}},
+		{names: []string{"instance"}},
+		{names: []string{"not-valid"}},
+	} {
+		if err := checkLabelNames(tc.names); (err == nil) != tc.ok {
+			t.Fatalf("%q: expected ok %v, got %v", tc.names, tc.ok, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// landingPathFlags are the flags naming files and directories shown on the
+// landing page when set.
+var landingPathFlags = []string{
+	"config.file",
+	"plugin.path",
+	"path.procfs",
+	"state.file",
+	"iptables.services-file",
+	"iptables.saved-rules",
+	"iptables.saved-rules-v6",
+	"iptables.baseline",
+	"iptables.baseline-v6",
+	"debug.dump-file",
+}
+
+// landingPage is the content of the page served at /, laid out like the
+// exporter-toolkit's landing page.
+type landingPage struct {
+	Version    string
+	Collectors []string
+	Paths      []landingSetting
+	Links      []landingLink
+}
+
+type landingSetting struct {
+	Name, Value string
+}
+
+type landingLink struct {
+	Address, Text, Description string
+}
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>iptables exporter</title>
+<style>
+body { font-family: -apple-system, sans-serif; margin: 0; }
+header { background: #e6522c; color: #fff; padding: 1em 2em; }
+header h1 { margin: 0; }
+main { padding: 0 2em; }
+td { padding-right: 1em; }
+</style>
+</head>
+<body>
+<header><h1>iptables exporter</h1><div>Prometheus exporter for iptables counters</div></header>
+<main>
+<h2>Version</h2>
+<pre>{{.Version}}</pre>
+<h2>Endpoints</h2>
+<ul>
+{{- range .Links}}
+<li><a href="{{.Address}}">{{.Text}}</a>{{with .Description}}: {{.}}{{end}}</li>
+{{- end}}
+</ul>
+<h2>Collectors</h2>
+<p>{{range $i, $c := .Collectors}}{{if $i}}, {{end}}{{$c}}{{else}}none{{end}}</p>
+{{- with .Paths}}
+<h2>Paths</h2>
+<table>
+{{- range .}}
+<tr><td><code>--{{.Name}}</code></td><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+func newLandingPage(collectors []string, links []landingLink) *landingPage {
+	page := &landingPage{
+		Version:    version.Print("iptables_exporter"),
+		Collectors: collectors,
+		Links:      links,
+	}
+	for _, name := range landingPathFlags {
+		flag := kingpin.CommandLine.GetFlag(name)
+		if flag == nil {
+			continue
+		}
+		if value := flag.Model().Value.String(); value != "" && value != "[]" {
+			page.Paths = append(page.Paths, landingSetting{name, value})
+		}
+	}
+	return page
+}
+
+// landingHandler serves the landing page at / and 404 for every other path
+// nothing else is registered for.
+func landingHandler(page *landingPage) http.HandlerFunc {
+	var buf bytes.Buffer
+	if err := landingTemplate.Execute(&buf, page); err != nil {
+		log.Fatalf("Rendering the landing page: %s", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
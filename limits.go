@@ -0,0 +1,42 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ruleRateLimit returns the rate configured by the limit or hashlimit match
+// of rule.
+func ruleRateLimit(rule iptables.Rule) (iptables.RateLimit, bool) {
+	if !strings.Contains(rule.Text, "limit") {
+		return iptables.RateLimit{}, false
+	}
+	return rule.Spec().RateLimit()
+}
+
+// rateLimitLabels appends the limit_match and limit_unit label values of limit
+// to labels.
+func rateLimitLabels(labels []string, limit iptables.RateLimit) []string {
+	unit := "packets"
+	if limit.Bytes {
+		unit = "bytes"
+	}
+	result := make([]string, 0, len(labels)+2)
+	result = append(result, labels...)
+	return append(result, limit.Match, unit)
+}
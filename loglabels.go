@@ -0,0 +1,92 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	logPrefixPacketsDesc = prometheus.NewDesc(
+		"iptables_log_prefix_packets_total",
+		"iptables_exporter: Total packets logged by LOG and NFLOG rules with a prefix.",
+		[]string{"log_prefix", "ip_family"},
+		nil,
+	)
+
+	logPrefixBytesDesc = prometheus.NewDesc(
+		"iptables_log_prefix_bytes_total",
+		"iptables_exporter: Total bytes logged by LOG and NFLOG rules with a prefix.",
+		[]string{"log_prefix", "ip_family"},
+		nil,
+	)
+)
+
+// logPrefixLabeler exports the prefix of LOG and NFLOG rules as
+// "log_prefix" label.
+type logPrefixLabeler struct{}
+
+func (logPrefixLabeler) labelNames() []string {
+	return []string{"log_prefix"}
+}
+
+func (logPrefixLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{ruleLogPrefix(rule.Spec())}, false
+}
+
+// ruleLogPrefix returns the prefix given by --log-prefix, --nflog-prefix or
+// --ulog-prefix, as written, including trailing spaces.
+func ruleLogPrefix(spec iptables.RuleSpec) string {
+	for _, target := range []struct{ name, flag string }{
+		{"LOG", "--log-prefix"},
+		{"NFLOG", "--nflog-prefix"},
+		{"ULOG", "--ulog-prefix"},
+	} {
+		if spec.Target != target.name {
+			continue
+		}
+		if option, ok := spec.MatchOption(target.name, target.flag); ok && len(option.Values) == 1 {
+			return option.Values[0]
+		}
+	}
+	return ""
+}
+
+// collectLogPrefixes exports the counters of logging rules summed up per
+// prefix.
+func (c *collector) collectLogPrefixes(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	if !c.logPrefixes {
+		return
+	}
+	packets := make(map[string]uint64)
+	bytes := make(map[string]uint64)
+	for _, table := range tables {
+		for _, chain := range table {
+			for _, rule := range chain.Rules {
+				prefix := sanitizeLabelValue(ruleLogPrefix(rule.Spec()))
+				if prefix == "" {
+					continue
+				}
+				packets[prefix] += rule.Packets
+				bytes[prefix] += rule.Bytes
+			}
+		}
+	}
+	for prefix := range packets {
+		metricChan <- prometheus.MustNewConstMetric(logPrefixPacketsDesc, prometheus.CounterValue, float64(packets[prefix]), prefix, string(family))
+		metricChan <- prometheus.MustNewConstMetric(logPrefixBytesDesc, prometheus.CounterValue, float64(bytes[prefix]), prefix, string(family))
+	}
+}
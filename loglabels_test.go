@@ -0,0 +1,24 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestLogPrefixLabeler(t *testing.T) {
+	testLabeler(t, logPrefixLabeler{}, []labelerCase{
+		{text: `-j LOG --log-prefix "DROP: "`, values: []string{"DROP: "}},
+		{text: `-j DROP`, values: []string{""}},
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/common/log"
+	"github.com/sirupsen/logrus"
+)
+
+// logLevel tracks the log level changed at runtime, which
+// prometheus/common/log can set but not report.
+type logLevel struct {
+	mu         sync.Mutex
+	configured string
+	current    string
+}
+
+func newLogLevel(configured string) *logLevel {
+	return &logLevel{configured: configured, current: configured}
+}
+
+func (l *logLevel) get() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current
+}
+
+func (l *logLevel) set(level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := log.Base().SetLevel(parsed.String()); err != nil {
+		return err
+	}
+	log.Infof("Log level changed from %s to %s", l.current, parsed)
+	l.current = parsed.String()
+	return nil
+}
+
+// toggle switches between debug logging and the configured level.
+func (l *logLevel) toggle() error {
+	level := logrus.DebugLevel.String()
+	if l.get() == level {
+		level = l.configured
+	}
+	return l.set(level)
+}
+
+// toggleOnSignal toggles debug logging whenever the exporter receives
+// SIGUSR2.
+func (l *logLevel) toggleOnSignal() {
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	for range usr2 {
+		if err := l.toggle(); err != nil {
+			log.Errorf("Toggling debug logging: %s", err)
+		}
+	}
+}
+
+// ServeHTTP answers GET /-/loglevel with the current log level and sets it
+// to the request body on PUT.
+func (l *logLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := l.set(strings.TrimSpace(string(body))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, l.get())
+}
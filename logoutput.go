@@ -0,0 +1,197 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Targets of --log.output.
+const (
+	logStderr  = "stderr"
+	logSyslog  = "syslog"
+	logJournal = "journal"
+)
+
+const (
+	logIdentifier = "iptables_exporter"
+	journalSocket = "/run/systemd/journal/socket"
+)
+
+// setLogOutput sends log messages to the system log instead of stderr.
+func setLogOutput(output string) error {
+	var hook logrus.Hook
+	switch output {
+	case logStderr:
+		return nil
+	case logSyslog:
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, logIdentifier)
+		if err != nil {
+			return fmt.Errorf("connecting to syslog: %s", err)
+		}
+		hook = syslogHook{w}
+	case logJournal:
+		conn, err := net.Dial("unixgram", journalSocket)
+		if err != nil {
+			return fmt.Errorf("connecting to journald: %s", err)
+		}
+		hook = journalHook{conn}
+	default:
+		return fmt.Errorf("unknown log output %q", output)
+	}
+	log.AddHook(discardOutput{hook})
+	return nil
+}
+
+// discardOutput wraps a hook delivering messages itself, silencing the
+// logger's own output. Hooks fire before messages are written and hold the
+// logger's lock, the only place its output can be replaced through
+// prometheus/common/log.
+type discardOutput struct {
+	logrus.Hook
+}
+
+func (h discardOutput) Fire(entry *logrus.Entry) error {
+	entry.Logger.Out = ioutil.Discard
+	return h.Hook.Fire(entry)
+}
+
+// logFields returns the fields of entry sorted by name, with the source of
+// the message, which is set for every message, left out.
+func logFields(entry *logrus.Entry) []string {
+	var names []string
+	for name := range entry.Data {
+		if name != "source" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// syslogHook writes messages to syslog with the priority of their level,
+// appending their fields as key=value pairs.
+type syslogHook struct {
+	w *syslog.Writer
+}
+
+func (syslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h syslogHook) Fire(entry *logrus.Entry) error {
+	var b strings.Builder
+	b.WriteString(entry.Message)
+	for _, name := range logFields(entry) {
+		fmt.Fprintf(&b, " %s=%q", name, fmt.Sprint(entry.Data[name]))
+	}
+	if source, ok := entry.Data["source"]; ok {
+		fmt.Fprintf(&b, " source=%s", source)
+	}
+	msg := b.String()
+	switch entry.Level {
+	case logrus.DebugLevel, logrus.TraceLevel:
+		return h.w.Debug(msg)
+	case logrus.InfoLevel:
+		return h.w.Info(msg)
+	case logrus.WarnLevel:
+		return h.w.Warning(msg)
+	case logrus.ErrorLevel:
+		return h.w.Err(msg)
+	default:
+		return h.w.Crit(msg)
+	}
+}
+
+// journalHook sends messages to journald using its native protocol, with
+// their fields as journal fields.
+type journalHook struct {
+	conn net.Conn
+}
+
+func (journalHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// journalPriorities maps log levels to syslog priorities, as used by the
+// journal's PRIORITY field.
+var journalPriorities = map[logrus.Level]syslog.Priority{
+	logrus.PanicLevel: syslog.LOG_CRIT,
+	logrus.FatalLevel: syslog.LOG_CRIT,
+	logrus.ErrorLevel: syslog.LOG_ERR,
+	logrus.WarnLevel:  syslog.LOG_WARNING,
+	logrus.InfoLevel:  syslog.LOG_INFO,
+	logrus.DebugLevel: syslog.LOG_DEBUG,
+	logrus.TraceLevel: syslog.LOG_DEBUG,
+}
+
+func (h journalHook) Fire(entry *logrus.Entry) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(int(journalPriorities[entry.Level])))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", logIdentifier)
+	if source, ok := entry.Data["source"].(string); ok {
+		if i := strings.LastIndex(source, ":"); i >= 0 {
+			writeJournalField(&b, "CODE_FILE", source[:i])
+			writeJournalField(&b, "CODE_LINE", source[i+1:])
+		}
+	}
+	for _, name := range logFields(entry) {
+		writeJournalField(&b, journalFieldName(name), fmt.Sprint(entry.Data[name]))
+	}
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournalField appends a field in the journal's native format, which
+// needs values containing newlines to be prefixed with their length.
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalFieldName turns a log field into a journal field name, which
+// consists of upper case letters, digits and underscores. The prefix keeps
+// it apart from the fields set above and from those reserved by journald,
+// which begin with an underscore.
+func journalFieldName(name string) string {
+	mapped := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	return "FIELD_" + mapped
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// lxcCgroupRegexp finds the name of an LXC container in the cgroup of one of
+// its processes: lxc.payload.<name> for LXC 4 and later, LXD and Incus, or
+// lxc/<name> before. The monitor processes, in lxc.monitor.<name>, run in
+// the host's namespaces.
+var lxcCgroupRegexp = regexp.MustCompile(`/lxc(?:\.payload\.|/)([^/\n]+)`)
+
+// findLXCContainers returns the lowest process ID of every running LXC
+// container by name, looking through the processes in procPath. Containers
+// sharing the host's network namespace are left out.
+func findLXCContainers(procPath string) (map[string]int, error) {
+	hostNetns, err := os.Readlink(filepath.Join(procPath, "1", "ns", "net"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	containers := make(map[string]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Processes can exit while being looked at.
+		cgroup, err := ioutil.ReadFile(filepath.Join(procPath, entry.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		match := lxcCgroupRegexp.FindSubmatch(cgroup)
+		if match == nil {
+			continue
+		}
+		name := string(match[1])
+		if known, ok := containers[name]; ok && known < pid {
+			continue
+		}
+		netns, err := os.Readlink(filepath.Join(procPath, entry.Name(), "ns", "net"))
+		if err != nil || netns == hostNetns {
+			continue
+		}
+		containers[name] = pid
+	}
+	return containers, nil
+}
+
+// netnsSource runs the save commands in another network namespace with
+// nsenter, using this host's binaries.
+type netnsSource struct {
+	// path is the namespace file, e.g. /proc/<pid>/ns/net.
+	path string
+}
+
+func (s netnsSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	opts.Exec = []string{"nsenter", "--net=" + s.path}
+	return iptables.GetTablesStats(ctx, opts)
+}
+
+// lxcContainers collects the running LXC containers, discovered anew on
+// every scrape, exporting their metrics with a container label.
+type lxcContainers struct {
+	procPath     string
+	newCollector func(name string, source tablesSource) *collector
+
+	// own holds the exporter's metrics about the containers.
+	own   *prometheus.Registry
+	count prometheus.Gauge
+
+	mu         sync.Mutex
+	containers map[string]*lxcContainer
+}
+
+type lxcContainer struct {
+	pid       int
+	collector *collector
+	registry  *prometheus.Registry
+}
+
+func newLXCContainers(procPath string, newCollector func(name string, source tablesSource) *collector) *lxcContainers {
+	l := &lxcContainers{
+		procPath:     procPath,
+		newCollector: newCollector,
+		own:          prometheus.NewRegistry(),
+		count: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "iptables_lxc_containers",
+			Help: "iptables_exporter: Number of running LXC containers with a network namespace of their own.",
+		}),
+		containers: make(map[string]*lxcContainer),
+	}
+	l.own.MustRegister(l.count)
+	return l
+}
+
+// Gather implements prometheus.Gatherer. The collector of a container is
+// kept while its process ID stays the same, so caches and counter state
+// survive between scrapes.
+func (l *lxcContainers) Gather() ([]*dto.MetricFamily, error) {
+	found, err := findLXCContainers(l.procPath)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	for name := range l.containers {
+		if _, ok := found[name]; !ok {
+			delete(l.containers, name)
+		}
+	}
+	l.count.Set(float64(len(found)))
+	gatherers := prometheus.Gatherers{l.own}
+	for name, pid := range found {
+		container, ok := l.containers[name]
+		if !ok || container.pid != pid {
+			source := netnsSource{filepath.Join(l.procPath, strconv.Itoa(pid), "ns", "net")}
+			container = &lxcContainer{pid: pid, collector: l.newCollector(name, source), registry: prometheus.NewRegistry()}
+			prometheus.WrapRegistererWith(prometheus.Labels{"container": name}, container.registry).MustRegister(container.collector)
+			l.containers[name] = container
+		}
+		gatherers = append(gatherers, container.registry)
+	}
+	l.mu.Unlock()
+	return gatherers.Gather()
+}
+
+// probeTargets implements targetDiscoverer with the containers found by the
+// last scrape.
+func (l *lxcContainers) probeTargets() []probeTarget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	targets := make([]probeTarget, 0, len(l.containers))
+	for name, c := range l.containers {
+		targets = append(targets, probeTarget{name: name, kind: "lxc", collector: c.collector})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var firewallManagerDesc = prometheus.NewDesc(
+	"iptables_firewall_manager_info",
+	"iptables_exporter: Tool detected to manage the ruleset, by the chains it creates.",
+	[]string{"manager"},
+	nil,
+)
+
+// firewallManager recognizes a tool by the chains it creates.
+type firewallManager struct {
+	name string
+	// chains are names all of which have to exist in a table
+	chains []string
+	// prefix begins the name of a chain the tool creates
+	prefix string
+}
+
+var firewallManagers = []firewallManager{
+	{name: "firewalld", chains: []string{"INPUT_direct"}},
+	{name: "firewalld", prefix: "IN_public"},
+	{name: "ufw", prefix: "ufw-"},
+	{name: "ufw", prefix: "ufw6-"},
+	{name: "docker", chains: []string{"DOCKER"}},
+	{name: "docker", chains: []string{"DOCKER-USER"}},
+	{name: "kube-proxy", chains: []string{"KUBE-SERVICES"}},
+	{name: "fail2ban", prefix: "f2b-"},
+	{name: "libvirt", prefix: "LIBVIRT_"},
+	{name: "shorewall", chains: []string{"shorewall"}},
+	{name: "shorewall", chains: []string{"dynamic", "reject", "logreject"}},
+	{name: "csf", chains: []string{"LOCALINPUT", "DENYIN"}},
+	{name: "openwrt", chains: []string{"delegate_input", "delegate_forward"}},
+	{name: "vyos", prefix: "VYATTA_"},
+}
+
+// matches reports whether the tool created chains of table.
+func (m firewallManager) matches(table iptables.Table) bool {
+	if m.prefix != "" {
+		for name := range table {
+			if strings.HasPrefix(name, m.prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, chain := range m.chains {
+		if _, ok := table[chain]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// firewallManagersOf returns the tools detected in the tables of all
+// families, sorted, or "script" if there are rules but none was detected.
+func firewallManagersOf(families map[iptables.Family]iptables.Tables) []string {
+	found := make(map[string]bool)
+	hasRules := false
+	for _, tables := range families {
+		for _, table := range tables {
+			for _, m := range firewallManagers {
+				if !found[m.name] && m.matches(table) {
+					found[m.name] = true
+				}
+			}
+			for _, chain := range table {
+				if len(chain.Rules) > 0 {
+					hasRules = true
+				}
+			}
+		}
+	}
+	managers := make([]string, 0, len(found))
+	for name := range found {
+		managers = append(managers, name)
+	}
+	sort.Strings(managers)
+	if len(managers) == 0 && hasRules {
+		managers = append(managers, "script")
+	}
+	return managers
+}
+
+// collectFirewallManagers exports the tools detected to manage the ruleset.
+func (c *collector) collectFirewallManagers(metricChan chan<- prometheus.Metric, families map[iptables.Family]iptables.Tables) {
+	for _, manager := range firewallManagersOf(families) {
+		metricChan <- prometheus.MustNewConstMetric(firewallManagerDesc, prometheus.GaugeValue, 1, manager)
+	}
+}
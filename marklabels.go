@@ -0,0 +1,92 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// markOptions are the options carrying a firewall mark, by extension.
+var markOptions = []struct{ match, flag string }{
+	{"mark", "--mark"},
+	{"connmark", "--mark"},
+	{"MARK", "--set-mark"},
+	{"MARK", "--set-xmark"},
+	{"CONNMARK", "--set-mark"},
+	{"CONNMARK", "--set-xmark"},
+	{"TPROXY", "--tproxy-mark"},
+}
+
+// markLabeler exports the mark matched or set by a rule as "mark" label,
+// translated to a name where one is configured.
+type markLabeler struct {
+	names map[uint64]string
+}
+
+// newMarkLabeler accepts the marks section of the configuration, mapping
+// marks (decimal or hex) to names.
+func newMarkLabeler(names map[string]string) (*markLabeler, error) {
+	l := &markLabeler{names: make(map[uint64]string)}
+	for mark, name := range names {
+		value, err := strconv.ParseUint(mark, 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mark %q", mark)
+		}
+		l.names[value] = name
+	}
+	return l, nil
+}
+
+func (l *markLabeler) labelNames() []string {
+	return []string{"mark"}
+}
+
+func (l *markLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	for _, o := range markOptions {
+		if option, ok := spec.MatchOption(o.match, o.flag); ok && len(option.Values) > 0 {
+			return []string{l.format(option.Values[0], option.Negated)}, false
+		}
+	}
+	return []string{""}, false
+}
+
+// format renders value/mask, naming the value if it's fully masked and
+// known.
+func (l *markLabeler) format(mark string, negated bool) string {
+	prefix := ""
+	if negated {
+		prefix = "!"
+	}
+	parts := strings.SplitN(mark, "/", 2)
+	value, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return prefix + mark
+	}
+	if len(parts) == 2 {
+		mask, err := strconv.ParseUint(parts[1], 0, 32)
+		if err != nil || mask != 0xffffffff {
+			return fmt.Sprintf("%s0x%x/%s", prefix, value, parts[1])
+		}
+	}
+	if name, ok := l.names[value]; ok {
+		return prefix + name
+	}
+	return fmt.Sprintf("%s0x%x", prefix, value)
+}
@@ -0,0 +1,30 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestMarkLabeler(t *testing.T) {
+	marks, err := newMarkLabeler(map[string]string{"0x1": "vpn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLabeler(t, marks, []labelerCase{
+		{text: `-m mark --mark 0x1 -j ACCEPT`, values: []string{"vpn"}},
+		{text: `-m mark ! --mark 0x1/0xffffffff -j ACCEPT`, values: []string{"!vpn"}},
+		{text: `-j MARK --set-xmark 0x2/0xff`, values: []string{"0x2/0xff"}},
+		{text: `-j ACCEPT`, values: []string{""}},
+	})
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	masqueradePacketsDesc = prometheus.NewDesc(
+		"iptables_masquerade_packets_total",
+		"iptables_exporter: Total packets matching MASQUERADE rules of the nat table, per output interface.",
+		[]string{"out_interface", "ip_family"},
+		nil,
+	)
+
+	masqueradeBytesDesc = prometheus.NewDesc(
+		"iptables_masquerade_bytes_total",
+		"iptables_exporter: Total bytes matching MASQUERADE rules of the nat table, per output interface.",
+		[]string{"out_interface", "ip_family"},
+		nil,
+	)
+)
+
+// collectMasquerade exports the counters of the MASQUERADE rules of the nat
+// table summed up per -o interface. Rules without one are exported with an
+// empty interface.
+func (c *collector) collectMasquerade(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	packets := make(map[string]uint64)
+	bytes := make(map[string]uint64)
+	for _, chain := range tables["nat"] {
+		for _, rule := range chain.Rules {
+			spec := rule.Spec()
+			if spec.Target != "MASQUERADE" {
+				continue
+			}
+			packets[spec.OutInterface] += rule.Packets
+			bytes[spec.OutInterface] += rule.Bytes
+		}
+	}
+	for iface := range packets {
+		metricChan <- prometheus.MustNewConstMetric(masqueradePacketsDesc, prometheus.CounterValue, float64(packets[iface]), iface, string(family))
+		metricChan <- prometheus.MustNewConstMetric(masqueradeBytesDesc, prometheus.CounterValue, float64(bytes[iface]), iface, string(family))
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ruleDPorts returns the values of the dport label of a rule when
+// expanding multiport rules: every port or range listed by --dports of the
+// multiport match, otherwise the --dport of the rule, if any. Negated port
+// lists aren't expanded.
+func ruleDPorts(spec iptables.RuleSpec) []string {
+	if spec.DPort == "" {
+		return []string{""}
+	}
+	if strings.HasPrefix(spec.DPort, "!") || !strings.Contains(spec.DPort, ",") {
+		return []string{spec.DPort}
+	}
+	return strings.Split(spec.DPort, ",")
+}
@@ -0,0 +1,221 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// containerIDRegexp finds the ID of a Docker, containerd or CRI-O container
+// in the cgroup of one of its processes, e.g. docker-<id>.scope or
+// /kubepods/.../<id>.
+var containerIDRegexp = regexp.MustCompile(`[/:-]([0-9a-f]{64})(?:\.scope)?\s*$`)
+
+// networkNamespace is a network namespace other than the host's.
+type networkNamespace struct {
+	// path is the namespace file, /proc/<pid>/ns/net or a file in
+	// --path.netns.
+	path string
+	// container is the name of the LXC container or the ID of the
+	// container of the lowest process in the namespace, if any.
+	container string
+	pid       int
+}
+
+// containerName returns the LXC name or container ID cgroup names, empty if
+// it names neither.
+func containerName(cgroup []byte) string {
+	if match := lxcCgroupRegexp.FindSubmatch(cgroup); match != nil {
+		return string(match[1])
+	}
+	for _, line := range strings.Split(string(cgroup), "\n") {
+		if match := containerIDRegexp.FindStringSubmatch(line); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// findNetworkNamespaces returns the network namespaces other than the
+// host's, by the value of their netns label: the name of those in netnsPath,
+// as created by ip netns add, and the inode number of those only processes
+// in procPath are in.
+func findNetworkNamespaces(procPath, netnsPath string) (map[string]*networkNamespace, error) {
+	host, err := os.Readlink(filepath.Join(procPath, "1", "ns", "net"))
+	if err != nil {
+		return nil, err
+	}
+	// byLink holds the namespaces by the target of their links in procfs,
+	// net:[<inode>].
+	byLink := make(map[string]*networkNamespace)
+	namespaces := make(map[string]*networkNamespace)
+	named, err := ioutil.ReadDir(netnsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range named {
+		path := filepath.Join(netnsPath, entry.Name())
+		// Named namespaces are bind mounts of the namespace inode.
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			continue
+		}
+		link := fmt.Sprintf("net:[%d]", stat.Ino)
+		if link == host {
+			continue
+		}
+		ns := &networkNamespace{path: path}
+		byLink[link] = ns
+		namespaces[entry.Name()] = ns
+	}
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		// Processes can exit while being looked at.
+		path := filepath.Join(procPath, entry.Name(), "ns", "net")
+		link, err := os.Readlink(path)
+		if err != nil || link == host {
+			continue
+		}
+		ns, ok := byLink[link]
+		if !ok {
+			ns = &networkNamespace{path: path}
+			byLink[link] = ns
+			namespaces[strings.TrimSuffix(strings.TrimPrefix(link, "net:["), "]")] = ns
+		}
+		if ns.pid != 0 && ns.pid < pid {
+			continue
+		}
+		cgroup, err := ioutil.ReadFile(filepath.Join(procPath, entry.Name(), "cgroup"))
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(ns.path, netnsPath) {
+			ns.path = path
+		}
+		ns.pid = pid
+		ns.container = containerName(cgroup)
+	}
+	return namespaces, nil
+}
+
+// networkNamespaces collects the network namespaces of the host, discovered
+// anew on every scrape, exporting their metrics with netns and container
+// labels.
+type networkNamespaces struct {
+	procPath     string
+	netnsPath    string
+	newCollector func(name string, source tablesSource) *collector
+
+	// own holds the exporter's metrics about the namespaces.
+	own   *prometheus.Registry
+	count prometheus.Gauge
+
+	mu         sync.Mutex
+	namespaces map[string]*netnsTarget
+}
+
+type netnsTarget struct {
+	path      string
+	container string
+	collector *collector
+	registry  *prometheus.Registry
+}
+
+func newNetworkNamespaces(procPath, netnsPath string, newCollector func(name string, source tablesSource) *collector) *networkNamespaces {
+	n := &networkNamespaces{
+		procPath:     procPath,
+		netnsPath:    netnsPath,
+		newCollector: newCollector,
+		own:          prometheus.NewRegistry(),
+		count: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "iptables_network_namespaces",
+			Help: "iptables_exporter: Number of network namespaces collected besides the host's.",
+		}),
+		namespaces: make(map[string]*netnsTarget),
+	}
+	n.own.MustRegister(n.count)
+	return n
+}
+
+// Gather implements prometheus.Gatherer. The collector of a namespace is
+// kept while it is found at the same path, so caches and counter state
+// survive between scrapes.
+func (n *networkNamespaces) Gather() ([]*dto.MetricFamily, error) {
+	found, err := findNetworkNamespaces(n.procPath, n.netnsPath)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	for name := range n.namespaces {
+		if _, ok := found[name]; !ok {
+			delete(n.namespaces, name)
+		}
+	}
+	n.count.Set(float64(len(found)))
+	gatherers := prometheus.Gatherers{n.own}
+	for name, ns := range found {
+		c, ok := n.namespaces[name]
+		if !ok || c.path != ns.path || c.container != ns.container {
+			c = &netnsTarget{
+				path:      ns.path,
+				container: ns.container,
+				collector: n.newCollector("netns "+name, netnsSource{ns.path}),
+				registry:  prometheus.NewRegistry(),
+			}
+			labels := prometheus.Labels{"netns": name, "container": ns.container}
+			prometheus.WrapRegistererWith(labels, c.registry).MustRegister(c.collector)
+			n.namespaces[name] = c
+		}
+		gatherers = append(gatherers, c.registry)
+	}
+	n.mu.Unlock()
+	return gatherers.Gather()
+}
+
+// probeTargets implements targetDiscoverer with the namespaces found by the
+// last scrape, named by their netns label.
+func (n *networkNamespaces) probeTargets() []probeTarget {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	targets := make([]probeTarget, 0, len(n.namespaces))
+	for name, c := range n.namespaces {
+		targets = append(targets, probeTarget{name: name, kind: "netns", collector: c.collector})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	return targets
+}
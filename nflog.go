@@ -0,0 +1,148 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nfprotoNames names the netfilter protocol families listed in nf_log.
+var nfprotoNames = map[string]string{
+	"0":  "unspec",
+	"1":  "inet",
+	"2":  "ipv4",
+	"3":  "arp",
+	"5":  "netdev",
+	"7":  "bridge",
+	"10": "ipv6",
+	"12": "decnet",
+}
+
+var (
+	nfLogLoggerDesc = prometheus.NewDesc(
+		"iptables_nf_log_logger_info",
+		"iptables_exporter: Logger bound to a protocol family for LOG and NFLOG targets.",
+		[]string{"family", "logger"},
+		nil,
+	)
+
+	nflogQueueLengthDesc = prometheus.NewDesc(
+		"iptables_nflog_group_queue_length",
+		"iptables_exporter: Number of packets queued in an NFLOG group and not yet sent to its listener.",
+		[]string{"group"},
+		nil,
+	)
+
+	nflogCopyRangeDesc = prometheus.NewDesc(
+		"iptables_nflog_group_copy_range_bytes",
+		"iptables_exporter: Number of bytes of each packet copied to an NFLOG group's listener.",
+		[]string{"group"},
+		nil,
+	)
+
+	nflogFlushTimeoutDesc = prometheus.NewDesc(
+		"iptables_nflog_group_flush_timeout_seconds",
+		"iptables_exporter: Time after which an NFLOG group's queue is flushed to its listener.",
+		[]string{"group"},
+		nil,
+	)
+
+	nflogListenerDesc = prometheus.NewDesc(
+		"iptables_nflog_group_listener_info",
+		"iptables_exporter: Netlink port ID and copy mode of the process listening on an NFLOG group.",
+		[]string{"group", "port_id", "copy_mode"},
+		nil,
+	)
+)
+
+// nflogCollector exports NFLOG statistics from /proc/net/netfilter, so
+// packet-log pipelines such as ulogd can be watched for growing queues or
+// a missing listener. The kernel doesn't expose per-group drop counters.
+type nflogCollector struct {
+	procPath string
+}
+
+func (c nflogCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- nfLogLoggerDesc
+	descChan <- nflogQueueLengthDesc
+	descChan <- nflogCopyRangeDesc
+	descChan <- nflogFlushTimeoutDesc
+	descChan <- nflogListenerDesc
+}
+
+func (c nflogCollector) update(metricChan chan<- prometheus.Metric) error {
+	err := c.readLines("nf_log", func(fields []string) {
+		// " 2 nf_log_ipv4 (nf_log_ipv4,nfnetlink_log)"
+		if len(fields) < 2 || fields[1] == "NONE" {
+			return
+		}
+		family, ok := nfprotoNames[fields[0]]
+		if !ok {
+			family = fields[0]
+		}
+		metricChan <- prometheus.MustNewConstMetric(nfLogLoggerDesc, prometheus.GaugeValue, 1, family, fields[1])
+	})
+	if err != nil {
+		return fmt.Errorf("reading nf_log: %s", err)
+	}
+
+	err = c.readLines("nfnetlink_log", func(fields []string) {
+		// group, peer port ID, queue length, copy mode, copy range, flush
+		// timeout (1/100s), use count
+		if len(fields) < 6 {
+			return
+		}
+		group := fields[0]
+		metricChan <- prometheus.MustNewConstMetric(nflogListenerDesc, prometheus.GaugeValue, 1, group, fields[1], fields[3])
+		if v, err := strconv.ParseFloat(fields[2], 64); err == nil {
+			metricChan <- prometheus.MustNewConstMetric(nflogQueueLengthDesc, prometheus.GaugeValue, v, group)
+		}
+		if v, err := strconv.ParseFloat(fields[4], 64); err == nil {
+			metricChan <- prometheus.MustNewConstMetric(nflogCopyRangeDesc, prometheus.GaugeValue, v, group)
+		}
+		if v, err := strconv.ParseFloat(fields[5], 64); err == nil {
+			metricChan <- prometheus.MustNewConstMetric(nflogFlushTimeoutDesc, prometheus.GaugeValue, v/100, group)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("reading nfnetlink_log: %s", err)
+	}
+	return nil
+}
+
+// readLines calls fn with the fields of every line of a file in
+// /proc/net/netfilter. A missing file, i.e. an unloaded module, is no error.
+func (c nflogCollector) readLines(name string, fn func(fields []string)) error {
+	f, err := os.Open(filepath.Join(c.procPath, "net", "netfilter", name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,263 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/nftables"
+)
+
+// The family label of the nftables metrics holds the nft family as is: ip,
+// ip6, inet, arp, bridge or netdev. It isn't an ip_family like that of the
+// iptables metrics, as inet tables filter IPv4 and IPv6 alike with the same
+// counters, and arp, bridge and netdev tables filter below IP.
+var (
+	nftTablePacketsDesc = prometheus.NewDesc(
+		"nftables_table_packets_total",
+		"iptables_exporter: Sum of the packet counters of the rules of an nftables table.",
+		[]string{"family", "table"},
+		nil,
+	)
+
+	nftTableBytesDesc = prometheus.NewDesc(
+		"nftables_table_bytes_total",
+		"iptables_exporter: Sum of the byte counters of the rules of an nftables table.",
+		[]string{"family", "table"},
+		nil,
+	)
+
+	nftChainDesc = prometheus.NewDesc(
+		"nftables_chain_info",
+		"iptables_exporter: Type, hook and policy of an nftables chain, empty for regular chains.",
+		[]string{"family", "table", "chain", "type", "hook", "policy"},
+		nil,
+	)
+
+	nftChainPacketsDesc = prometheus.NewDesc(
+		"nftables_chain_packets_total",
+		"iptables_exporter: Sum of the packet counters of the rules of an nftables chain.",
+		[]string{"family", "table", "chain"},
+		nil,
+	)
+
+	nftChainBytesDesc = prometheus.NewDesc(
+		"nftables_chain_bytes_total",
+		"iptables_exporter: Sum of the byte counters of the rules of an nftables chain.",
+		[]string{"family", "table", "chain"},
+		nil,
+	)
+
+	nftCounterPacketsDesc = prometheus.NewDesc(
+		"nftables_counter_packets_total",
+		"iptables_exporter: Packets counted by a named nftables counter.",
+		[]string{"family", "table", "counter"},
+		nil,
+	)
+
+	nftCounterBytesDesc = prometheus.NewDesc(
+		"nftables_counter_bytes_total",
+		"iptables_exporter: Bytes counted by a named nftables counter.",
+		[]string{"family", "table", "counter"},
+		nil,
+	)
+
+	nftCtTimeoutDesc = prometheus.NewDesc(
+		"nftables_ct_timeout_info",
+		"iptables_exporter: Protocols of an nftables ct timeout policy.",
+		[]string{"family", "table", "name", "protocol", "l3proto"},
+		nil,
+	)
+
+	nftCtTimeoutSecondsDesc = prometheus.NewDesc(
+		"nftables_ct_timeout_seconds",
+		"iptables_exporter: Timeout an nftables ct timeout policy sets for a connection state.",
+		[]string{"family", "table", "name", "state"},
+		nil,
+	)
+
+	nftCtExpectationDesc = prometheus.NewDesc(
+		"nftables_ct_expectation_info",
+		"iptables_exporter: Protocols and destination port of an nftables ct expectation.",
+		[]string{"family", "table", "name", "protocol", "l3proto", "dport"},
+		nil,
+	)
+
+	nftCtExpectationTimeoutDesc = prometheus.NewDesc(
+		"nftables_ct_expectation_timeout_seconds",
+		"iptables_exporter: Timeout of the expected connections of an nftables ct expectation.",
+		[]string{"family", "table", "name"},
+		nil,
+	)
+
+	nftCtExpectationSizeDesc = prometheus.NewDesc(
+		"nftables_ct_expectation_size",
+		"iptables_exporter: Maximum number of expected connections of an nftables ct expectation.",
+		[]string{"family", "table", "name"},
+		nil,
+	)
+)
+
+// nftablesCollector exports the counters of the native nftables ruleset,
+// listed with nft -j, including the tables the iptables-nft commands don't
+// show. Rules without a counter statement count nothing and aren't exported.
+// nftables chains have no counters of their own, so those of chains and
+// tables are the sums of the counters of their rules.
+type nftablesCollector struct {
+	// timeout, if positive, limits how long nft may run.
+	timeout time.Duration
+	// timeouts counts the collections killed because nft took longer.
+	timeouts *uint64
+	// openwrt adds the zone and section labels of OpenWrt's fw4 to the
+	// rule metrics.
+	openwrt bool
+
+	rulePacketsDesc *prometheus.Desc
+	ruleBytesDesc   *prometheus.Desc
+}
+
+// nftKey identifies a table by family and name, or a chain by family,
+// table and name.
+type nftKey struct {
+	family, table, chain string
+}
+
+func newNftablesCollector(timeout time.Duration, openwrt bool) nftablesCollector {
+	labels := []string{"family", "table", "chain", "handle", "comment", "verdict"}
+	if openwrt {
+		labels = append(labels, "zone", "section")
+	}
+	return nftablesCollector{
+		timeout:  timeout,
+		timeouts: new(uint64),
+		openwrt:  openwrt,
+		rulePacketsDesc: prometheus.NewDesc(
+			"nftables_rule_packets_total",
+			"iptables_exporter: Packets counted by the counter statement of an nftables rule.",
+			labels,
+			nil,
+		),
+		ruleBytesDesc: prometheus.NewDesc(
+			"nftables_rule_bytes_total",
+			"iptables_exporter: Bytes counted by the counter statement of an nftables rule.",
+			labels,
+			nil,
+		),
+	}
+}
+
+func (c nftablesCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- nftTablePacketsDesc
+	descChan <- nftTableBytesDesc
+	descChan <- nftChainDesc
+	descChan <- nftChainPacketsDesc
+	descChan <- nftChainBytesDesc
+	descChan <- c.rulePacketsDesc
+	descChan <- c.ruleBytesDesc
+	descChan <- nftCounterPacketsDesc
+	descChan <- nftCounterBytesDesc
+	descChan <- nftCtTimeoutDesc
+	descChan <- nftCtTimeoutSecondsDesc
+	descChan <- nftCtExpectationDesc
+	descChan <- nftCtExpectationTimeoutDesc
+	descChan <- nftCtExpectationSizeDesc
+	descChan <- familyTimeoutsDesc
+}
+
+func (c nftablesCollector) update(metricChan chan<- prometheus.Metric) error {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	ruleset, err := nftables.List(ctx)
+	timeExec("nft", "", start)
+	if errors.Is(err, context.DeadlineExceeded) {
+		atomic.AddUint64(c.timeouts, 1)
+	}
+	// nft lists the tables of all families at once, hence ip_family="any".
+	metricChan <- prometheus.MustNewConstMetric(familyTimeoutsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(c.timeouts)), string(anyFamily))
+	if err != nil {
+		return err
+	}
+	c.collect(metricChan, ruleset)
+	return nil
+}
+
+// collect exports the metrics of ruleset.
+func (c nftablesCollector) collect(metricChan chan<- prometheus.Metric, ruleset nftables.Ruleset) {
+	tables := make(map[nftKey]*ruleCounts)
+	for _, table := range ruleset.Tables {
+		tables[nftKey{table.Family, table.Name, ""}] = &ruleCounts{}
+	}
+	chains := make(map[nftKey]*ruleCounts)
+	for _, chain := range ruleset.Chains {
+		chains[nftKey{chain.Family, chain.Table, chain.Name}] = &ruleCounts{}
+		metricChan <- prometheus.MustNewConstMetric(nftChainDesc, prometheus.GaugeValue, 1,
+			chain.Family, chain.Table, chain.Name, chain.Type, chain.Hook, chain.Policy)
+	}
+	for _, rule := range ruleset.Rules {
+		if !rule.HasCounter {
+			continue
+		}
+		for _, counts := range []*ruleCounts{tables[nftKey{rule.Family, rule.Table, ""}], chains[nftKey{rule.Family, rule.Table, rule.Chain}]} {
+			if counts != nil {
+				counts.packets += rule.Packets
+				counts.bytes += rule.Bytes
+			}
+		}
+		labels := []string{rule.Family, rule.Table, rule.Chain, strconv.FormatUint(rule.Handle, 10), rule.Comment, rule.Verdict}
+		if c.openwrt {
+			labels = append(labels, fw4Zone(rule.Family, rule.Table, rule.Chain), openwrtSection(rule.Comment))
+		}
+		metricChan <- prometheus.MustNewConstMetric(c.rulePacketsDesc, prometheus.CounterValue, float64(rule.Packets), labels...)
+		metricChan <- prometheus.MustNewConstMetric(c.ruleBytesDesc, prometheus.CounterValue, float64(rule.Bytes), labels...)
+	}
+	for key, counts := range tables {
+		metricChan <- prometheus.MustNewConstMetric(nftTablePacketsDesc, prometheus.CounterValue, float64(counts.packets), key.family, key.table)
+		metricChan <- prometheus.MustNewConstMetric(nftTableBytesDesc, prometheus.CounterValue, float64(counts.bytes), key.family, key.table)
+	}
+	for key, counts := range chains {
+		metricChan <- prometheus.MustNewConstMetric(nftChainPacketsDesc, prometheus.CounterValue, float64(counts.packets), key.family, key.table, key.chain)
+		metricChan <- prometheus.MustNewConstMetric(nftChainBytesDesc, prometheus.CounterValue, float64(counts.bytes), key.family, key.table, key.chain)
+	}
+	for _, counter := range ruleset.Counters {
+		metricChan <- prometheus.MustNewConstMetric(nftCounterPacketsDesc, prometheus.CounterValue, float64(counter.Packets), counter.Family, counter.Table, counter.Name)
+		metricChan <- prometheus.MustNewConstMetric(nftCounterBytesDesc, prometheus.CounterValue, float64(counter.Bytes), counter.Family, counter.Table, counter.Name)
+	}
+	for _, timeout := range ruleset.CtTimeouts {
+		metricChan <- prometheus.MustNewConstMetric(nftCtTimeoutDesc, prometheus.GaugeValue, 1,
+			timeout.Family, timeout.Table, timeout.Name, timeout.Protocol, timeout.L3Proto)
+		for state, seconds := range timeout.Policy {
+			metricChan <- prometheus.MustNewConstMetric(nftCtTimeoutSecondsDesc, prometheus.GaugeValue, float64(seconds),
+				timeout.Family, timeout.Table, timeout.Name, state)
+		}
+	}
+	for _, expectation := range ruleset.CtExpectations {
+		metricChan <- prometheus.MustNewConstMetric(nftCtExpectationDesc, prometheus.GaugeValue, 1,
+			expectation.Family, expectation.Table, expectation.Name, expectation.Protocol, expectation.L3Proto, strconv.Itoa(int(expectation.Dport)))
+		metricChan <- prometheus.MustNewConstMetric(nftCtExpectationTimeoutDesc, prometheus.GaugeValue, float64(expectation.Timeout)/1000,
+			expectation.Family, expectation.Table, expectation.Name)
+		metricChan <- prometheus.MustNewConstMetric(nftCtExpectationSizeDesc, prometheus.GaugeValue, float64(expectation.Size),
+			expectation.Family, expectation.Table, expectation.Name)
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nftables reads the counters of the nftables ruleset from the JSON
+// output of nft.
+package nftables
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Ruleset holds the tables, chains, rules, named counters and conntrack
+// objects of a ruleset.
+type Ruleset struct {
+	Tables         []Table
+	Chains         []Chain
+	Rules          []Rule
+	Counters       []Counter
+	CtTimeouts     []CtTimeout
+	CtExpectations []CtExpectation
+}
+
+// Table is a table of a family: ip, ip6, inet, arp, bridge or netdev.
+type Table struct {
+	Family string `json:"family"`
+	Name   string `json:"name"`
+	Handle uint64 `json:"handle"`
+}
+
+// Chain is a chain of a table. Type, Hook and Policy are empty for regular
+// chains, which are only reached by jumps.
+type Chain struct {
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Handle uint64 `json:"handle"`
+	Type   string `json:"type"`
+	Hook   string `json:"hook"`
+	Prio   int    `json:"prio"`
+	Policy string `json:"policy"`
+}
+
+// Rule is a rule of a chain. HasCounter is false for rules without a
+// counter statement, which count no packets.
+type Rule struct {
+	Family  string
+	Table   string
+	Chain   string
+	Handle  uint64
+	Comment string
+	// Verdict is the verdict statement of the rule, e.g. "accept" or
+	// "jump input_wan", or empty if it has none.
+	Verdict    string
+	HasCounter bool
+	Packets    uint64
+	Bytes      uint64
+}
+
+// Counter is a named counter object.
+type Counter struct {
+	Family  string `json:"family"`
+	Table   string `json:"table"`
+	Name    string `json:"name"`
+	Handle  uint64 `json:"handle"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// CtTimeout is a ct timeout object, a conntrack timeout policy assigned to
+// connections by rules. Policy maps connection states, e.g. "established",
+// to their timeouts in seconds.
+type CtTimeout struct {
+	Family   string            `json:"family"`
+	Table    string            `json:"table"`
+	Name     string            `json:"name"`
+	Handle   uint64            `json:"handle"`
+	Protocol string            `json:"protocol"`
+	L3Proto  string            `json:"l3proto"`
+	Policy   map[string]uint64 `json:"policy"`
+}
+
+// CtExpectation is a ct expectation object, which lets rules create
+// expected connections to Dport. Timeout is in milliseconds and Size is the
+// maximum number of expectations.
+type CtExpectation struct {
+	Family   string `json:"family"`
+	Table    string `json:"table"`
+	Name     string `json:"name"`
+	Handle   uint64 `json:"handle"`
+	Protocol string `json:"protocol"`
+	L3Proto  string `json:"l3proto"`
+	Dport    uint16 `json:"dport"`
+	Timeout  uint64 `json:"timeout"`
+	Size     uint32 `json:"size"`
+}
+
+// List runs nft -j list ruleset and parses its output. nft is killed once
+// ctx is done.
+func List(ctx context.Context) (Ruleset, error) {
+	cmd := exec.CommandContext(ctx, "nft", "-j", "list", "ruleset")
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return Ruleset{}, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return Ruleset{}, err
+	}
+	var stderr bytes.Buffer
+	stderrDone := make(chan struct{})
+	go func() {
+		io.Copy(&stderr, stderrPipe)
+		close(stderrDone)
+	}()
+
+	// Buffered so the parser doesn't leak if nft fails to start
+	resultCh := make(chan struct {
+		Ruleset
+		error
+	}, 1)
+	go func() {
+		ruleset, parseErr := Parse(pipe)
+		resultCh <- struct {
+			Ruleset
+			error
+		}{ruleset, parseErr}
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return Ruleset{}, err
+	}
+	var r struct {
+		Ruleset
+		error
+	}
+	// Like the save commands of the iptables package, stop reading once
+	// ctx is done rather than wait for whatever holds the output open.
+	select {
+	case r = <-resultCh:
+	case <-ctx.Done():
+		pipe.Close()
+		r = <-resultCh
+	}
+	select {
+	case <-stderrDone:
+	case <-ctx.Done():
+		stderrPipe.Close()
+		<-stderrDone
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return Ruleset{}, fmt.Errorf("nft: %w", ctx.Err())
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%s: %s", err, msg)
+		}
+		return Ruleset{}, err
+	}
+	return r.Ruleset, r.error
+}
+
+// ruleObject is a rule as nft writes it. Its statements are decoded one by
+// one as only counters and verdicts are of interest.
+type ruleObject struct {
+	Family  string                       `json:"family"`
+	Table   string                       `json:"table"`
+	Chain   string                       `json:"chain"`
+	Handle  uint64                       `json:"handle"`
+	Comment string                       `json:"comment"`
+	Expr    []map[string]json.RawMessage `json:"expr"`
+}
+
+// verdicts are the verdict statements without a target chain.
+var verdicts = []string{"accept", "drop", "reject", "return", "continue", "queue"}
+
+// Parse parses the output of nft -j list ruleset.
+func Parse(r io.Reader) (Ruleset, error) {
+	var output struct {
+		Nftables []map[string]json.RawMessage `json:"nftables"`
+	}
+	if err := json.NewDecoder(r).Decode(&output); err != nil {
+		return Ruleset{}, err
+	}
+	var ruleset Ruleset
+	for _, object := range output.Nftables {
+		// Every object has a single key naming its type. Other types such
+		// as metainfo, set or map aren't exported.
+		for kind, value := range object {
+			var err error
+			switch kind {
+			case "table":
+				var table Table
+				err = json.Unmarshal(value, &table)
+				ruleset.Tables = append(ruleset.Tables, table)
+			case "chain":
+				var chain Chain
+				err = json.Unmarshal(value, &chain)
+				ruleset.Chains = append(ruleset.Chains, chain)
+			case "rule":
+				var rule Rule
+				rule, err = parseRule(value)
+				ruleset.Rules = append(ruleset.Rules, rule)
+			case "counter":
+				var counter Counter
+				err = json.Unmarshal(value, &counter)
+				ruleset.Counters = append(ruleset.Counters, counter)
+			case "ct timeout":
+				var timeout CtTimeout
+				err = json.Unmarshal(value, &timeout)
+				ruleset.CtTimeouts = append(ruleset.CtTimeouts, timeout)
+			case "ct expectation":
+				var expectation CtExpectation
+				err = json.Unmarshal(value, &expectation)
+				ruleset.CtExpectations = append(ruleset.CtExpectations, expectation)
+			}
+			if err != nil {
+				return Ruleset{}, fmt.Errorf("parsing %s: %s", kind, err)
+			}
+		}
+	}
+	return ruleset, nil
+}
+
+// parseRule parses a rule object, taking its counters from its counter
+// statement and its verdict from its last verdict statement.
+func parseRule(value json.RawMessage) (Rule, error) {
+	var object ruleObject
+	if err := json.Unmarshal(value, &object); err != nil {
+		return Rule{}, err
+	}
+	rule := Rule{
+		Family:  object.Family,
+		Table:   object.Table,
+		Chain:   object.Chain,
+		Handle:  object.Handle,
+		Comment: object.Comment,
+	}
+	for _, statement := range object.Expr {
+		if value, ok := statement["counter"]; ok {
+			// A reference to a named counter is a string, its counts are
+			// those of the counter object.
+			var counter struct {
+				Packets uint64 `json:"packets"`
+				Bytes   uint64 `json:"bytes"`
+			}
+			if json.Unmarshal(value, &counter) == nil {
+				rule.HasCounter = true
+				rule.Packets = counter.Packets
+				rule.Bytes = counter.Bytes
+			}
+		}
+		for _, verdict := range []string{"jump", "goto"} {
+			if value, ok := statement[verdict]; ok {
+				var target struct {
+					Target string `json:"target"`
+				}
+				if err := json.Unmarshal(value, &target); err != nil {
+					return Rule{}, fmt.Errorf("parsing %s: %s", verdict, err)
+				}
+				rule.Verdict = verdict + " " + target.Target
+			}
+		}
+		for _, verdict := range verdicts {
+			if _, ok := statement[verdict]; ok {
+				rule.Verdict = verdict
+			}
+		}
+	}
+	return rule, nil
+}
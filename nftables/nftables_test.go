@@ -0,0 +1,121 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nftables
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("ruleset.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ruleset, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Ruleset{
+		Tables: []Table{
+			{Family: "inet", Name: "filter", Handle: 1},
+			{Family: "ip", Name: "nat", Handle: 2},
+		},
+		Chains: []Chain{
+			{Family: "inet", Table: "filter", Name: "input", Handle: 1, Type: "filter", Hook: "input", Policy: "drop"},
+			{Family: "inet", Table: "filter", Name: "input_wan", Handle: 2},
+			{Family: "ip", Table: "nat", Name: "postrouting", Handle: 1, Type: "nat", Hook: "postrouting", Prio: 100, Policy: "accept"},
+		},
+		Rules: []Rule{
+			{Family: "inet", Table: "filter", Chain: "input", Handle: 4, Verdict: "accept", HasCounter: true, Packets: 1204, Bytes: 98231},
+			{Family: "inet", Table: "filter", Chain: "input", Handle: 5, Comment: "wan", Verdict: "jump input_wan", HasCounter: true, Packets: 37, Bytes: 2960},
+			{Family: "inet", Table: "filter", Chain: "input", Handle: 6, Verdict: "accept"},
+			{Family: "inet", Table: "filter", Chain: "input_wan", Handle: 7, Verdict: "accept"},
+			{Family: "ip", Table: "nat", Chain: "postrouting", Handle: 3, HasCounter: true, Packets: 12, Bytes: 720},
+		},
+		Counters: []Counter{
+			{Family: "inet", Table: "filter", Name: "ssh", Handle: 3, Packets: 9, Bytes: 540},
+		},
+		CtTimeouts: []CtTimeout{
+			{Family: "inet", Table: "filter", Name: "short-tcp", Handle: 8, Protocol: "tcp", L3Proto: "ip", Policy: map[string]uint64{"established": 120, "close": 10}},
+		},
+		CtExpectations: []CtExpectation{
+			{Family: "inet", Table: "filter", Name: "ftp-data", Handle: 9, Protocol: "tcp", L3Proto: "ip", Dport: 2021, Timeout: 12000, Size: 8},
+		},
+	}
+	if diff := deep.Equal(ruleset, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestParseFamilies(t *testing.T) {
+	input := `{"nftables": [
+{"table": {"family": "ip", "name": "filter", "handle": 1}},
+{"table": {"family": "ip6", "name": "filter", "handle": 2}},
+{"table": {"family": "inet", "name": "filter", "handle": 3}},
+{"table": {"family": "arp", "name": "filter", "handle": 4}},
+{"table": {"family": "bridge", "name": "filter", "handle": 5}},
+{"table": {"family": "netdev", "name": "ingress", "handle": 6}},
+{"rule": {"family": "ip", "table": "filter", "chain": "input", "handle": 1, "expr": [{"counter": {"packets": 1, "bytes": 10}}]}},
+{"rule": {"family": "ip6", "table": "filter", "chain": "input", "handle": 1, "expr": [{"counter": {"packets": 2, "bytes": 20}}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 1, "expr": [{"counter": {"packets": 3, "bytes": 30}}]}},
+{"rule": {"family": "arp", "table": "filter", "chain": "input", "handle": 1, "expr": [{"counter": {"packets": 4, "bytes": 40}}]}},
+{"rule": {"family": "bridge", "table": "filter", "chain": "forward", "handle": 1, "expr": [{"counter": {"packets": 5, "bytes": 50}}]}},
+{"rule": {"family": "netdev", "table": "ingress", "chain": "eth0", "handle": 1, "expr": [{"counter": {"packets": 6, "bytes": 60}}, {"drop": null}]}}
+]}`
+	ruleset, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tables of the same name in different families stay apart, and every
+	// rule keeps the family of its table.
+	expected := Ruleset{
+		Tables: []Table{
+			{Family: "ip", Name: "filter", Handle: 1},
+			{Family: "ip6", Name: "filter", Handle: 2},
+			{Family: "inet", Name: "filter", Handle: 3},
+			{Family: "arp", Name: "filter", Handle: 4},
+			{Family: "bridge", Name: "filter", Handle: 5},
+			{Family: "netdev", Name: "ingress", Handle: 6},
+		},
+		Rules: []Rule{
+			{Family: "ip", Table: "filter", Chain: "input", Handle: 1, HasCounter: true, Packets: 1, Bytes: 10},
+			{Family: "ip6", Table: "filter", Chain: "input", Handle: 1, HasCounter: true, Packets: 2, Bytes: 20},
+			{Family: "inet", Table: "filter", Chain: "input", Handle: 1, HasCounter: true, Packets: 3, Bytes: 30},
+			{Family: "arp", Table: "filter", Chain: "input", Handle: 1, HasCounter: true, Packets: 4, Bytes: 40},
+			{Family: "bridge", Table: "filter", Chain: "forward", Handle: 1, HasCounter: true, Packets: 5, Bytes: 50},
+			{Family: "netdev", Table: "ingress", Chain: "eth0", Handle: 1, Verdict: "drop", HasCounter: true, Packets: 6, Bytes: 60},
+		},
+	}
+	if diff := deep.Equal(ruleset, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, input := range []string{
+		`not json`,
+		`{"nftables": [{"rule": {"handle": "x"}}]}`,
+		`{"nftables": [{"rule": {"expr": [{"jump": "input"}]}}]}`,
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("Parse(%q) succeeded, expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/steigr/iptables_exporter/nftables"
+)
+
+func TestNftablesOpenwrtLabels(t *testing.T) {
+	f, err := os.Open("nftables/fw4.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ruleset, err := nftables.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newNftablesCollector(0, true)
+	metricChan := make(chan prometheus.Metric, 100)
+	c.collect(metricChan, ruleset)
+	close(metricChan)
+
+	// zone and section of the packet counter of every rule by handle
+	labels := make(map[string][2]string)
+	for m := range metricChan {
+		if m.Desc() != c.rulePacketsDesc {
+			continue
+		}
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]string)
+		for _, label := range metric.Label {
+			values[label.GetName()] = label.GetValue()
+		}
+		labels[values["table"]+"/"+values["handle"]] = [2]string{values["zone"], values["section"]}
+	}
+	expected := map[string][2]string{
+		"fw4/20": {"", "Handle wan IPv4/IPv6 input traffic"},
+		"fw4/21": {"wan", "Allow-SSH"},
+		"fw4/22": {"wan", ""},
+		"fw4/23": {"wan", "reject wan IPv4/IPv6 input traffic"},
+		"fw4/24": {"lan", "Accept lan to wan forwarding"},
+		"fw4/25": {"wan", "accept wan IPv4/IPv6 traffic"},
+		"fw4/26": {"", "Reject TCP traffic"},
+		"fw4/27": {"wan", "Masquerade IPv4 wan traffic"},
+		"fw4/28": {"", "Zone wan IPv4/IPv6 egress MTU fixing"},
+		// Only the chains of fw4's own table belong to zones.
+		"filter/2": {"", ""},
+	}
+	if mismatch := deep.Equal(expected, labels); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// writeOnce collects every collector of s once and writes the metrics in
+// the text format to output, or to stdout if output is empty or "-". The
+// exporter's own metrics are left out, as they would clash with those of
+// node_exporter when output is in its textfile directory. output is
+// replaced atomically, so the textfile collector never reads it half
+// written.
+func writeOnce(s *collectorSet, output string) error {
+	gatherers, err := s.collectorGatherers(nil)
+	if err != nil {
+		return err
+	}
+	families, err := gatherers.Gather()
+	if err != nil {
+		return err
+	}
+	if output == "" || output == "-" {
+		return writeMetrics(os.Stdout, families)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = writeMetrics(f, families)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// TempFile creates files only the exporter's user may read.
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), output)
+}
+
+func writeMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(w, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onceFlags are the values of the --once and --output flags.
+type onceFlags struct {
+	enabled *bool
+	output  *string
+}
+
+func newOnceFlags(app *kingpin.Application) onceFlags {
+	return onceFlags{
+		enabled: app.Flag("once", "Collect once, write the metrics to --output and exit instead of serving them, e.g. from a timer for the textfile collector of node_exporter.").Bool(),
+		output:  app.Flag("output", "File --once writes the metrics to, replacing it atomically, e.g. /var/lib/node_exporter/textfile/iptables.prom; stdout if empty or -.").String(),
+	}
+}
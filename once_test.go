@@ -0,0 +1,68 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "once")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "iptables.prom")
+	if err := ioutil.WriteFile(output, []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newCollectorSet()
+	chains := prometheus.NewGauge(prometheus.GaugeOpts{Name: "iptables_chains", Help: "Chains."})
+	chains.Set(3)
+	s.registerer("iptables").MustRegister(chains)
+	if err := writeOnce(s, output); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# HELP iptables_chains Chains.\n# TYPE iptables_chains gauge\niptables_chains 3\n"
+	if string(data) != expected {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0644 {
+		t.Errorf("expected mode 0644, got %o", mode)
+	}
+	// The temporary file is renamed into place, leaving nothing behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the output in %s, got %d files", dir, len(files))
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// openwrtLabeler exports the firewall zone of the chains generated by
+// OpenWrt's fw3 as "zone" label, and the name of the configuration section a
+// rule was generated from, given by its "!fw3: <name>" comment, as "section".
+type openwrtLabeler struct{}
+
+func (openwrtLabeler) labelNames() []string {
+	return []string{"zone", "section"}
+}
+
+func (openwrtLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{openwrtZone(chain), openwrtSection(rule.Spec().Comment)}, false
+}
+
+// openwrtZoneSuffixes end the chains fw3 creates per zone, e.g.
+// zone_wan_input.
+var openwrtZoneSuffixes = []string{
+	"_input", "_output", "_forward", "_prerouting", "_postrouting",
+	"_helper", "_notrack",
+}
+
+// openwrtRulePrefixes begin the chains fw3 creates for the user rules of a
+// zone, e.g. input_wan_rule.
+var openwrtRulePrefixes = []string{
+	"input_", "output_", "forwarding_", "prerouting_", "postrouting_",
+}
+
+// openwrtZone returns the zone of a chain generated by fw3, or an empty
+// string for other chains. Besides the suffixes above, zones have chains
+// like zone_wan_src_REJECT and zone_lan_dest_ACCEPT for their policies.
+func openwrtZone(chain string) string {
+	if strings.HasPrefix(chain, "zone_") {
+		name := strings.TrimPrefix(chain, "zone_")
+		for _, kind := range []string{"_src_", "_dest_"} {
+			if i := strings.LastIndex(name, kind); i > 0 && isUpper(name[i+len(kind):]) {
+				return name[:i]
+			}
+		}
+		for _, suffix := range openwrtZoneSuffixes {
+			if zone := strings.TrimSuffix(name, suffix); zone != name && zone != "" {
+				return zone
+			}
+		}
+		return ""
+	}
+	if !strings.HasSuffix(chain, "_rule") {
+		return ""
+	}
+	for _, prefix := range openwrtRulePrefixes {
+		if strings.HasPrefix(chain, prefix) && len(chain) > len(prefix)+len("_rule") {
+			return chain[len(prefix) : len(chain)-len("_rule")]
+		}
+	}
+	return ""
+}
+
+// fw4ZonePrefixes begin the chains fw4 creates per zone in its inet fw4
+// table, e.g. input_wan or reject_from_wan.
+var fw4ZonePrefixes = []string{
+	"input_", "output_", "forward_",
+	"accept_from_", "accept_to_", "drop_from_", "drop_to_", "reject_from_", "reject_to_",
+	"dstnat_", "srcnat_", "helper_", "notrack_",
+}
+
+// fw4Zone returns the zone of a chain of the nftables table fw4, OpenWrt's
+// successor of fw3, generates, or an empty string for other chains.
+func fw4Zone(family, table, chain string) string {
+	if family != "inet" || table != "fw4" {
+		return ""
+	}
+	for _, prefix := range fw4ZonePrefixes {
+		if zone := strings.TrimPrefix(chain, prefix); zone != chain && zone != "" {
+			return zone
+		}
+	}
+	return ""
+}
+
+// openwrtSection returns the section name of a "!fw3: <name>" comment of an
+// iptables rule, or of the "!fw4: <name>" comment fw4 gives nftables rules.
+func openwrtSection(comment string) string {
+	for _, prefix := range []string{"!fw3: ", "!fw4: "} {
+		if strings.HasPrefix(comment, prefix) {
+			return strings.TrimPrefix(comment, prefix)
+		}
+	}
+	return ""
+}
+
+func isUpper(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
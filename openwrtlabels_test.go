@@ -0,0 +1,45 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestOpenwrtLabeler(t *testing.T) {
+	testLabeler(t, openwrtLabeler{}, []labelerCase{
+		{chain: "zone_wan_input", text: `-m comment --comment "!fw3: Allow-SSH" -j ACCEPT`, values: []string{"wan", "Allow-SSH"}},
+		{chain: "INPUT", text: `-j ACCEPT`, values: []string{"", ""}},
+	})
+}
+
+func TestFw4Zone(t *testing.T) {
+	for _, tc := range []struct {
+		family, table, chain, zone string
+	}{
+		{"inet", "fw4", "input_wan", "wan"},
+		{"inet", "fw4", "forward_lan", "lan"},
+		{"inet", "fw4", "accept_to_wan", "wan"},
+		{"inet", "fw4", "reject_from_guest_net", "guest_net"},
+		{"inet", "fw4", "srcnat_wan", "wan"},
+		{"inet", "fw4", "input", ""},
+		{"inet", "fw4", "handle_reject", ""},
+		{"inet", "fw4", "mangle_forward", ""},
+		{"inet", "filter", "input_wan", ""},
+		{"ip", "fw4", "input_wan", ""},
+	} {
+		if zone := fw4Zone(tc.family, tc.table, tc.chain); zone != tc.zone {
+			t.Fatalf("%s %s %s: expected zone %q, got %q", tc.family, tc.table, tc.chain, tc.zone, zone)
+		}
+	}
+}
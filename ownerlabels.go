@@ -0,0 +1,113 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ownerNameTTL is how long resolved user and group names are cached.
+const ownerNameTTL = 5 * time.Minute
+
+// ownerLabeler exports the user and group matched by -m owner as
+// "owner_user" and "owner_group" labels, resolving numeric IDs to names.
+type ownerLabeler struct {
+	users  *nameCache
+	groups *nameCache
+}
+
+func newOwnerLabeler() *ownerLabeler {
+	return &ownerLabeler{
+		users: newNameCache(func(id string) (string, error) {
+			u, err := user.LookupId(id)
+			if err != nil {
+				return "", err
+			}
+			return u.Username, nil
+		}),
+		groups: newNameCache(func(id string) (string, error) {
+			g, err := user.LookupGroupId(id)
+			if err != nil {
+				return "", err
+			}
+			return g.Name, nil
+		}),
+	}
+}
+
+func (l *ownerLabeler) labelNames() []string {
+	return []string{"owner_user", "owner_group"}
+}
+
+func (l *ownerLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	return []string{
+		l.resolve(spec, "--uid-owner", l.users),
+		l.resolve(spec, "--gid-owner", l.groups),
+	}, false
+}
+
+func (l *ownerLabeler) resolve(spec iptables.RuleSpec, flag string, cache *nameCache) string {
+	option, ok := spec.MatchOption("owner", flag)
+	if !ok || len(option.Values) == 0 {
+		return ""
+	}
+	name := cache.name(option.Values[0])
+	if option.Negated {
+		return "!" + name
+	}
+	return name
+}
+
+// nameCache caches the names of numeric IDs. IDs that can't be resolved,
+// such as ranges or unknown IDs, are returned unchanged.
+type nameCache struct {
+	lookup func(id string) (string, error)
+
+	mu      sync.Mutex
+	entries map[string]nameCacheEntry
+}
+
+type nameCacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+func newNameCache(lookup func(id string) (string, error)) *nameCache {
+	return &nameCache{lookup: lookup, entries: make(map[string]nameCacheEntry)}
+}
+
+func (c *nameCache) name(id string) string {
+	if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+		return id
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[id]; ok && now.Before(entry.expires) {
+		return entry.name
+	}
+	name, err := c.lookup(id)
+	if err != nil {
+		name = id
+	}
+	c.entries[id] = nameCacheEntry{name: name, expires: now.Add(ownerNameTTL)}
+	return name
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// interpretedMatches are the match extensions whose options the exporter
+// interprets, for labels or other metrics.
+var interpretedMatches = map[string]bool{
+	"tcp": true, "udp": true, "multiport": true, "comment": true,
+	"mark": true, "connmark": true, "cgroup": true, "owner": true,
+	"set": true, "iprange": true, "conntrack": true, "state": true,
+	"limit": true, "hashlimit": true, "time": true, "quota": true,
+	"physdev": true,
+}
+
+// interpretedTargets are the target extensions whose options the exporter
+// interprets.
+var interpretedTargets = map[string]bool{
+	"ACCEPT": true, "DROP": true, "RETURN": true, "REJECT": true,
+	"LOG": true, "NFLOG": true, "MARK": true, "CONNMARK": true,
+	"TPROXY": true, "MASQUERADE": true,
+}
+
+type parseCheck struct {
+	File       string           `json:"file"`
+	Lines      int              `json:"lines"`
+	Rules      int              `json:"rules"`
+	Uncovered  int              `json:"uncovered_lines"`
+	Coverage   float64          `json:"coverage"`
+	Extensions map[string]int   `json:"uninterpreted_extensions"`
+	Issues     []parseCheckLine `json:"issues"`
+}
+
+type parseCheckLine struct {
+	Line    int    `json:"line"`
+	Table   string `json:"table,omitempty"`
+	Chain   string `json:"chain,omitempty"`
+	Message string `json:"message"`
+	Text    string `json:"text"`
+}
+
+// checkParse parses an iptables-save dump strictly, reporting the lines
+// that are malformed and the rules using match extensions or targets the
+// exporter doesn't interpret. Such rules are still exported, but only their
+// counters and text are taken into account.
+func checkParse(name string, r io.Reader) (*parseCheck, error) {
+	result := &parseCheck{File: name, Extensions: map[string]int{}, Issues: []parseCheckLine{}}
+	type rule struct {
+		line         int
+		table, chain string
+		rule         iptables.Rule
+	}
+	var rules []rule
+	tables, stats, errors, err := iptables.CheckIptablesSave(r, func(line int, table, chain string, r iptables.Rule) {
+		rules = append(rules, rule{line, table, chain, r})
+	})
+	if err != nil {
+		return nil, err
+	}
+	uncovered := make(map[int]bool)
+	for _, e := range errors {
+		uncovered[e.LineNumber] = true
+		result.Issues = append(result.Issues, parseCheckLine{Line: e.LineNumber, Message: e.Message, Text: e.LineText})
+	}
+	for _, r := range rules {
+		spec := r.rule.Spec()
+		var messages []string
+		for _, m := range spec.Matches {
+			if !interpretedMatches[m] {
+				result.Extensions["match "+m]++
+				messages = append(messages, "uninterpreted match "+m)
+			}
+		}
+		switch target := spec.Target; {
+		case target == "" || interpretedTargets[target]:
+		case iptables.IsTargetExtension(target):
+			result.Extensions["target "+target]++
+			messages = append(messages, "uninterpreted target "+target)
+		case !isChain(tables, r.table, target):
+			messages = append(messages, "undefined chain "+target)
+		}
+		for _, m := range messages {
+			uncovered[r.line] = true
+			result.Issues = append(result.Issues, parseCheckLine{
+				Line:    r.line,
+				Table:   r.table,
+				Chain:   r.chain,
+				Message: m,
+				Text:    r.rule.Text,
+			})
+		}
+	}
+	sort.SliceStable(result.Issues, func(i, j int) bool {
+		return result.Issues[i].Line < result.Issues[j].Line
+	})
+	result.Lines = stats.Lines
+	result.Rules = stats.Rules
+	result.Uncovered = len(uncovered)
+	result.Coverage = 1
+	if stats.Lines > 0 {
+		result.Coverage = float64(stats.Lines-len(uncovered)) / float64(stats.Lines)
+	}
+	return result, nil
+}
+
+func isChain(tables iptables.Tables, table, chain string) bool {
+	_, ok := tables[table][chain]
+	return ok
+}
+
+// runParseCheck checks the dump in the named file, or stdin if empty.
+func runParseCheck(name string) (*parseCheck, error) {
+	if name == "" {
+		return checkParse("-", os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening dump: %s", err)
+	}
+	defer f.Close()
+	return checkParse(name, f)
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// physdevLabeler exports the bridge ports matched by -m physdev
+// --physdev-in and --physdev-out as "physdev_in" and "physdev_out" labels.
+type physdevLabeler struct{}
+
+func (physdevLabeler) labelNames() []string {
+	return []string{"physdev_in", "physdev_out"}
+}
+
+func (physdevLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	values := make([]string, 2)
+	for i, flag := range []string{"--physdev-in", "--physdev-out"} {
+		if option, ok := spec.MatchOption("physdev", flag); ok {
+			values[i] = option.Value()
+		}
+	}
+	return values, false
+}
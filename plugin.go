@@ -0,0 +1,70 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/steigr/iptables_exporter/iptables"
+	"github.com/steigr/iptables_exporter/plugins"
+)
+
+// pluginLabeler adapts a plugins.Labeler to a ruleLabeler.
+type pluginLabeler struct {
+	labeler plugins.Labeler
+	names   []string
+}
+
+func loadPlugin(path string) (*pluginLabeler, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(plugins.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	labeler, ok := sym.(plugins.Labeler)
+	if !ok {
+		return nil, fmt.Errorf("%s: %s (%T) does not implement plugins.Labeler", path, plugins.Symbol, sym)
+	}
+	names := labeler.LabelNames()
+	if err := checkLabelNames(names); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, name := range names {
+		if reservedLabels[name] {
+			return nil, fmt.Errorf("%s: label %q is the exporter's own", path, name)
+		}
+	}
+	return &pluginLabeler{labeler: labeler, names: names}, nil
+}
+
+func (l *pluginLabeler) labelNames() []string {
+	return l.names
+}
+
+func (l *pluginLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	labels, skip := l.labeler.Labels(table, chain, rule)
+	if skip {
+		return nil, true
+	}
+	values := make([]string, len(l.names))
+	for i, name := range l.names {
+		values[i] = labels[name]
+	}
+	return values, false
+}
@@ -0,0 +1,42 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plugins defines the interface of iptables_exporter plugins.
+//
+// A plugin is a Go plugin (built with -buildmode=plugin) exporting a
+// variable named Labeler whose type implements the Labeler interface:
+//
+//	package main
+//
+//	var Labeler myLabeler
+//
+// Plugins have to be built with the same Go version and the same versions
+// of the packages they share with the exporter.
+package plugins
+
+import "github.com/steigr/iptables_exporter/iptables"
+
+// Symbol is the name of the variable looked up in a plugin.
+const Symbol = "Labeler"
+
+// Labeler computes site-specific labels for rule metrics.
+type Labeler interface {
+	// LabelNames returns the names of all labels the labeler may set. It is
+	// called once when the plugin is loaded.
+	LabelNames() []string
+	// Labels returns label values for a rule of the given table and chain.
+	// Labels missing from the result are exported empty. If skip is true,
+	// the rule is not exported at all.
+	Labels(table, chain string, rule iptables.Rule) (labels map[string]string, skip bool)
+}
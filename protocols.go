@@ -0,0 +1,86 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	protocolPacketsDesc = prometheus.NewDesc(
+		"iptables_protocol_packets_total",
+		"iptables_exporter: Total packets matching the rules of a chain, per protocol matched with -p.",
+		[]string{"table", "chain", "protocol", "ip_family"},
+		nil,
+	)
+
+	protocolBytesDesc = prometheus.NewDesc(
+		"iptables_protocol_bytes_total",
+		"iptables_exporter: Total bytes matching the rules of a chain, per protocol matched with -p.",
+		[]string{"table", "chain", "protocol", "ip_family"},
+		nil,
+	)
+)
+
+// ruleProtocols maps the -p values iptables-save prints, by name or number,
+// to the protocol label. ICMPv6 is icmp, the ip_family label tells them
+// apart.
+var ruleProtocols = map[string]string{
+	"tcp":       "tcp",
+	"6":         "tcp",
+	"udp":       "udp",
+	"17":        "udp",
+	"icmp":      "icmp",
+	"1":         "icmp",
+	"ipv6-icmp": "icmp",
+	"icmpv6":    "icmp",
+	"58":        "icmp",
+}
+
+// ruleProtocol returns the protocol label of a rule: tcp, udp or icmp, all
+// for rules without -p, and other for other and negated protocols.
+func ruleProtocol(spec iptables.RuleSpec) string {
+	if spec.Proto == "" || spec.Proto == "all" || spec.Proto == "0" {
+		return "all"
+	}
+	if protocol, ok := ruleProtocols[spec.Proto]; ok {
+		return protocol
+	}
+	return "other"
+}
+
+// collectProtocols exports the counters of the rules of every chain summed
+// up per protocol, a view of the traffic without a series per rule.
+func (c *collector) collectProtocols(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	if !c.protocols {
+		return
+	}
+	for tableName, table := range tables {
+		for chainName, chain := range table {
+			packets := make(map[string]uint64)
+			bytes := make(map[string]uint64)
+			for _, rule := range chain.Rules {
+				protocol := ruleProtocol(rule.Spec())
+				packets[protocol] += rule.Packets
+				bytes[protocol] += rule.Bytes
+			}
+			for protocol, count := range packets {
+				metricChan <- prometheus.MustNewConstMetric(protocolPacketsDesc, prometheus.CounterValue, float64(count), tableName, chainName, protocol, string(family))
+				metricChan <- prometheus.MustNewConstMetric(protocolBytesDesc, prometheus.CounterValue, float64(bytes[protocol]), tableName, chainName, protocol, string(family))
+			}
+		}
+	}
+}
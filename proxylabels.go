@@ -0,0 +1,44 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// proxyLabeler exports the port transparent proxy rules hand packets to,
+// given by --on-port of TPROXY and --to-ports of REDIRECT, as "proxy_port"
+// label.
+type proxyLabeler struct{}
+
+func (proxyLabeler) labelNames() []string {
+	return []string{"proxy_port"}
+}
+
+func (proxyLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{ruleProxyPort(rule.Spec())}, false
+}
+
+// ruleProxyPort returns the listener port of a TPROXY or REDIRECT rule, or
+// the port range REDIRECT spreads packets over, e.g. 8080-8090.
+func ruleProxyPort(spec iptables.RuleSpec) string {
+	if option, ok := spec.MatchOption("TPROXY", "--on-port"); ok && len(option.Values) == 1 {
+		return option.Values[0]
+	}
+	if option, ok := spec.MatchOption("REDIRECT", "--to-ports"); ok && len(option.Values) == 1 {
+		return option.Values[0]
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestProxyLabeler(t *testing.T) {
+	testLabeler(t, proxyLabeler{}, []labelerCase{
+		{text: `-p tcp -j TPROXY --on-port 3128 --on-ip 0.0.0.0 --tproxy-mark 0x1/0x1`, values: []string{"3128"}},
+		{text: `-p tcp -j REDIRECT --to-ports 8080-8090`, values: []string{"8080-8090"}},
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ruleQuota returns the byte quota configured with -m quota --quota for
+// rule. Negated quotas, matching only once the quota is used up, don't
+// count.
+func ruleQuota(rule iptables.Rule) (float64, bool) {
+	if !strings.Contains(rule.Text, "--quota") {
+		return 0, false
+	}
+	o, ok := rule.Spec().MatchOption("quota", "--quota")
+	if !ok || o.Negated || len(o.Values) != 1 {
+		return 0, false
+	}
+	quota, err := strconv.ParseUint(o.Values[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return float64(quota), true
+}
+
+// quotaRemaining returns how many of the quota bytes of a rule are left
+// after matching bytes.
+func quotaRemaining(quota, bytes float64) float64 {
+	if bytes >= quota {
+		return 0
+	}
+	return quota - bytes
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// rateBuckets are the upper bounds of the packet rates, in packets per
+// second, rateHistogram sorts rules into.
+var rateBuckets = []float64{0.1, 1, 10, 100, 1e3, 1e4, 1e5, 1e6}
+
+// rateHistogram observes the packet rate of every rule between two
+// collections, a heavy-hitters signal without a series per rule.
+type rateHistogram struct {
+	histogram *prometheus.HistogramVec
+
+	mu   sync.Mutex
+	last time.Time
+	// packets are the counters of the last collection by rule.
+	packets map[string]uint64
+}
+
+func newRateHistogram() *rateHistogram {
+	return &rateHistogram{
+		histogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "iptables_rule_packets_per_second",
+			Help:    "iptables_exporter: Packet rates of the rules between two collections, one observation per rule and collection.",
+			Buckets: rateBuckets,
+		}, []string{"ip_family"}),
+	}
+}
+
+func (h *rateHistogram) observe(now time.Time, families map[iptables.Family]iptables.Tables) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seconds := now.Sub(h.last).Seconds()
+	packets := make(map[string]uint64)
+	for family, tables := range families {
+		observer := h.histogram.WithLabelValues(string(family))
+		for tableName, table := range tables {
+			for chainName, chain := range table {
+				seen := make(map[string]int)
+				for _, rule := range chain.Rules {
+					// Identical rules of a chain are told apart by their
+					// occurrence.
+					seen[rule.Text]++
+					key := string(family) + "\x00" + tableName + "\x00" + chainName + "\x00" + rule.Text + "\x00" + strconv.Itoa(seen[rule.Text])
+					packets[key] = rule.Packets
+					previous, ok := h.packets[key]
+					// New rules have no rate yet, and rules whose counters
+					// were reset none to speak of.
+					if !ok || rule.Packets < previous || seconds <= 0 {
+						continue
+					}
+					observer.Observe(float64(rule.Packets-previous) / seconds)
+				}
+			}
+		}
+	}
+	h.last = now
+	h.packets = packets
+}
+
+func (h *rateHistogram) Describe(descChan chan<- *prometheus.Desc) {
+	h.histogram.Describe(descChan)
+}
+
+func (h *rateHistogram) Collect(metricChan chan<- prometheus.Metric) {
+	h.histogram.Collect(metricChan)
+}
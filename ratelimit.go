@@ -0,0 +1,196 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// tokenBucket is a minimal token bucket refilled at rate tokens per second
+// up to burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// available returns whether a token is available without consuming it, and
+// if not how long the caller has to wait until the next one is.
+func (b *tokenBucket) available(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return true, 0
+	}
+	wait := (1 - b.tokens) / b.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// take consumes a token if one is available. Otherwise it returns how long
+// the caller has to wait until the next token becomes available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	ok, wait := b.available(now)
+	if ok {
+		b.tokens--
+	}
+	return ok, wait
+}
+
+type rateLimiter struct {
+	mu sync.Mutex
+
+	global *tokenBucket
+
+	clientRate  float64
+	clientBurst int
+	clients     map[string]*tokenBucket
+	lastSweep   time.Time
+}
+
+// newRateLimiter returns a limiter allowing globalRate requests per second
+// in total and clientRate requests per second per client address. A rate of
+// zero disables the respective limit.
+func newRateLimiter(globalRate float64, globalBurst int, clientRate float64, clientBurst int) *rateLimiter {
+	now := time.Now()
+	l := &rateLimiter{
+		clientRate:  clientRate,
+		clientBurst: clientBurst,
+		clients:     make(map[string]*tokenBucket),
+		lastSweep:   now,
+	}
+	if globalRate > 0 {
+		l.global = newTokenBucket(globalRate, globalBurst, now)
+	}
+	return l
+}
+
+// allow returns whether a request of client is within the limits, taking a
+// token from both the client's and the global bucket if so. Requests
+// refused by the global limit don't use up the client's tokens.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allowAt(client, time.Now())
+}
+
+func (l *rateLimiter) allowAt(client string, now time.Time) (bool, time.Duration) {
+	var bucket *tokenBucket
+	if l.clientRate > 0 {
+		l.sweep(now)
+		var ok bool
+		bucket, ok = l.clients[client]
+		if !ok {
+			bucket = newTokenBucket(l.clientRate, l.clientBurst, now)
+			l.clients[client] = bucket
+		}
+		if ok, wait := bucket.available(now); !ok {
+			return false, wait
+		}
+	}
+	if l.global != nil {
+		if ok, wait := l.global.take(now); !ok {
+			return false, wait
+		}
+	}
+	if bucket != nil {
+		bucket.take(now)
+	}
+	return true, 0
+}
+
+// sweep forgets clients whose bucket has refilled completely, so the map
+// doesn't grow with every address that ever scraped us.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, bucket := range l.clients {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.burst {
+			delete(l.clients, client)
+		}
+	}
+}
+
+func (l *rateLimiter) enabled() bool {
+	return l.global != nil || l.clientRate > 0
+}
+
+// limitRate answers requests exceeding the limits of l with 429 Too Many
+// Requests and a Retry-After header instead of passing them to next.
+func limitRate(l *rateLimiter, next http.Handler) http.Handler {
+	if !l.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := r.RemoteAddr
+		if ip := clientIP(r); ip != nil {
+			client = ip.String()
+		}
+		if ok, wait := l.allow(client); !ok {
+			log.Debugf("Rate limiting request from %s", r.RemoteAddr)
+			deniedRequests.WithLabelValues("rate_limit").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitFlags are the values of the --web.rate-limit* and
+// --web.client-rate-limit* flags.
+type rateLimitFlags struct {
+	rate, clientRate   *float64
+	burst, clientBurst *int
+}
+
+func newRateLimitFlags(app *kingpin.Application) rateLimitFlags {
+	return rateLimitFlags{
+		rate:        app.Flag("web.rate-limit", "Maximum number of scrapes per second across all clients (0 disables the limit).").Default("0").Float64(),
+		burst:       app.Flag("web.rate-limit-burst", "Number of scrapes allowed to exceed --web.rate-limit in a burst.").Default("5").Int(),
+		clientRate:  app.Flag("web.client-rate-limit", "Maximum number of scrapes per second from a single client address (0 disables the limit).").Default("0").Float64(),
+		clientBurst: app.Flag("web.client-rate-limit-burst", "Number of scrapes a single client may make in excess of --web.client-rate-limit in a burst.").Default("2").Int(),
+	}
+}
+
+func (f rateLimitFlags) limiter() *rateLimiter {
+	return newRateLimiter(*f.rate, *f.burst, *f.clientRate, *f.clientBurst)
+}
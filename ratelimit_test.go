@@ -0,0 +1,84 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	type request struct {
+		client string
+		after  time.Duration
+		ok     bool
+		wait   time.Duration
+	}
+	for _, tc := range []struct {
+		name                     string
+		globalRate, clientRate   float64
+		globalBurst, clientBurst int
+		requests                 []request
+	}{
+		{
+			name:       "global",
+			globalRate: 2, globalBurst: 2,
+			requests: []request{
+				{client: "a", ok: true},
+				{client: "b", ok: true},
+				{client: "a", wait: 500 * time.Millisecond},
+				{client: "b", after: 500 * time.Millisecond, ok: true},
+			},
+		},
+		{
+			name:       "per client",
+			clientRate: 1, clientBurst: 2,
+			requests: []request{
+				{client: "a", ok: true},
+				{client: "a", ok: true},
+				{client: "a", wait: time.Second},
+				{client: "b", ok: true},
+				{client: "a", after: time.Second, ok: true},
+			},
+		},
+		{
+			// Requests the global limit refuses leave the client's tokens
+			// alone, so a client isn't locked out by others' scrapes.
+			name:       "global before client",
+			globalRate: 1, globalBurst: 1,
+			clientRate: 0.1, clientBurst: 2,
+			requests: []request{
+				{client: "b", ok: true},
+				{client: "a", wait: time.Second},
+				{client: "a", wait: time.Second},
+				{client: "a", after: time.Second, ok: true},
+				{client: "a", after: 2 * time.Second, ok: true},
+			},
+		},
+	} {
+		l := newRateLimiter(tc.globalRate, tc.globalBurst, tc.clientRate, tc.clientBurst)
+		now := time.Now()
+		for i, r := range tc.requests {
+			now = now.Add(r.after)
+			ok, wait := l.allowAt(r.client, now)
+			if ok != r.ok {
+				t.Fatalf("%s: request %d: expected %v, got %v", tc.name, i, r.ok, ok)
+			}
+			if d := wait - r.wait; d < -time.Millisecond || d > time.Millisecond {
+				t.Fatalf("%s: request %d: expected to wait %s, got %s", tc.name, i, r.wait, wait)
+			}
+		}
+	}
+}
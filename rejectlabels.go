@@ -0,0 +1,40 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// rejectLabeler exports the type of REJECT rules, given by --reject-with, as
+// "reject_with" label.
+type rejectLabeler struct{}
+
+func (rejectLabeler) labelNames() []string {
+	return []string{"reject_with"}
+}
+
+func (rejectLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{ruleRejectWith(rule.Spec())}, false
+}
+
+// ruleRejectWith returns how a REJECT rule rejects packets, e.g. tcp-reset.
+// The save commands always write --reject-with, even for the default.
+func ruleRejectWith(spec iptables.RuleSpec) string {
+	if option, ok := spec.MatchOption("REJECT", "--reject-with"); ok && len(option.Values) == 1 {
+		return option.Values[0]
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ruleTemplateData is what --iptables.rule-template is executed with: the
+// fields of the parsed rule plus its location and the rule it was parsed
+// from.
+type ruleTemplateData struct {
+	iptables.RuleSpec
+	Table   string
+	Chain   string
+	Text    string
+	Label   string
+	Packets uint64
+	Bytes   uint64
+}
+
+func parseRuleTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("rule").Option("missingkey=zero").Parse(text)
+}
+
+func renderRuleTemplate(tmpl *template.Template, table, chain string, rule iptables.Rule) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, ruleTemplateData{
+		RuleSpec: rule.Spec(),
+		Table:    table,
+		Chain:    chain,
+		Text:     rule.Text,
+		Label:    rule.Rule,
+		Packets:  rule.Packets,
+		Bytes:    rule.Bytes,
+	})
+	return strings.TrimSpace(b.String()), err
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sanitizedLabelValues = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "iptables_exporter_label_values_sanitized_total",
+		Help: "iptables_exporter: Total label values taken from rules that contained invalid UTF-8 or control characters.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(sanitizedLabelValues)
+}
+
+// sanitizeLabelValue makes a label value taken from a rule, e.g. from a
+// comment, safe to export: invalid UTF-8 is replaced with U+FFFD and
+// control characters are escaped like in Go strings. Label values that
+// aren't valid UTF-8 would otherwise fail the whole scrape.
+func sanitizeLabelValue(value string) string {
+	clean := true
+	for _, r := range value {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return value
+	}
+	sanitizedLabelValues.Inc()
+	var b strings.Builder
+	for _, r := range strings.ToValidUTF8(value, string(utf8.RuneError)) {
+		switch {
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
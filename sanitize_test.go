@@ -0,0 +1,46 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSanitizeLabelValue(t *testing.T) {
+	for _, tc := range []struct {
+		value, expected string
+		sanitized       bool
+	}{
+		{value: "ssh from office", expected: "ssh from office"},
+		{value: "café → dmz", expected: "café → dmz"},
+		{value: "", expected: ""},
+		{value: "line\nbreak", expected: `line\nbreak`, sanitized: true},
+		{value: "tab\tand\rreturn", expected: `tab\tand\rreturn`, sanitized: true},
+		{value: "bell\x07 del\x7f", expected: `bell\x07 del\x7f`, sanitized: true},
+		{value: "latin1 caf\xe9", expected: "latin1 caf�", sanitized: true},
+		{value: "truncated \xe2\x82", expected: "truncated �", sanitized: true},
+	} {
+		before := testutil.ToFloat64(sanitizedLabelValues)
+		if actual := sanitizeLabelValue(tc.value); actual != tc.expected {
+			t.Fatalf("%q: expected %q, got %q", tc.value, tc.expected, actual)
+		}
+		counted := testutil.ToFloat64(sanitizedLabelValues) - before
+		if (counted == 1) != tc.sanitized || counted > 1 {
+			t.Fatalf("%q: expected sanitized %v, counted %v", tc.value, tc.sanitized, counted)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	savedDriftDesc = prometheus.NewDesc(
+		"iptables_saved_ruleset_drift_rules",
+		"iptables_exporter: Number of rules only found in either the saved or the running ruleset.",
+		[]string{"ip_family"},
+		nil,
+	)
+
+	savedReadableDesc = prometheus.NewDesc(
+		"iptables_saved_ruleset_readable",
+		"iptables_exporter: Whether the saved ruleset could be read and parsed.",
+		[]string{"ip_family"},
+		nil,
+	)
+)
+
+var matchAllRules = regexp.MustCompile(".*")
+
+// readSavedRules parses the ruleset saved in path like parseSavedRules.
+func (c *collector) readSavedRules(path string) (iptables.Tables, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.parseSavedRules(f)
+}
+
+// parseSavedRules parses a saved ruleset the way running rulesets are
+// collected, so rules left out by the capture regexps, the chain selection
+// or the table and chain filter are left out of both.
+func (c *collector) parseSavedRules(r io.Reader) (iptables.Tables, error) {
+	postCapture := c.captures != nil || c.unmatched != unmatchedSkip
+	capture := c.capture
+	if postCapture {
+		capture = matchAllRules
+	}
+	tables, _, err := iptables.ParseIptablesSaveChains(r, capture, c.chains)
+	if err != nil {
+		return nil, err
+	}
+	if c.filter != nil {
+		c.filter.Apply(tables)
+	}
+	if postCapture {
+		var stats iptables.ParseStats
+		c.captures.apply(tables, c.capture, c.unmatched, &stats)
+	}
+	return tables, nil
+}
+
+// collectSavedDrift compares the running ruleset of family with the one
+// saved to be restored at boot, exporting the number of rules that differ.
+// Only the tables of the saved ruleset are compared, and rules that only
+// moved within their chain don't count.
+func (c *collector) collectSavedDrift(metricChan chan<- prometheus.Metric, family iptables.Family, running iptables.Tables) {
+	path, ok := c.savedRules[family]
+	if !ok {
+		return
+	}
+	saved, err := c.readSavedRules(path)
+	if err != nil {
+		log.Errorf("Reading saved %s ruleset %s: %s", family, path, err)
+		metricChan <- prometheus.MustNewConstMetric(savedReadableDesc, prometheus.GaugeValue, 0, string(family))
+		return
+	}
+	metricChan <- prometheus.MustNewConstMetric(savedReadableDesc, prometheus.GaugeValue, 1, string(family))
+	compared := make(iptables.Tables)
+	for name := range saved {
+		if table, ok := running[name]; ok {
+			compared[name] = table
+		}
+	}
+	drift := driftRules(iptables.Diff(saved, compared))
+	metricChan <- prometheus.MustNewConstMetric(savedDriftDesc, prometheus.GaugeValue, float64(drift), string(family))
+}
+
+// driftRules returns the number of rules only found in one of the rulesets
+// diffs compares.
+func driftRules(diffs []iptables.ChainDiff) int {
+	drift := 0
+	for _, d := range diffs {
+		drift += len(d.AddedRules) + len(d.RemovedRules)
+	}
+	return drift
+}
+
+func newSavedRulesFlags(app *kingpin.Application) familyFileFlags {
+	return familyFileFlags{
+		v4: app.Flag("iptables.saved-rules", "File holding the saved IPv4 ruleset restored at boot, e.g. /etc/iptables/rules.v4, to export how many rules differ from the running ruleset.").String(),
+		v6: app.Flag("iptables.saved-rules-v6", "File holding the saved IPv6 ruleset restored at boot, e.g. /etc/iptables/rules.v6.").String(),
+	}
+}
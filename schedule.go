@@ -0,0 +1,43 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// ruleSchedule returns the schedule configured by the time match of rule.
+func ruleSchedule(rule iptables.Rule) (iptables.TimeMatch, bool) {
+	if !strings.Contains(rule.Text, "time") {
+		return iptables.TimeMatch{}, false
+	}
+	return rule.Spec().TimeMatch()
+}
+
+// scheduleLabels appends the time_start, time_stop, time_weekdays and
+// time_monthdays label values of m to labels.
+func scheduleLabels(labels []string, m iptables.TimeMatch) []string {
+	result := make([]string, 0, len(labels)+4)
+	result = append(result, labels...)
+	return append(result, formatDayTime(m.Start), formatDayTime(m.Stop), m.Weekdays, m.Monthdays)
+}
+
+func formatDayTime(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute), int(d%time.Minute/time.Second))
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeTarget is a target that can be scraped on its own at /probe.
+type probeTarget struct {
+	name string
+	// kind is local, ssh or agent, or lxc or netns for discovered targets.
+	kind      string
+	collector *collector
+}
+
+// sdTargetGroup is an entry of the Prometheus HTTP service discovery
+// response.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// sdHandler answers /sd with a Prometheus HTTP service discovery target
+// group per probe target, pointing at its /probe URL on this exporter.
+func sdHandler(targets []probeTarget) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		groups := make([]sdTargetGroup, 0, len(targets))
+		for _, t := range targets {
+			groups = append(groups, sdTargetGroup{
+				Targets: []string{r.Host},
+				Labels: map[string]string{
+					"__scheme__":                      scheme,
+					"__metrics_path__":                "/probe",
+					"__param_target":                  t.name,
+					"__meta_iptables_exporter_target": t.name,
+					"__meta_iptables_exporter_kind":   t.kind,
+				},
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(groups)
+	}
+}
+
+// probeHandler answers /probe with the metrics of the probe target given
+// by the target parameter, without the instance label /metrics adds.
+func probeHandler(targets []probeTarget) http.HandlerFunc {
+	handlers := make(map[string]http.Handler, len(targets))
+	for _, t := range targets {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(t.collector)
+		handlers[t.name] = promhttp.HandlerFor(registry, promhttp.HandlerOpts{DisableCompression: true})
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		if name == "" {
+			name = localTarget
+		}
+		h, ok := handlers[name]
+		if !ok {
+			http.Error(w, "unknown target "+strconv.Quote(name), http.StatusNotFound)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
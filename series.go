@@ -0,0 +1,70 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var (
+	exporterSeriesDesc = prometheus.NewDesc(
+		"iptables_exporter_series",
+		"iptables_exporter: Number of series exported by the collection, this one included.",
+		nil,
+		nil,
+	)
+
+	// rulesExportedDesc names the family label ip_family rather than
+	// family, so it joins with the rule metrics it counts.
+	rulesExportedDesc = prometheus.NewDesc(
+		"iptables_rules_exported",
+		"iptables_exporter: Number of rule series exported, after capturing, filtering and merging rules.",
+		[]string{"table", "ip_family"},
+		nil,
+	)
+)
+
+// countSeries passes the metrics sent by collect on to metricChan and ends
+// them with the number of series sent, so the cardinality of the exporter
+// can be watched as its configuration changes.
+func countSeries(metricChan chan<- prometheus.Metric, collect func(chan<- prometheus.Metric)) {
+	counted := make(chan prometheus.Metric)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for m := range counted {
+			metricChan <- m
+			n++
+		}
+		done <- n
+	}()
+	collect(counted)
+	close(counted)
+	metricChan <- prometheus.MustNewConstMetric(exporterSeriesDesc, prometheus.GaugeValue, float64(<-done+1))
+}
+
+// collectRulesExported exports the number of rule series of every table.
+func collectRulesExported(metricChan chan<- prometheus.Metric, rules map[iptables.Family]ruleCounter) {
+	for family, counters := range rules {
+		tables := make(map[string]int)
+		for _, ruleData := range counters {
+			tables[ruleData.labels[0]]++
+		}
+		for table, count := range tables {
+			metricChan <- prometheus.MustNewConstMetric(rulesExportedDesc, prometheus.GaugeValue, float64(count), table, string(family))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// serviceLabeler exports the name of the port matched by a rule, as found
+// in /etc/services, as "service" label.
+type serviceLabeler struct {
+	// services maps "port/proto" to service names
+	services map[string]string
+}
+
+func newServiceLabeler(path string) (*serviceLabeler, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	services, err := parseServices(f)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceLabeler{services: services}, nil
+}
+
+// parseServices reads services(5) lines like "https 443/tcp # comment".
+// The first name listed for a port wins.
+func parseServices(r io.Reader) (map[string]string, error) {
+	services := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if _, ok := services[fields[1]]; !ok {
+			services[fields[1]] = fields[0]
+		}
+	}
+	return services, scanner.Err()
+}
+
+func (l *serviceLabeler) labelNames() []string {
+	return []string{"service"}
+}
+
+func (l *serviceLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	spec := rule.Spec()
+	ports := spec.DPort
+	if ports == "" {
+		ports = spec.SPort
+	}
+	if ports == "" || spec.Proto == "" {
+		return []string{""}, false
+	}
+	prefix := ""
+	if strings.HasPrefix(ports, "!") {
+		prefix = "!"
+		ports = ports[1:]
+	}
+	var names []string
+	for _, port := range strings.Split(ports, ",") {
+		name, ok := l.services[port+"/"+spec.Proto]
+		if !ok {
+			// Unknown ports and ranges can't be named
+			return []string{""}, false
+		}
+		names = append(names, name)
+	}
+	return []string{prefix + strings.Join(names, ",")}, false
+}
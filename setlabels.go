@@ -0,0 +1,60 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// setLabeler exports the IP set matched by -m set --match-set as "set"
+// label.
+type setLabeler struct{}
+
+func (setLabeler) labelNames() []string {
+	return []string{"set"}
+}
+
+func (setLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{ruleSet(rule.Spec())}, false
+}
+
+// ruleSet returns the name of the set matched by a rule.
+func ruleSet(spec iptables.RuleSpec) string {
+	option, ok := spec.MatchOption("set", "--match-set")
+	if !ok || len(option.Values) == 0 {
+		return ""
+	}
+	if option.Negated {
+		return "!" + option.Values[0]
+	}
+	return option.Values[0]
+}
+
+// referencedSets returns the names of all sets matched by rules.
+func referencedSets(families map[iptables.Family]iptables.Tables) map[string]bool {
+	sets := make(map[string]bool)
+	for _, tables := range families {
+		for _, table := range tables {
+			for _, chain := range table {
+				for _, rule := range chain.Rules {
+					if option, ok := rule.Spec().MatchOption("set", "--match-set"); ok && len(option.Values) > 0 {
+						sets[option.Values[0]] = true
+					}
+				}
+			}
+		}
+	}
+	return sets
+}
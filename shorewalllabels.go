@@ -0,0 +1,121 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// shorewallLabeler exports "managed_by" as "shorewall" for the chains
+// generated by Shorewall, and the zones of its zone-pair chains, such as
+// net2fw or loc-net, as "src_zone" and "dst_zone" labels. Rulesets not
+// generated by Shorewall export these labels empty.
+type shorewallLabeler struct {
+	managed bool
+}
+
+func (shorewallLabeler) labelNames() []string {
+	return []string{"managed_by", "src_zone", "dst_zone"}
+}
+
+// shorewallMarkers are chains only Shorewall creates in the filter table.
+var shorewallMarkers = [][]string{
+	{"shorewall"},
+	{"dynamic", "reject", "logreject"},
+}
+
+func (shorewallLabeler) forTables(tables iptables.Tables) ruleLabeler {
+	filter := tables["filter"]
+	for _, chains := range shorewallMarkers {
+		found := true
+		for _, chain := range chains {
+			if _, ok := filter[chain]; !ok {
+				found = false
+			}
+		}
+		if found {
+			return shorewallLabeler{managed: true}
+		}
+	}
+	return shorewallLabeler{}
+}
+
+// shorewallChains are the action and helper chains of Shorewall.
+var shorewallChains = map[string]bool{
+	"shorewall": true, "dynamic": true, "reject": true, "logreject": true,
+	"logdrop": true, "logaccept": true, "smurfs": true, "smurflog": true,
+	"sfilter": true, "blacklst": true, "blacklog": true, "tcpflags": true,
+	"Broadcast": true, "Invalid": true, "NotSyn": true, "dropBcast": true,
+	"dropInvalid": true, "dropNotSyn": true, "rejNotSyn": true,
+	"norfc1918": true, "nosmurfs": true,
+}
+
+// shorewallInterfaceSuffixes end the chains Shorewall creates per
+// interface, e.g. eth0_in.
+var shorewallInterfaceSuffixes = []string{"_in", "_fwd", "_out", "_mac", "_frwd"}
+
+func (l shorewallLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	if !l.managed {
+		return []string{"", "", ""}, false
+	}
+	src, dst, ok := shorewallZones(chain)
+	if ok || shorewallChains[chain] || isBuiltin(chain) || hasAnySuffix(chain, shorewallInterfaceSuffixes) {
+		return []string{"shorewall", src, dst}, false
+	}
+	return []string{"", "", ""}, false
+}
+
+// shorewallZones splits a zone-pair chain name into its zones, separated by
+// "-" or, in older configurations, "2". Zone names begin with a letter, so
+// "loc22net" is the chain from loc2 to net.
+func shorewallZones(chain string) (src, dst string, ok bool) {
+	if i := strings.Index(chain, "-"); i > 0 && isZoneName(chain[:i]) && isZoneName(chain[i+1:]) {
+		return chain[:i], chain[i+1:], true
+	}
+	for i := 1; i < len(chain)-1; i++ {
+		if chain[i] == '2' && isZoneName(chain[:i]) && isZoneName(chain[i+1:]) {
+			return chain[:i], chain[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func isBuiltin(chain string) bool {
+	_, ok := iptables.BuiltinHook(chain)
+	return ok
+}
+
+func isZoneName(s string) bool {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) && len(s) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// counterState remembers the last counter values seen per series and the
+// offsets added to compensate for counter resets. It can be persisted so
+// compensation survives exporter restarts.
+type counterState struct {
+	path string
+
+	mu     sync.Mutex
+	series map[string]*seriesState
+}
+
+type seriesState struct {
+	// Last is the last raw value read from the kernel.
+	Last float64 `json:"last"`
+	// Offset is added to raw values to keep the series monotonic.
+	Offset float64 `json:"offset"`
+	// Seen is when the series was last updated.
+	Seen time.Time `json:"seen"`
+	// Active is when the series was last seen increasing, if ever.
+	Active time.Time `json:"active,omitempty"`
+}
+
+// loadCounterState reads the state persisted at path. A missing file yields
+// an empty state; an empty path one that is never persisted.
+func loadCounterState(path string) (*counterState, error) {
+	s := &counterState{path: path, series: make(map[string]*seriesState)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.series); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// adjust records raw as the current value of the series key and returns it
+// plus the accumulated offset. A value lower than the last one is taken as
+// a reset, adding the last value to the offset.
+func (s *counterState) adjust(key string, raw float64, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.series[key]
+	if !ok {
+		st = &seriesState{}
+		s.series[key] = st
+	}
+	if raw < st.Last {
+		st.Offset += st.Last
+	}
+	st.Last = raw
+	st.Seen = now
+	return raw + st.Offset
+}
+
+// activity records raw as the current value of the series key and returns
+// when it last increased, including by being reset to a non-zero value. It
+// returns false until the series has been seen increasing once.
+func (s *counterState) activity(key string, raw float64, now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.series[key]
+	if !ok {
+		st = &seriesState{Last: raw}
+		s.series[key] = st
+	}
+	if raw > st.Last || (raw < st.Last && raw > 0) {
+		st.Active = now
+	}
+	st.Last = raw
+	st.Seen = now
+	return st.Active, !st.Active.IsZero()
+}
+
+// expire forgets series not updated since before.
+func (s *counterState) expire(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, st := range s.series {
+		if st.Seen.Before(before) {
+			delete(s.series, key)
+		}
+	}
+}
+
+// save atomically writes the state to its file, if it has one.
+func (s *counterState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(s.series)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic replaces the file at path with data, so readers never see
+// a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// persist forgets the series not updated for retention, if set, and saves
+// the state.
+func (s *counterState) persist(retention time.Duration) error {
+	if retention > 0 {
+		s.expire(time.Now().Add(-retention))
+	}
+	return s.save()
+}
+
+// persistCounterState saves s every interval. The exporter saves it once
+// more when shutting down.
+func persistCounterState(s *counterState, interval, retention time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.persist(retention); err != nil {
+			log.Errorf("Saving counter state: %s", err)
+		}
+	}
+}
+
+// stateFlags are the values of the --state.* flags.
+type stateFlags struct {
+	file                    *string
+	saveInterval, retention *time.Duration
+}
+
+func newStateFlags(app *kingpin.Application) stateFlags {
+	return stateFlags{
+		file:         app.Flag("state.file", "File to persist counter state in across restarts.").String(),
+		saveInterval: app.Flag("state.save-interval", "How often to save the counter state.").Default("1m").Duration(),
+		retention:    app.Flag("state.retention", "How long to keep the state of series that disappeared.").Default("24h").Duration(),
+	}
+}
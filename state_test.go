@@ -0,0 +1,114 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCounterStateAdjust(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	s, err := loadCounterState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// Raw values drop on every reset; the adjusted ones keep increasing.
+	for _, step := range []struct{ raw, adjusted float64 }{
+		{10, 10},
+		{15, 15},
+		{3, 18},
+		{8, 23},
+		{0, 23},
+		{4, 27},
+	} {
+		if adjusted := s.adjust("a", step.raw, now); adjusted != step.adjusted {
+			t.Fatalf("adjust(%v): expected %v, got %v", step.raw, step.adjusted, adjusted)
+		}
+	}
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The offsets survive a restart.
+	s, err = loadCounterState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adjusted := s.adjust("a", 1, now); adjusted != 28 {
+		t.Fatalf("after restart: expected 28, got %v", adjusted)
+	}
+	if adjusted := s.adjust("b", 1, now); adjusted != 1 {
+		t.Fatalf("new series: expected 1, got %v", adjusted)
+	}
+}
+
+func TestCounterStateExpire(t *testing.T) {
+	s, err := loadCounterState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.adjust("old", 1, now.Add(-time.Hour))
+	s.adjust("new", 1, now)
+	s.expire(now.Add(-time.Minute))
+	if _, ok := s.series["old"]; ok {
+		t.Error("old series not expired")
+	}
+	if _, ok := s.series["new"]; !ok {
+		t.Error("new series expired")
+	}
+	// A state without file isn't saved anywhere.
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCounterStateActivity(t *testing.T) {
+	s, err := loadCounterState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i, step := range []struct {
+		raw    float64
+		active time.Duration
+		ok     bool
+	}{
+		{raw: 5},
+		{raw: 5},
+		{raw: 7, active: 2 * time.Second, ok: true},
+		{raw: 7, active: 2 * time.Second, ok: true},
+		// A reset to a non-zero value counted packets since the last read.
+		{raw: 1, active: 4 * time.Second, ok: true},
+		{raw: 0, active: 4 * time.Second, ok: true},
+	} {
+		now := start.Add(time.Duration(i) * time.Second)
+		active, ok := s.activity("a", step.raw, now)
+		if ok != step.ok || ok && !active.Equal(start.Add(step.active)) {
+			t.Fatalf("step %d: expected %v, %v, got %v, %v", i, step.active, step.ok, active.Sub(start), ok)
+		}
+	}
+}
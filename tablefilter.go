@@ -0,0 +1,93 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// tableFilterFlags are the values of the --iptables.include-* and
+// --iptables.exclude-* flags.
+type tableFilterFlags struct {
+	includeTables, excludeTables, includeChains, excludeChains         *[]string
+	includeTablesRE, excludeTablesRE, includeChainsRE, excludeChainsRE *string
+}
+
+func newTableFilterFlags(app *kingpin.Application) tableFilterFlags {
+	return tableFilterFlags{
+		includeTables:   app.Flag("iptables.include-tables", "Only collect these tables, dumping just them with the save command. Can be repeated or comma-separated.").Strings(),
+		excludeTables:   app.Flag("iptables.exclude-tables", "Do not collect these tables. Can be repeated or comma-separated.").Strings(),
+		includeTablesRE: app.Flag("iptables.include-tables-re", "Only collect the tables whose whole name matches this regular expression.").String(),
+		excludeTablesRE: app.Flag("iptables.exclude-tables-re", "Do not collect the tables whose whole name matches this regular expression.").String(),
+		includeChains:   app.Flag("iptables.include-chains", "Only collect these chains. Can be repeated or comma-separated.").Strings(),
+		excludeChains:   app.Flag("iptables.exclude-chains", "Do not collect these chains. Can be repeated or comma-separated.").Strings(),
+		includeChainsRE: app.Flag("iptables.include-chains-re", "Only collect the chains whose whole name matches this regular expression.").String(),
+		excludeChainsRE: app.Flag("iptables.exclude-chains-re", "Do not collect the chains whose whole name matches this regular expression, e.g. 'KUBE-.*'.").String(),
+	}
+}
+
+// filter returns the filter the flags configure, or nil if they are all
+// empty.
+func (f tableFilterFlags) filter() (*iptables.Filter, error) {
+	filter := &iptables.Filter{
+		IncludeTables: splitNames(*f.includeTables),
+		ExcludeTables: splitNames(*f.excludeTables),
+		IncludeChains: splitNames(*f.includeChains),
+		ExcludeChains: splitNames(*f.excludeChains),
+	}
+	for _, re := range []struct {
+		flag, expr string
+		re         **regexp.Regexp
+	}{
+		{"include-tables-re", *f.includeTablesRE, &filter.IncludeTablesRE},
+		{"exclude-tables-re", *f.excludeTablesRE, &filter.ExcludeTablesRE},
+		{"include-chains-re", *f.includeChainsRE, &filter.IncludeChainsRE},
+		{"exclude-chains-re", *f.excludeChainsRE, &filter.ExcludeChainsRE},
+	} {
+		if re.expr == "" {
+			continue
+		}
+		// Anchored like the regexps of Prometheus relabelling.
+		compiled, err := regexp.Compile("^(?:" + re.expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid --iptables.%s: %s", re.flag, err)
+		}
+		*re.re = compiled
+	}
+	if len(filter.IncludeTables)+len(filter.ExcludeTables)+len(filter.IncludeChains)+len(filter.ExcludeChains) == 0 &&
+		*f.includeTablesRE+*f.excludeTablesRE+*f.includeChainsRE+*f.excludeChainsRE == "" {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// splitNames splits the values of repeatable flags also taking
+// comma-separated lists.
+func splitNames(values []string) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,340 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// localTarget is the instance label of the local host's metrics once remote
+// targets are configured.
+const localTarget = "local"
+
+// targetConfig is a remote host collected on every scrape, either over SSH
+// or from another exporter acting as agent.
+type targetConfig struct {
+	Name string `yaml:"name"`
+	// Timeout limits how long collecting a family may take. Defaults to
+	// 10s.
+	Timeout time.Duration      `yaml:"timeout"`
+	SSH     *sshTargetConfig   `yaml:"ssh"`
+	Agent   *agentTargetConfig `yaml:"agent"`
+}
+
+// kind returns how the target is collected, ssh or agent.
+func (t targetConfig) kind() string {
+	if t.SSH != nil {
+		return "ssh"
+	}
+	return "agent"
+}
+
+type sshTargetConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	User string `yaml:"user"`
+	// IdentityFile is the private key to authenticate with.
+	IdentityFile string `yaml:"identity_file"`
+	// Sudo runs the save commands with sudo -n.
+	Sudo bool `yaml:"sudo"`
+	// JumpHosts are the bastions to connect through, as [user@]host[:port],
+	// in order.
+	JumpHosts []string `yaml:"jump_hosts"`
+	// KnownHostsFile pins the host keys of the host and of the jump hosts:
+	// hosts whose key isn't in it are refused.
+	KnownHostsFile string `yaml:"known_hosts_file"`
+	// Persist keeps the connection open for reuse by later scrapes for this
+	// long after the last one. Zero reconnects on every scrape.
+	Persist time.Duration `yaml:"persist"`
+}
+
+type agentTargetConfig struct {
+	// URL of the /api/v1/save endpoint of the exporter on the target.
+	URL string `yaml:"url"`
+	// BearerTokenFile holds the agent's --web.admin-token-file token.
+	BearerTokenFile string `yaml:"bearer_token_file"`
+}
+
+// tablesSource dumps the tables of a family.
+type tablesSource interface {
+	getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error)
+}
+
+// localSource runs the save commands on this host.
+type localSource struct{}
+
+func (localSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	return iptables.GetTablesStats(ctx, opts)
+}
+
+// Values of --collector.backend.
+const (
+	backendExec    = "exec"
+	backendNetlink = "netlink"
+)
+
+// kernelSource reads the tables of this host from the kernel, without
+// running the save commands, unless the kernel has no legacy tables of a
+// family, e.g. because iptables uses nftables.
+type kernelSource struct {
+	// fallbacks are the families the save commands were run for instead.
+	fallbacks sync.Map
+}
+
+func (s *kernelSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	tables, stats, err := iptables.GetKernelTablesStats(opts)
+	if err != iptables.ErrKernelUnavailable {
+		return tables, stats, err
+	}
+	if _, logged := s.fallbacks.LoadOrStore(opts.Family, true); !logged {
+		log.Infof("No legacy %s tables in the kernel, running %s instead", opts.Family, opts.Family.SaveCommand())
+	}
+	start := time.Now()
+	defer timeExec(opts.Family.SaveCommand(), "", start)
+	return iptables.GetTablesStats(ctx, opts)
+}
+
+// sshSource runs the save commands on a remote host with ssh.
+type sshSource struct {
+	args    []string
+	timeout time.Duration
+}
+
+// sshDir holds the configurations generated for jump hosts and the control
+// sockets of the SSH connections kept open between scrapes.
+var sshDir struct {
+	once sync.Once
+	path string
+	err  error
+}
+
+func sshDirPath() (string, error) {
+	sshDir.once.Do(func() {
+		// Control socket paths are limited to about 100 bytes, so keep
+		// them short.
+		sshDir.path, sshDir.err = ioutil.TempDir("", "ipe-ssh")
+	})
+	return sshDir.path, sshDir.err
+}
+
+func newSSHSource(cfg *sshTargetConfig, timeout time.Duration) (*sshSource, error) {
+	args := []string{"ssh", "-o", "BatchMode=yes"}
+	if len(cfg.JumpHosts) > 0 {
+		config, err := writeJumpHostsConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-F", config, "-J", strings.Join(cfg.JumpHosts, ","))
+	}
+	if cfg.KnownHostsFile != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+cfg.KnownHostsFile, "-o", "StrictHostKeyChecking=yes")
+	}
+	if cfg.Persist > 0 {
+		dir, err := sshDirPath()
+		if err != nil {
+			return nil, err
+		}
+		persist := int((cfg.Persist + time.Second - 1) / time.Second)
+		args = append(args,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath="+filepath.Join(dir, "%C"),
+			"-o", "ControlPersist="+strconv.Itoa(persist))
+	}
+	if cfg.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(cfg.Port))
+	}
+	if cfg.IdentityFile != "" {
+		args = append(args, "-i", cfg.IdentityFile)
+	}
+	if cfg.User != "" {
+		args = append(args, "-l", cfg.User)
+	}
+	args = append(args, cfg.Host)
+	if cfg.Sudo {
+		args = append(args, "sudo", "-n")
+	}
+	return &sshSource{args: args, timeout: timeout}, nil
+}
+
+// writeJumpHostsConfig writes an ssh_config with a Host block per jump host
+// and returns its path. ssh connects to jump hosts with the configuration
+// file given with -F but without the other options of the command line, so
+// the options that must also apply to them go in there. The user's own
+// configuration is read after them.
+func writeJumpHostsConfig(cfg *sshTargetConfig) (string, error) {
+	dir, err := sshDirPath()
+	if err != nil {
+		return "", err
+	}
+	var config strings.Builder
+	for _, hop := range cfg.JumpHosts {
+		fmt.Fprintf(&config, "Host %s\n\tBatchMode yes\n", jumpHostName(hop))
+		if cfg.KnownHostsFile != "" {
+			fmt.Fprintf(&config, "\tUserKnownHostsFile %q\n\tStrictHostKeyChecking yes\n", cfg.KnownHostsFile)
+		}
+	}
+	config.WriteString("Match all\n\tInclude ~/.ssh/config\n")
+	f, err := ioutil.TempFile(dir, "config")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.WriteString(config.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return f.Name(), err
+}
+
+// jumpHostName returns the host name of a jump host given as
+// [user@]host[:port].
+func jumpHostName(hop string) string {
+	if i := strings.LastIndex(hop, "@"); i >= 0 {
+		hop = hop[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return host
+	}
+	return hop
+}
+
+func (s *sshSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	opts.Exec = s.args
+	opts.Timeout = s.timeout
+	return iptables.GetTablesStats(ctx, opts)
+}
+
+// agentSource fetches the save command output from another exporter.
+type agentSource struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newAgentSource(cfg *agentTargetConfig, timeout time.Duration) (*agentSource, error) {
+	s := &agentSource{url: cfg.URL, client: &http.Client{Timeout: timeout}}
+	if cfg.BearerTokenFile != "" {
+		token, err := readAdminToken(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		s.token = token
+	}
+	return s, nil
+}
+
+func (s *agentSource) getTables(ctx context.Context, opts iptables.Options) (iptables.Tables, iptables.ParseStats, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, iptables.ParseStats{}, err
+	}
+	q := u.Query()
+	q.Set("ip_family", string(opts.Family))
+	for _, table := range opts.Tables {
+		q.Add("table", table)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, iptables.ParseStats{}, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, iptables.ParseStats{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, iptables.ParseStats{}, fmt.Errorf("%s returned %s", s.url, resp.Status)
+	}
+	capture := opts.Capture
+	if capture == nil {
+		capture = regexp.MustCompile(".*")
+	}
+	if opts.SkipRules {
+		capture = nil
+	}
+	return iptables.ParseIptablesSaveChains(resp.Body, capture, opts.Chains)
+}
+
+// newTargetSource returns the source collecting the target configured by
+// cfg, which config.validate has checked.
+func newTargetSource(cfg targetConfig) (tablesSource, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	if cfg.SSH != nil {
+		return newSSHSource(cfg.SSH, timeout)
+	}
+	return newAgentSource(cfg.Agent, timeout)
+}
+
+// saveHandler answers /api/v1/save with the raw output of the save command
+// of the family given by the ip_family parameter, for agent targets of
+// other exporters. The table parameter, which can be repeated, selects
+// tables.
+func saveHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	family := iptables.IPv4
+	if f := q.Get("ip_family"); f != "" {
+		family = iptables.Family(f)
+	}
+	if family != iptables.IPv4 && family != iptables.IPv6 {
+		http.Error(w, "unknown ip_family "+strconv.Quote(string(family)), http.StatusBadRequest)
+		return
+	}
+	tables := q["table"]
+	if len(tables) == 0 {
+		tables = []string{""}
+	}
+	var dump []byte
+	for _, table := range tables {
+		args := []string{"-c"}
+		if table != "" {
+			args = append(args, "-t", table)
+		}
+		start := time.Now()
+		cmd := exec.CommandContext(r.Context(), family.SaveCommand(), args...)
+		out, err := cmd.Output()
+		timeExec(family.SaveCommand(), table, start)
+		if cmd.ProcessState != nil {
+			recordExit(family.SaveCommand(), cmd.ProcessState)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", family.SaveCommand(), err), http.StatusInternalServerError)
+			return
+		}
+		dump = append(dump, out...)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(dump)
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	targetsDesc = prometheus.NewDesc(
+		"iptables_targets",
+		"iptables_exporter: Number of hosts collected from.",
+		[]string{"kind"},
+		nil,
+	)
+
+	targetsUpDesc = prometheus.NewDesc(
+		"iptables_targets_up",
+		"iptables_exporter: Number of hosts whose last collection succeeded.",
+		[]string{"kind"},
+		nil,
+	)
+
+	targetErrorsDesc = prometheus.NewDesc(
+		"iptables_target_collection_errors_total",
+		"iptables_exporter: Total failed collections of a host.",
+		[]string{"instance", "kind"},
+		nil,
+	)
+)
+
+// targetStatsCollector sums up the outcome of the collections of several
+// hosts, so partial failures show without looking at every instance.
+type targetStatsCollector struct {
+	targets []probeTarget
+	// discovered are the network namespaces and containers, found anew on
+	// every scrape.
+	discovered []targetDiscoverer
+}
+
+// targetDiscoverer lists the targets found by the last discovery.
+type targetDiscoverer interface {
+	probeTargets() []probeTarget
+}
+
+func (targetStatsCollector) Describe(descChan chan<- *prometheus.Desc) {
+	descChan <- targetsDesc
+	descChan <- targetsUpDesc
+	descChan <- targetErrorsDesc
+}
+
+func (s targetStatsCollector) Collect(metricChan chan<- prometheus.Metric) {
+	total := make(map[string]int)
+	up := make(map[string]int)
+	targets := s.targets
+	for _, d := range s.discovered {
+		targets = append(targets[:len(targets):len(targets)], d.probeTargets()...)
+	}
+	for _, t := range targets {
+		ok, failures := t.collector.health.status()
+		total[t.kind]++
+		if ok {
+			up[t.kind]++
+		}
+		metricChan <- prometheus.MustNewConstMetric(targetErrorsDesc, prometheus.CounterValue, float64(failures), t.name, t.kind)
+	}
+	for kind, n := range total {
+		metricChan <- prometheus.MustNewConstMetric(targetsDesc, prometheus.GaugeValue, float64(n), kind)
+		metricChan <- prometheus.MustNewConstMetric(targetsUpDesc, prometheus.GaugeValue, float64(up[kind]), kind)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// certReloader serves a TLS certificate that is reloaded from disk when its
+// files change or on SIGHUP, so rotated certificates are picked up without
+// restarting the listener.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime [2]time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) modTimes() ([2]time.Time, error) {
+	var times [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return times, err
+		}
+		times[i] = fi.ModTime()
+	}
+	return times, nil
+}
+
+func (r *certReloader) reload() error {
+	times, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = times
+	return nil
+}
+
+// changed reports whether the files were modified since the last reload.
+func (r *certReloader) changed() bool {
+	times, err := r.modTimes()
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return times != r.modTime
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// watch checks the files for changes every interval and reloads them on
+// SIGHUP. A certificate that fails to load is logged and the previous one
+// kept.
+func (r *certReloader) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+		case <-hup:
+		}
+		if err := r.reload(); err != nil {
+			log.Errorf("Reloading TLS certificate, keeping the previous one: %s", err)
+			continue
+		}
+		log.Infof("Reloaded TLS certificate from %s", r.certFile)
+	}
+}
+
+// webConfigTLS reports whether the exporter-toolkit web configuration file
+// given to --web.config.file enables TLS.
+func webConfigTLS(path string) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var cfg struct {
+		TLSServerConfig struct {
+			CertFile string `yaml:"cert_file"`
+		} `yaml:"tls_server_config"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return false, err
+	}
+	return cfg.TLSServerConfig.CertFile != "", nil
+}
+
+// kitLogger passes the messages of exporter-toolkit, which logs with
+// go-kit, to the exporter's log.
+type kitLogger struct{}
+
+func (kitLogger) Log(keyvals ...interface{}) error {
+	var level, msg string
+	var fields []string
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key, value := fmt.Sprint(keyvals[i]), fmt.Sprint(keyvals[i+1])
+		switch key {
+		case "level":
+			level = value
+		case "msg":
+			msg = value
+		default:
+			fields = append(fields, key+"="+value)
+		}
+	}
+	line := strings.TrimSpace(msg + " " + strings.Join(fields, " "))
+	switch level {
+	case "error":
+		log.Errorln(line)
+	case "warn":
+		log.Warnln(line)
+	case "debug":
+		log.Debugln(line)
+	default:
+		log.Infoln(line)
+	}
+	return nil
+}
+
+// tlsFlags are the values of the flags serving HTTPS, either with the
+// certificate of --web.tls-cert-file or as --web.config.file configures.
+type tlsFlags struct {
+	certFile, keyFile, webConfigFile *string
+	reloadInterval                   *time.Duration
+}
+
+func newTLSFlags(app *kingpin.Application) tlsFlags {
+	return tlsFlags{
+		certFile:       app.Flag("web.tls-cert-file", "Serve HTTPS with the certificate in this file, reloaded when it changes or on SIGHUP.").String(),
+		keyFile:        app.Flag("web.tls-key-file", "Private key for --web.tls-cert-file.").String(),
+		reloadInterval: app.Flag("web.tls-reload-interval", "How often to check the TLS certificate and key files for changes.").Default("1m").Duration(),
+		webConfigFile:  kingpinflag.AddFlags(app),
+	}
+}
+
+// certFiles reports whether --web.tls-cert-file or --web.tls-key-file is
+// set.
+func (f tlsFlags) certFiles() bool {
+	return *f.certFile != "" || *f.keyFile != ""
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+type captureValidation struct {
+	Regex        string                  `json:"regex"`
+	RulesTotal   int                     `json:"rules_total"`
+	RulesMatched int                     `json:"rules_matched"`
+	Series       int                     `json:"series"`
+	Rules        []captureValidationRule `json:"rules"`
+}
+
+type captureValidationRule struct {
+	Family  iptables.Family `json:"ip_family"`
+	Table   string          `json:"table"`
+	Chain   string          `json:"chain"`
+	Text    string          `json:"text"`
+	Matched bool            `json:"matched"`
+	Label   string          `json:"label,omitempty"`
+	// Groups are the labels of the named groups.
+	Groups map[string]string `json:"groups,omitempty"`
+}
+
+// validateCapture applies the candidate capture regular expression to the
+// current ruleset and reports the resulting rule labels and how many rule
+// series would be exported, without changing c.
+func (c *collector) validateCapture(candidate string) (*captureValidation, error) {
+	capture, err := regexp.Compile(candidate)
+	if err != nil {
+		return nil, err
+	}
+	families, err := c.getAllTables(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := &captureValidation{Regex: candidate, Rules: []captureValidationRule{}}
+	seriesPerRule := 2
+	if c.continuity != nil {
+		seriesPerRule = 4
+	}
+	for _, family := range iptables.Families {
+		tables, ok := families[family]
+		if !ok {
+			continue
+		}
+		captured := make(iptables.Tables)
+		for _, tableName := range tables.Names() {
+			table := tables[tableName]
+			captured[tableName] = make(iptables.Table)
+			for _, chainName := range table.ChainNames() {
+				chain := table[chainName]
+				var rules []iptables.Rule
+				for _, rule := range chain.Rules {
+					label, matched := iptables.CaptureLabel(capture, rule.Text)
+					result.RulesTotal++
+					result.Rules = append(result.Rules, captureValidationRule{
+						Family:  family,
+						Table:   tableName,
+						Chain:   chainName,
+						Text:    rule.Text,
+						Matched: matched,
+						Label:   label,
+						Groups:  iptables.CaptureGroups(capture, rule.Text),
+					})
+					if matched {
+						result.RulesMatched++
+						rule.Rule = label
+						rules = append(rules, rule)
+					}
+				}
+				chain.Rules = rules
+				captured[tableName][chainName] = chain
+			}
+		}
+		result.Series += seriesPerRule * len(c.countRules(captured, nil))
+	}
+	return result, nil
+}
+
+// readAdminToken reads the token protecting administrative endpoints.
+func readAdminToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
+
+// requireToken only passes requests carrying token as bearer token to next.
+// With basic auth enabled by --web.config.file taking the Authorization
+// header, the token can be sent as X-Admin-Token header instead.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := r.Header.Get("X-Admin-Token")
+		if given == "" {
+			given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			deniedRequests.WithLabelValues("token").Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateHandler answers /-/validate?re=... with the result of
+// validateCapture.
+func validateHandler(c *collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		candidate := r.URL.Query().Get("re")
+		if candidate == "" {
+			http.Error(w, "missing re parameter", http.StatusBadRequest)
+			return
+		}
+		result, err := c.validateCapture(candidate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var chainJumpsDesc = prometheus.NewDesc(
+	"iptables_chain_jumps",
+	"iptables_exporter: Number of rules of a chain passing packets on to another chain of its table.",
+	[]string{"table", "chain", "target", "verdict_kind", "ip_family"},
+	nil,
+)
+
+// verdictKindLabeler exports whether a rule continues in its target with
+// -j or -g as "verdict_kind" label.
+type verdictKindLabeler struct{}
+
+func (verdictKindLabeler) labelNames() []string {
+	return []string{"verdict_kind"}
+}
+
+func (verdictKindLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	return []string{verdictKind(rule.Spec())}, false
+}
+
+// verdictKind returns jump for rules with -j, goto for rules with -g, and
+// an empty string for rules without target. Chains reached with goto
+// return to the chain that called the rule's chain rather than to it.
+func verdictKind(spec iptables.RuleSpec) string {
+	switch {
+	case spec.Target == "":
+		return ""
+	case spec.Goto:
+		return "goto"
+	default:
+		return "jump"
+	}
+}
+
+// collectChainJumps exports the number of rules jumping from chain to chain,
+// separating -j from -g.
+func (c *collector) collectChainJumps(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	if !c.chainJumps {
+		return
+	}
+	type edge struct {
+		from, to string
+		goTo     bool
+	}
+	for tableName, table := range tables {
+		counts := make(map[edge]int)
+		for _, jump := range table.Jumps() {
+			counts[edge{jump.From, jump.To, jump.Goto}]++
+		}
+		for e, count := range counts {
+			kind := "jump"
+			if e.goTo {
+				kind = "goto"
+			}
+			metricChan <- prometheus.MustNewConstMetric(
+				chainJumpsDesc,
+				prometheus.GaugeValue,
+				float64(count),
+				tableName,
+				e.from,
+				e.to,
+				kind,
+				string(family),
+			)
+		}
+	}
+}
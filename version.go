@@ -0,0 +1,72 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/prometheus/common/version"
+)
+
+// backendCommands are the commands whose versions /version reports.
+var backendCommands = []string{"iptables-save", "ip6tables-save", "ipset", "nft"}
+
+type versionInfo struct {
+	Version    string            `json:"version"`
+	Revision   string            `json:"revision"`
+	Branch     string            `json:"branch"`
+	BuildUser  string            `json:"build_user"`
+	BuildDate  string            `json:"build_date"`
+	GoVersion  string            `json:"go_version"`
+	Collectors []string          `json:"collectors"`
+	Backends   map[string]string `json:"backends"`
+}
+
+// backendVersion returns the first line printed by command --version, or
+// false if the command isn't available.
+func backendVersion(command string) (string, bool) {
+	out, err := exec.Command(command, "--version").Output()
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]), true
+}
+
+// versionHandler answers /version with the build information, the enabled
+// collectors and the versions of the available backend commands.
+func versionHandler(collectors []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := versionInfo{
+			Version:    version.Version,
+			Revision:   version.Revision,
+			Branch:     version.Branch,
+			BuildUser:  version.BuildUser,
+			BuildDate:  version.BuildDate,
+			GoVersion:  version.GoVersion,
+			Collectors: collectors,
+			Backends:   make(map[string]string),
+		}
+		for _, command := range backendCommands {
+			if v, ok := backendVersion(command); ok {
+				info.Backends[command] = v
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// vyosLabeler exports the firewall name, rule number and description of the
+// rules generated by VyOS as "firewall", "rule_number" and "description"
+// labels, matching the output of "show firewall".
+type vyosLabeler struct{}
+
+func (vyosLabeler) labelNames() []string {
+	return []string{"firewall", "rule_number", "description"}
+}
+
+// vyosCommentRegexp matches the comments VyOS gives its rules: the firewall
+// name and rule number, e.g. "WAN_IN-10", followed for some rules by a
+// description, e.g. "WAN_IN-10000 default-action drop".
+var vyosCommentRegexp = regexp.MustCompile(`^(\S+)-(\d+)(?: (.*))?$`)
+
+func (vyosLabeler) labelValues(table, chain string, rule iptables.Rule) ([]string, bool) {
+	name := vyosFirewall(chain)
+	values := []string{name, "", ""}
+	match := vyosCommentRegexp.FindStringSubmatch(rule.Spec().Comment)
+	// VyOS before 1.3 names the chains after the firewall itself.
+	if match != nil && (match[1] == name || name == "" && match[1] == chain) {
+		values[0] = match[1]
+		values[1] = match[2]
+		values[2] = match[3]
+	}
+	return values, false
+}
+
+// vyosFirewall returns the firewall name of the chains VyOS 1.3 generates
+// for named firewalls, NAME_<name> for IPv4 and NAME6_<name> for IPv6.
+func vyosFirewall(chain string) string {
+	for _, prefix := range []string{"NAME_", "NAME6_"} {
+		if strings.HasPrefix(chain, prefix) && len(chain) > len(prefix) {
+			return chain[len(prefix):]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestVyosLabeler(t *testing.T) {
+	testLabeler(t, vyosLabeler{}, []labelerCase{
+		{chain: "NAME_WAN_IN", text: `-m comment --comment WAN_IN-10 -j RETURN`, values: []string{"WAN_IN", "10", ""}},
+		{chain: "WAN_IN", text: `-m comment --comment "WAN_IN-10000 default-action drop" -j DROP`, values: []string{"WAN_IN", "10000", "default-action drop"}},
+		{chain: "NAME_WAN_IN", text: `-m comment --comment LAN_IN-10 -j RETURN`, values: []string{"WAN_IN", "", ""}},
+	})
+}
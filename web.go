@@ -0,0 +1,185 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	deniedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iptables_exporter_web_denied_requests_total",
+			Help: "iptables_exporter: Total HTTP requests rejected before reaching a handler.",
+		},
+		[]string{"reason"},
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iptables_exporter_http_request_duration_seconds",
+			Help:    "iptables_exporter: Duration of HTTP requests to scrape handlers.",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"handler", "code", "method"},
+	)
+
+	responseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iptables_exporter_http_response_size_bytes",
+			Help:    "iptables_exporter: Size of the responses of scrape handlers.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 8),
+		},
+		[]string{"handler", "code", "method"},
+	)
+
+	requestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "iptables_exporter_http_requests_in_flight",
+			Help: "iptables_exporter: Number of requests to scrape handlers being served.",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deniedRequests, requestDuration, responseSize, requestsInFlight)
+}
+
+// parseCIDRs parses the values given to --web.allow-cidr. A bare address is
+// accepted and treated as a single-host network.
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// clientIP returns the address of the peer that sent r.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// allowCIDRs only passes requests from clients within one of nets to next.
+// An empty list allows every client.
+func allowCIDRs(nets []*net.IPNet, next http.Handler) http.Handler {
+	if len(nets) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip != nil {
+			for _, n := range nets {
+				if n.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		log.Debugf("Denying request from %s: not in allowed networks", r.RemoteAddr)
+		deniedRequests.WithLabelValues("allowlist").Inc()
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// inFlightLimiter caps the number of scrape requests served at once, so
+// scrapes piling up on a slow host don't pile up save commands too.
+type inFlightLimiter chan struct{}
+
+// newInFlightLimiter returns a limiter allowing max requests at once, or no
+// limiter if max is zero.
+func newInFlightLimiter(max int) inFlightLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(inFlightLimiter, max)
+}
+
+// instrumentHandler records the duration and response size of the
+// requests next serves, labeled with name, and answers with 503 Service
+// Unavailable while l is at its limit.
+func instrumentHandler(l inFlightLimiter, name string, next http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	instrumented := promhttp.InstrumentHandlerInFlight(requestsInFlight,
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(labels), next)))
+	if l == nil {
+		return instrumented
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l <- struct{}{}:
+			defer func() { <-l }()
+			instrumented.ServeHTTP(w, r)
+		default:
+			log.Debugf("Rejecting request from %s: %d requests in flight", r.RemoteAddr, cap(l))
+			deniedRequests.WithLabelValues("max_requests").Inc()
+			http.Error(w, fmt.Sprintf("Too many concurrent requests, limit is %d.", cap(l)), http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// webFlags are the values of the flags configuring the HTTP server and its
+// endpoints.
+type webFlags struct {
+	listenAddress, metricsPath, adminTokenFile *string
+	allowCIDR                                  *[]string
+	shutdownTimeout                            *time.Duration
+	maxRequests, readyThreshold                *int
+	landingPage                                *bool
+}
+
+func newWebFlags(app *kingpin.Application) webFlags {
+	return webFlags{
+		listenAddress:   app.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":9455").String(),
+		metricsPath:     app.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String(),
+		allowCIDR:       app.Flag("web.allow-cidr", "Only accept HTTP requests from this network (CIDR or address). Can be repeated; all clients are allowed by default.").Strings(),
+		shutdownTimeout: app.Flag("web.shutdown-timeout", "How long to let in-flight requests finish when shutting down on SIGINT or SIGTERM.").Default("10s").Duration(),
+		maxRequests:     app.Flag("web.max-requests", "Maximum number of scrape requests served at once, beyond which requests are answered with 503 Service Unavailable (0 means no limit).").Default("40").Int(),
+		readyThreshold:  app.Flag("web.ready-failure-threshold", "Number of consecutive failed collections after which /readyz reports not ready (0 never turns unready after the first success).").Default("3").Int(),
+		adminTokenFile:  app.Flag("web.admin-token-file", "File containing the bearer token required by administrative endpoints like /-/validate, which are disabled without it.").String(),
+		landingPage:     app.Flag("web.landing-page", "Serve a landing page at / listing the version, collectors and endpoints; --no-web.landing-page answers / with 404.").Default("true").Bool(),
+	}
+}
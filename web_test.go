@@ -0,0 +1,83 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAllowCIDRs(t *testing.T) {
+	nets, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := allowCIDRs(nets, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	for _, tc := range []struct {
+		remoteAddr, forwardedFor string
+		code                     int
+		body                     string
+	}{
+		{remoteAddr: "10.1.2.3:4711", code: http.StatusOK, body: "ok"},
+		{remoteAddr: "192.168.1.5:4711", code: http.StatusOK, body: "ok"},
+		{remoteAddr: "[2001:db8::1]:4711", code: http.StatusOK, body: "ok"},
+		{remoteAddr: "192.168.1.6:4711", code: http.StatusForbidden, body: "Forbidden\n"},
+		{remoteAddr: "[2001:db9::1]:4711", code: http.StatusForbidden, body: "Forbidden\n"},
+		// Clients can't get in by claiming to be forwarded for an allowed
+		// address.
+		{remoteAddr: "172.16.0.1:4711", forwardedFor: "10.1.2.3", code: http.StatusForbidden, body: "Forbidden\n"},
+		{remoteAddr: "10.1.2.3:4711", forwardedFor: "172.16.0.1", code: http.StatusOK, body: "ok"},
+	} {
+		denied := testutil.ToFloat64(deniedRequests.WithLabelValues("allowlist"))
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code || w.Body.String() != tc.body {
+			t.Fatalf("%s: expected %d %q, got %d %q", tc.remoteAddr, tc.code, tc.body, w.Code, w.Body.String())
+		}
+		expected := denied
+		if tc.code == http.StatusForbidden {
+			expected++
+		}
+		if got := testutil.ToFloat64(deniedRequests.WithLabelValues("allowlist")); got != expected {
+			t.Fatalf("%s: expected %v denied requests, got %v", tc.remoteAddr, expected, got)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "not-an-address", "10.0.0"} {
+		if _, err := parseCIDRs([]string{value}); err == nil {
+			t.Fatalf("%s: expected an error", value)
+		}
+	}
+	// Without networks every client is allowed.
+	h := allowCIDRs(nil, http.NotFoundHandler())
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
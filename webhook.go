@@ -0,0 +1,199 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+// webhookSignatureHeader carries the hex encoded HMAC-SHA256 of the request
+// body, keyed with the webhook's secret.
+const webhookSignatureHeader = "X-Iptables-Exporter-Signature"
+
+var webhookNotifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "iptables_exporter_webhook_notifications_total",
+		Help: "iptables_exporter: Total ruleset change notifications sent to webhooks.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(webhookNotifications)
+}
+
+type webhookConfig struct {
+	URL string `yaml:"url"`
+	// Secret, if set, is used to sign the payload.
+	Secret string `yaml:"secret"`
+	// MaxRetries is how often a failed delivery is retried, with
+	// exponential backoff starting at one second. Defaults to 3.
+	MaxRetries *int `yaml:"max_retries"`
+	// Timeout of a single delivery attempt. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type webhookPayload struct {
+	Timestamp    time.Time            `json:"timestamp"`
+	Family       iptables.Family      `json:"ip_family"`
+	Hash         string               `json:"hash"`
+	PreviousHash string               `json:"previous_hash"`
+	Summary      webhookSummary       `json:"summary"`
+	Chains       []iptables.ChainDiff `json:"chains"`
+}
+
+type webhookSummary struct {
+	ChainsAdded     int `json:"chains_added"`
+	ChainsRemoved   int `json:"chains_removed"`
+	PoliciesChanged int `json:"policies_changed"`
+	RulesAdded      int `json:"rules_added"`
+	RulesRemoved    int `json:"rules_removed"`
+}
+
+func newWebhookPayload(change rulesetChange) webhookPayload {
+	p := webhookPayload{
+		Timestamp:    change.Time,
+		Family:       change.Family,
+		Hash:         change.Hash,
+		PreviousHash: change.PreviousHash,
+		Chains:       change.Diff,
+	}
+	for _, d := range change.Diff {
+		if d.Added {
+			p.Summary.ChainsAdded++
+		}
+		if d.Removed {
+			p.Summary.ChainsRemoved++
+		}
+		if d.OldPolicy != d.NewPolicy {
+			p.Summary.PoliciesChanged++
+		}
+		p.Summary.RulesAdded += len(d.AddedRules)
+		p.Summary.RulesRemoved += len(d.RemovedRules)
+	}
+	return p
+}
+
+// webhook delivers ruleset changes to a URL in the background. Deliveries
+// happen in order; if the queue is full, changes are dropped.
+type webhook struct {
+	url        string
+	secret     []byte
+	maxRetries int
+	// backoff is the delay before the first retry, doubling with every
+	// further one.
+	backoff time.Duration
+	client  *http.Client
+	queue   chan []byte
+}
+
+func newWebhook(cfg webhookConfig) (*webhook, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook without url")
+	}
+	w := &webhook{
+		url:        cfg.URL,
+		secret:     []byte(cfg.Secret),
+		maxRetries: 3,
+		backoff:    time.Second,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		queue:      make(chan []byte, 100),
+	}
+	if cfg.MaxRetries != nil {
+		w.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.Timeout > 0 {
+		w.client.Timeout = cfg.Timeout
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *webhook) rulesetChanged(change rulesetChange) {
+	body, err := json.Marshal(newWebhookPayload(change))
+	if err != nil {
+		log.Errorf("Encoding webhook payload: %s", err)
+		return
+	}
+	select {
+	case w.queue <- body:
+	default:
+		log.Warnf("Dropping ruleset change notification to %s: too many pending", w.url)
+		webhookNotifications.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (w *webhook) run() {
+	for body := range w.queue {
+		backoff := w.backoff
+		for attempt := 0; ; attempt++ {
+			retry, err := w.deliver(body)
+			if err == nil {
+				webhookNotifications.WithLabelValues("success").Inc()
+				break
+			}
+			if !retry || attempt >= w.maxRetries {
+				log.Errorf("Notifying %s of ruleset change: %s", w.url, err)
+				webhookNotifications.WithLabelValues("failure").Inc()
+				break
+			}
+			log.Debugf("Notifying %s of ruleset change, retrying in %s: %s", w.url, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// deliver posts body once. It reports whether a failure is worth retrying.
+func (w *webhook) deliver(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("server returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("server returned %s", resp.Status)
+	}
+}
+
+func signPayload(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
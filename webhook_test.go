@@ -0,0 +1,136 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		statuses []int
+		attempts int
+		result   string
+	}{
+		{name: "delivered", statuses: []int{http.StatusOK}, attempts: 1, result: "success"},
+		{
+			name:     "retried",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent},
+			attempts: 3,
+			result:   "success",
+		},
+		{
+			name:     "retries exhausted",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			attempts: 3,
+			result:   "failure",
+		},
+		// Client errors won't go away by retrying.
+		{name: "not retried", statuses: []int{http.StatusBadRequest}, attempts: 1, result: "failure"},
+	} {
+		var (
+			bodies     [][]byte
+			signatures []string
+			times      []time.Time
+		)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies = append(bodies, body)
+			signatures = append(signatures, r.Header.Get(webhookSignatureHeader))
+			times = append(times, time.Now())
+			status := http.StatusInternalServerError
+			if len(bodies) <= len(tc.statuses) {
+				status = tc.statuses[len(bodies)-1]
+			}
+			w.WriteHeader(status)
+		}))
+		w := &webhook{
+			url:        server.URL,
+			secret:     []byte("s3cret"),
+			maxRetries: 2,
+			backoff:    10 * time.Millisecond,
+			client:     server.Client(),
+			queue:      make(chan []byte, 1),
+		}
+		before := testutil.ToFloat64(webhookNotifications.WithLabelValues(tc.result))
+		w.rulesetChanged(rulesetChange{Family: iptables.IPv4, Hash: "new", PreviousHash: "old"})
+		close(w.queue)
+		// run returns once the queue is drained, after the retries.
+		w.run()
+		server.Close()
+
+		if len(bodies) != tc.attempts {
+			t.Fatalf("%s: expected %d attempts, got %d", tc.name, tc.attempts, len(bodies))
+		}
+		if after := testutil.ToFloat64(webhookNotifications.WithLabelValues(tc.result)); after != before+1 {
+			t.Fatalf("%s: expected one more %s notification, got %v", tc.name, tc.result, after-before)
+		}
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(bodies[0])
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		for i, signature := range signatures {
+			if signature != expected {
+				t.Fatalf("%s: attempt %d: expected signature %q, got %q", tc.name, i, expected, signature)
+			}
+			if string(bodies[i]) != string(bodies[0]) {
+				t.Fatalf("%s: attempt %d: body changed: %s", tc.name, i, bodies[i])
+			}
+		}
+		// The delay before every retry doubles.
+		for i := 1; i < len(times); i++ {
+			if min := w.backoff << uint(i-1); times[i].Sub(times[i-1]) < min {
+				t.Fatalf("%s: retry %d after %s, expected at least %s", tc.name, i, times[i].Sub(times[i-1]), min)
+			}
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(bodies[0], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Family != iptables.IPv4 || payload.Hash != "new" || payload.PreviousHash != "old" {
+			t.Fatalf("%s: unexpected payload %s", tc.name, strings.TrimSpace(string(bodies[0])))
+		}
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	var signature []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header[webhookSignatureHeader]
+	}))
+	defer server.Close()
+	w := &webhook{url: server.URL, client: server.Client()}
+	if _, err := w.deliver([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if signature != nil {
+		t.Fatalf("expected no signature without secret, got %q", signature)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+var worldOpenRulesDesc = prometheus.NewDesc(
+	"iptables_world_open_rules",
+	"iptables_exporter: Number of rules accepting new connections from any source to a sensitive port.",
+	[]string{"table", "chain", "port", "ip_family"},
+	nil,
+)
+
+// parseWorldOpenPorts parses the values of --iptables.world-open-ports.
+func parseWorldOpenPorts(values []string) ([]int, error) {
+	var ports []int
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			if s == "" {
+				continue
+			}
+			port, err := strconv.Atoi(s)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("invalid port %q", s)
+			}
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// worldOpen reports whether rule accepts new connections to port from every
+// source address. Rules restricted to the loopback interface, to IP sets or
+// address ranges, or to already established connections don't count.
+func worldOpen(spec iptables.RuleSpec, port int) bool {
+	if spec.Target != "ACCEPT" {
+		return false
+	}
+	switch spec.Source {
+	case "", "0.0.0.0/0", "::/0":
+	default:
+		return false
+	}
+	if spec.InInterface == "lo" {
+		return false
+	}
+	switch spec.Proto {
+	case "", "all", "tcp", "udp", "sctp", "udplite", "dccp":
+	default:
+		return false
+	}
+	if spec.HasMatch("set") || spec.HasMatch("iprange") {
+		return false
+	}
+	for _, flag := range []string{"--ctstate", "--state"} {
+		if o, ok := spec.Option(flag); ok && !o.Negated && !strings.Contains(o.Value(), "NEW") {
+			return false
+		}
+	}
+	return iptables.PortsContain(spec.DPort, port)
+}
+
+// inbound reports whether any of hooks sees traffic from other hosts.
+func inbound(hooks []string) bool {
+	for _, hook := range hooks {
+		if hook == "input" || hook == "forward" {
+			return true
+		}
+	}
+	return false
+}
+
+// collectWorldOpen exports the number of world-open rules per chain and
+// sensitive port of the filter table. Only chains reachable from INPUT or
+// FORWARD are considered.
+func (c *collector) collectWorldOpen(metricChan chan<- prometheus.Metric, family iptables.Family, tables iptables.Tables) {
+	if len(c.worldOpenPorts) == 0 {
+		return
+	}
+	filter := tables["filter"]
+	hooks := filter.Hooks()
+	for chainName, chain := range filter {
+		if !inbound(hooks[chainName]) {
+			continue
+		}
+		counts := make(map[int]int)
+		for _, rule := range chain.Rules {
+			spec := rule.Spec()
+			for _, port := range c.worldOpenPorts {
+				if worldOpen(spec, port) {
+					counts[port]++
+				}
+			}
+		}
+		for port, count := range counts {
+			metricChan <- prometheus.MustNewConstMetric(
+				worldOpenRulesDesc,
+				prometheus.GaugeValue,
+				float64(count),
+				"filter",
+				chainName,
+				strconv.Itoa(port),
+				string(family),
+			)
+		}
+	}
+}
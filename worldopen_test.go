@@ -0,0 +1,64 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/steigr/iptables_exporter/iptables"
+)
+
+func TestWorldOpen(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		port int
+		open bool
+	}{
+		{text: "-p tcp -m tcp --dport 22 -j ACCEPT", port: 22, open: true},
+		{text: "-s 0.0.0.0/0 -p tcp -m tcp --dport 22 -j ACCEPT", port: 22, open: true},
+		{text: "-p tcp -m multiport --dports 80,443,3389 -j ACCEPT", port: 3389, open: true},
+		{text: "-p tcp -m tcp --dport 3000:4000 -j ACCEPT", port: 3389, open: true},
+		{text: "-j ACCEPT", port: 22, open: true},
+		{text: "-p tcp -m tcp --dport 22 -m conntrack --ctstate NEW -j ACCEPT", port: 22, open: true},
+		{text: "-p tcp -m tcp --dport 80 -j ACCEPT", port: 22, open: false},
+		{text: "-p tcp -m tcp --dport 22 -j DROP", port: 22, open: false},
+		{text: "-s 10.0.0.0/8 -p tcp -m tcp --dport 22 -j ACCEPT", port: 22, open: false},
+		{text: "-i lo -j ACCEPT", port: 22, open: false},
+		{text: "-p icmp -j ACCEPT", port: 22, open: false},
+		{text: "-p tcp -m set --match-set admins src -m tcp --dport 22 -j ACCEPT", port: 22, open: false},
+		{text: "-p tcp -m iprange --src-range 10.0.0.1-10.0.0.9 -m tcp --dport 22 -j ACCEPT", port: 22, open: false},
+		{text: "-m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT", port: 22, open: false},
+	} {
+		if open := worldOpen(iptables.ParseRuleSpec(tc.text), tc.port); open != tc.open {
+			t.Fatalf("%s, port %d: expected %v, got %v", tc.text, tc.port, tc.open, open)
+		}
+	}
+}
+
+func TestParseWorldOpenPorts(t *testing.T) {
+	ports, err := parseWorldOpenPorts([]string{"22,3389", "5432", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch := deep.Equal([]int{22, 3389, 5432}, ports); mismatch != nil {
+		t.Fatalf("%+v", mismatch)
+	}
+	for _, value := range []string{"ssh", "0", "65536"} {
+		if _, err := parseWorldOpenPorts([]string{value}); err == nil {
+			t.Fatalf("%s: expected an error", value)
+		}
+	}
+}